@@ -12,7 +12,7 @@ import (
 func main() {
 	logger.Init()
 
-	closeSt, err := storage.InitFromEnv()
+	db, err := storage.InitFromEnv()
 	if err != nil {
 		slog.Error(
 			"Failed to initialize storage",
@@ -21,11 +21,18 @@ func main() {
 
 		return
 	}
-	defer closeSt()
+	defer db.Close()
 
-	app := orchestrator.New()
+	instanceID := storage.NewInstanceID()
+	o := orchestrator.New(
+		orchestrator.ConfigFromEnv(),
+		orchestrator.Dependencies{
+			InstanceID: instanceID,
+			Repos:      orchestrator.NewRepositories(db, instanceID),
+		},
+	)
 
-	err = app.Serve()
+	err = o.Serve()
 	if err != nil {
 		defer os.Exit(1)
 	}
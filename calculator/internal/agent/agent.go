@@ -41,19 +41,40 @@ func (a *Agent) Run() {
 	}(conn)
 
 	a.client = OrchestratorClient(conn)
+	a.registerUntilDone()
 
 	a.run()
 }
 
 func (a *Agent) run() {
+	go func() {
+		if a.config.Streaming {
+			err := a.runStreaming()
+			slog.Warn(
+				"orchestrator does not support streaming, polling instead",
+				"error", err,
+			)
+		}
+
+		err := a.runBatchPolling()
+		slog.Warn(
+			"orchestrator does not support batches, polling per worker",
+			"error", err,
+		)
+
+		a.runPolling()
+	}()
+
+	waitUntilTermination()
+}
+
+func (a *Agent) runPolling() {
 	for range a.config.TotalWorkers {
 		// Запускаем вокреров с небольшой задержкой,
 		// так будем более равномерно обращаться к оркестратору
 		go a.runWorker()
 		time.Sleep(workerStartDelay)
 	}
-
-	waitUntilTermination()
 }
 
 const pollingInterval = 400 * time.Millisecond
@@ -78,6 +99,8 @@ func (a *Agent) runWorker() {
 				)
 			}
 
+			a.reregisterIfForgotten(err)
+
 			// No tasks available, sleep for a while
 			time.Sleep(pollingInterval)
 
@@ -89,7 +112,7 @@ func (a *Agent) runWorker() {
 			"taskId", task.Id,
 			"workerId", worker.id,
 		)
-		worker.processTask(task)
+		worker.processTask(task, worker.sendTaskResult)
 	}
 }
 
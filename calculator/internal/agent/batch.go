@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// idleWorkers возвращает пул свободных воркеров.
+func (a *Agent) idleWorkers() chan *agentWorker {
+	idle := make(chan *agentWorker, a.config.TotalWorkers)
+	for range a.config.TotalWorkers {
+		idle <- a.newAgentWorker()
+	}
+
+	return idle
+}
+
+// runBatchPolling опрашивает оркестратор одним вызовом GetTasks
+// сразу на всех свободных воркеров, а результаты отправляет пачками
+// через AddResults. Возвращает ошибку, только если оркестратор
+// пакетные вызовы не поддерживает.
+func (a *Agent) runBatchPolling() error {
+	idle := a.idleWorkers()
+
+	// Канал не закрываем: после перехода на другой режим опроса
+	// в него еще могут писать воркеры, занятые вычислением
+	results := make(chan *pb.TaskResult, a.config.TotalWorkers)
+	go a.flushResults(results)
+
+	enqueueResult := func(result *pb.TaskResult) error {
+		results <- result
+		return nil
+	}
+
+	for {
+		// Ждем хотя бы одного свободного воркера и забираем остальных
+		workers := []*agentWorker{<-idle}
+
+	collect:
+		for {
+			select {
+			case worker := <-idle:
+				workers = append(workers, worker)
+			default:
+				break collect
+			}
+		}
+
+		tasks, err := a.getTasks(len(workers))
+		if err != nil {
+			for _, worker := range workers {
+				idle <- worker
+			}
+
+			code := status.Code(err)
+			if code == codes.Unimplemented {
+				return err
+			}
+
+			a.reregisterIfForgotten(err)
+
+			if code != codes.ResourceExhausted {
+				slog.Error(
+					"unexpected error while getting tasks",
+					"error", err,
+				)
+			}
+
+			// No tasks available, sleep for a while
+			time.Sleep(pollingInterval)
+
+			continue
+		}
+
+		for i, worker := range workers {
+			if i >= len(tasks) {
+				idle <- worker
+				continue
+			}
+
+			task := tasks[i]
+
+			slog.Info(
+				"got a taskToProcess from the orchestrator",
+				"taskId", task.Id,
+				"workerId", worker.id,
+			)
+
+			go func() {
+				worker.processTask(task, enqueueResult)
+				idle <- worker
+			}()
+		}
+	}
+}
+
+// flushResults отправляет результаты, накопившиеся к моменту отправки,
+// одним вызовом AddResults.
+func (a *Agent) flushResults(results <-chan *pb.TaskResult) {
+	for result := range results {
+		batch := []*pb.TaskResult{result}
+
+	collect:
+		for {
+			select {
+			case result := <-results:
+				batch = append(batch, result)
+			default:
+				break collect
+			}
+		}
+
+		resp, err := a.addResults(batch)
+		if err != nil {
+			slog.Error(
+				"failed to send task results",
+				"count", len(batch),
+				"error", err,
+			)
+
+			continue
+		}
+
+		for _, rejected := range resp.Rejected {
+			slog.Warn(
+				"orchestrator rejected a task result",
+				"taskId", rejected.Id,
+				"code", codes.Code(rejected.Code).String(),
+				"error", rejected.Message,
+			)
+		}
+	}
+}
+
+func (a *Agent) getTasks(count int) ([]*pb.TaskToProcess, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := a.client.GetTasks(ctx, &pb.GetTasksRequest{
+		MaxCount: uint32(count), //nolint:gosec
+		AgentId:  a.agentID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Tasks, nil
+}
+
+func (a *Agent) addResults(
+	results []*pb.TaskResult,
+) (*pb.AddResultsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	return a.client.AddResults(ctx, &pb.TaskResults{Results: results})
+}
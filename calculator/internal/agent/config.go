@@ -1,7 +1,11 @@
 package agent
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/dzherb/go_calculator/calculator/internal/pkg"
 )
@@ -10,6 +14,17 @@ type Config struct {
 	orchestratorHost string
 	orchestratorPort string
 	TotalWorkers     int
+	Streaming        bool
+	AgentID          string
+	Operators        []string // Пустой список — все операторы
+	Token            string
+	// TLS включается, если задан CA оркестратора, сертификат агента
+	// или имя сервера
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	// Имя в сертификате оркестратора, если оно отличается от хоста
+	TLSServerName string
 }
 
 func ConfigFromEnv() *Config {
@@ -27,5 +42,38 @@ func ConfigFromEnv() *Config {
 	workers := common.EnvOrDefault("AGENT_COMPUTING_POWER", "4")
 	config.TotalWorkers, _ = strconv.Atoi(workers)
 
+	streaming := common.EnvOrDefault("AGENT_STREAMING", "true")
+	config.Streaming, _ = strconv.ParseBool(streaming)
+
+	config.AgentID = common.EnvOrDefault("AGENT_ID", defaultAgentID())
+
+	if operators := common.EnvOrDefault("AGENT_OPERATORS", ""); operators != "" {
+		config.Operators = strings.Split(operators, ",")
+	}
+
+	config.Token = common.EnvOrDefault("AGENT_TOKEN", "")
+
+	config.TLSCAFile = common.EnvOrDefault("ORCHESTRATOR_TLS_CA_FILE", "")
+	config.TLSCertFile = common.EnvOrDefault("AGENT_TLS_CERT_FILE", "")
+	config.TLSKeyFile = common.EnvOrDefault("AGENT_TLS_KEY_FILE", "")
+	config.TLSServerName = common.EnvOrDefault(
+		"ORCHESTRATOR_TLS_SERVER_NAME",
+		"",
+	)
+
 	return config
 }
+
+// defaultAgentID составляет id из имени хоста и случайного суффикса,
+// чтобы несколько агентов на одной машине не путались.
+func defaultAgentID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "agent"
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
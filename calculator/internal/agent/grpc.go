@@ -1,8 +1,12 @@
 package agent
 
 import (
+	"context"
+
 	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"github.com/dzherb/go_calculator/calculator/internal/pkg"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -13,11 +17,13 @@ type OrchestratorConn struct {
 
 func NewOrchestratorConn(cfg *Config) (*OrchestratorConn, error) {
 	addr := cfg.orchestratorHost + ":" + cfg.orchestratorPort
-	conn, err := grpc.NewClient(
-		addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
 
+	opts, err := dialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.NewClient(addr, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +33,48 @@ func NewOrchestratorConn(cfg *Config) (*OrchestratorConn, error) {
 	return &OrchestratorConn{conn: conn, closeFn: conn.Close}, nil
 }
 
+func dialOptions(cfg *Config) ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+
+	if cfg.TLSCAFile != "" || cfg.TLSCertFile != "" || cfg.TLSServerName != "" {
+		tlsConfig, err := common.ClientTLSConfig(
+			cfg.TLSCAFile,
+			cfg.TLSCertFile,
+			cfg.TLSKeyFile,
+			cfg.TLSServerName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	if cfg.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(agentToken(cfg.Token)))
+	}
+
+	return opts, nil
+}
+
+// agentToken передает токен агента с каждым вызовом.
+type agentToken string
+
+func (t agentToken) GetRequestMetadata(
+	context.Context,
+	...string,
+) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity разрешает токен и без TLS,
+// например внутри сети docker compose.
+func (t agentToken) RequireTransportSecurity() bool {
+	return false
+}
+
 func (c *OrchestratorConn) Close() error {
 	return c.closeFn()
 }
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Version подставляется при сборке через -ldflags "-X <пакет>.Version=...".
+var Version = "dev"
+
+// registerUntilDone представляет агента оркестратору, пока это
+// не получится. Старый оркестратор регистрацию не поддерживает,
+// тогда агент работает без идентификатора и получает любые задачи.
+func (a *Agent) registerUntilDone() {
+	for {
+		resp, err := a.register()
+		if err == nil {
+			a.agentID = a.config.AgentID
+
+			if interval := resp.HeartbeatInterval.AsDuration(); interval > 0 {
+				go a.heartbeat(interval)
+			}
+
+			return
+		}
+
+		if status.Code(err) == codes.Unimplemented {
+			slog.Warn("orchestrator does not support agent registration")
+			return
+		}
+
+		slog.Error("failed to register the agent", "error", err)
+		time.Sleep(reconnectDelay)
+	}
+}
+
+func (a *Agent) register() (*pb.RegisterAgentResponse, error) {
+	hostname, _ := os.Hostname()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := a.client.RegisterAgent(ctx, &pb.RegisterAgentRequest{
+		AgentId:   a.config.AgentID,
+		Hostname:  hostname,
+		Version:   Version,
+		Workers:   uint32(a.config.TotalWorkers), //nolint:gosec
+		Operators: a.config.Operators,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("registered the agent", "agentId", a.config.AgentID)
+
+	return resp, nil
+}
+
+// reregisterIfForgotten регистрирует агента заново, если оркестратор
+// его не знает, например после перезапуска.
+func (a *Agent) reregisterIfForgotten(err error) {
+	if a.agentID == "" || status.Code(err) != codes.NotFound {
+		return
+	}
+
+	if _, err = a.register(); err != nil {
+		slog.Error("failed to register the agent again", "error", err)
+	}
+}
+
+// heartbeat сообщает оркестратору, что агент жив, даже когда все
+// воркеры заняты долгими задачами и к оркестратору не обращаются.
+func (a *Agent) heartbeat(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := a.sendHeartbeat()
+		if err == nil {
+			continue
+		}
+
+		slog.Error("failed to send a heartbeat", "error", err)
+		a.reregisterIfForgotten(err)
+	}
+}
+
+func (a *Agent) sendHeartbeat() error {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	_, err := a.client.Heartbeat(ctx, &pb.HeartbeatRequest{
+		AgentId: a.agentID,
+	})
+
+	return err
+}
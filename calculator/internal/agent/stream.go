@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const reconnectDelay = 2 * time.Second
+
+// workSession — открытый стрим Work. Отправлять в стрим
+// можно только из одной горутины за раз.
+type workSession struct {
+	stream  pb.TaskService_WorkClient
+	agentID string
+	mu      sync.Mutex
+}
+
+func (s *workSession) send(req *pb.WorkRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stream.Send(req)
+}
+
+func (s *workSession) sendCapacity(capacity int) error {
+	return s.send(&pb.WorkRequest{
+		Message: &pb.WorkRequest_Capacity{
+			Capacity: uint32(capacity), //nolint:gosec
+		},
+		AgentId: s.agentID,
+	})
+}
+
+func (s *workSession) sendTaskResult(result *pb.TaskResult) error {
+	return s.send(&pb.WorkRequest{
+		Message: &pb.WorkRequest_Result{Result: result},
+	})
+}
+
+func (a *Agent) sendStreamResult(result *pb.TaskResult) error {
+	return a.session.Load().sendTaskResult(result)
+}
+
+// runStreaming получает задачи по стриму Work и переподключается,
+// если стрим оборвался. Возвращает ошибку, только если оркестратор
+// стриминг не поддерживает.
+func (a *Agent) runStreaming() error {
+	idle := a.idleWorkers()
+
+	for {
+		err := a.work(idle)
+		if status.Code(err) == codes.Unimplemented {
+			return err
+		}
+
+		slog.Error(
+			"work stream is broken, reconnecting",
+			"error", err,
+		)
+
+		a.reregisterIfForgotten(err)
+
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// work обслуживает один стрим: сообщает оркестратору, сколько
+// воркеров свободно, и раздает им присланные задачи.
+func (a *Agent) work(idle chan *agentWorker) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := a.client.Work(ctx)
+	if err != nil {
+		return err
+	}
+
+	session := &workSession{stream: stream, agentID: a.agentID}
+	a.session.Store(session)
+
+	err = session.sendCapacity(len(idle))
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if cancel := resp.GetCancel(); cancel != nil {
+			a.cancelTask(cancel.TaskId)
+			continue
+		}
+
+		task := resp.GetTask()
+		if task == nil {
+			continue
+		}
+
+		// Оркестратор не присылает больше задач, чем свободно воркеров
+		worker := <-idle
+
+		slog.Info(
+			"got a taskToProcess from the orchestrator stream",
+			"taskId", task.Id,
+			"workerId", worker.id,
+		)
+
+		// Стрим мог переподключиться, пока задача вычислялась,
+		// поэтому отвечаем в актуальный
+		go func() {
+			worker.processTask(task, a.sendStreamResult)
+
+			idle <- worker
+
+			err := a.session.Load().sendCapacity(1)
+			if err != nil {
+				slog.Error(
+					"failed to report free capacity",
+					"workerId", worker.id,
+					"error", err,
+				)
+			}
+		}()
+	}
+}
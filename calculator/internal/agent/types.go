@@ -1,10 +1,18 @@
 package agent
 
-import pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+import (
+	"sync"
+	"sync/atomic"
+
+	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+)
 
 type Agent struct {
-	config *Config
-	client pb.TaskServiceClient
+	config  *Config
+	client  pb.TaskServiceClient
+	session atomic.Pointer[workSession] // Текущий стрим Work
+	agentID string                      // Пустой, если агент не зарегистрирован
+	running sync.Map                    // id задачи -> отмена ее вычисления
 }
 
 type agentWorker struct {
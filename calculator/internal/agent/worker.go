@@ -7,21 +7,49 @@ import (
 	"time"
 
 	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (w *agentWorker) client() pb.TaskServiceClient {
 	return w.agent.client
 }
 
-func (w *agentWorker) processTask(task *pb.TaskToProcess) {
-	res, err := compute(task)
+func (w *agentWorker) processTask(
+	task *pb.TaskToProcess,
+	sendTaskResult func(*pb.TaskResult) error,
+) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	w.agent.running.Store(task.Id, stop)
+	defer w.agent.running.Delete(task.Id)
+
+	go w.keepLease(ctx, task)
+
+	res, kind, err := computeSafely(ctx, task)
+	if ctx.Err() != nil {
+		slog.Info(
+			"task was canceled by the orchestrator",
+			"taskId", task.Id,
+			"workerId", w.id,
+		)
+
+		return
+	}
+
+	// Больше не продлеваем аренду
+	stop()
+
 	resResp := &pb.TaskResult{
-		Id:     task.Id,
-		Result: res,
+		Id:      task.Id,
+		Result:  res,
+		AgentId: w.agent.agentID,
 	}
 
 	if err != nil {
 		resResp.Error = err.Error()
+		resResp.ErrorKind = kind
 
 		slog.Info(
 			"Failed to compute a taskToProcess",
@@ -29,12 +57,13 @@ func (w *agentWorker) processTask(task *pb.TaskToProcess) {
 			"workerId", w.id,
 		)
 
-		err = w.sendTaskResult(resResp)
+		err = sendTaskResult(resResp)
 		if err != nil {
 			slog.Info(
 				"failed to send task result",
 				"taskId", task.Id,
 				"workerId", w.id,
+				"error", err,
 			)
 
 			return
@@ -43,12 +72,13 @@ func (w *agentWorker) processTask(task *pb.TaskToProcess) {
 		return
 	}
 
-	err = w.sendTaskResult(resResp)
+	err = sendTaskResult(resResp)
 	if err != nil {
 		slog.Info(
 			"failed to send task result",
 			"taskId", task.Id,
 			"workerId", w.id,
+			"error", err,
 		)
 
 		return
@@ -64,13 +94,83 @@ func (w *agentWorker) processTask(task *pb.TaskToProcess) {
 
 const grpcTimeout = time.Second * 10
 
+// Не продлеваем аренду чаще, чем раз в этот интервал.
+const minLeaseRenewInterval = 100 * time.Millisecond
+
+// keepLease продлевает аренду задачи, пока она вычисляется.
+// Аренда продлевается заранее, когда прошла половина оставшегося срока.
+func (w *agentWorker) keepLease(ctx context.Context, task *pb.TaskToProcess) {
+	if task.LeaseDeadline == nil {
+		return
+	}
+
+	deadline := task.LeaseDeadline.AsTime()
+
+	for {
+		wait := max(time.Until(deadline)/2, minLeaseRenewInterval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		lease, err := w.renewLease(task.Id)
+		if err != nil {
+			code := status.Code(err)
+			if code == codes.FailedPrecondition || code == codes.NotFound {
+				slog.Warn(
+					"lost the task lease",
+					"taskId", task.Id,
+					"workerId", w.id,
+				)
+
+				return
+			}
+
+			slog.Error(
+				"failed to renew the task lease",
+				"taskId", task.Id,
+				"workerId", w.id,
+				"error", err,
+			)
+
+			continue
+		}
+
+		deadline = lease.Deadline.AsTime()
+	}
+}
+
+// cancelTask прерывает вычисление задачи, которая больше не нужна
+// оркестратору.
+func (a *Agent) cancelTask(taskId uint64) {
+	stop, ok := a.running.Load(taskId)
+	if !ok {
+		return
+	}
+
+	stop.(context.CancelFunc)()
+}
+
+func (w *agentWorker) renewLease(taskId uint64) (*pb.Lease, error) {
+	client := w.client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	return client.RenewLease(ctx, &pb.RenewLeaseRequest{TaskId: taskId})
+}
+
 func (w *agentWorker) getTask() (*pb.TaskToProcess, error) {
 	client := w.client()
 
 	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
 	defer cancel()
 
-	task, err := client.GetTask(ctx, &pb.GetTaskRequest{})
+	task, err := client.GetTask(ctx, &pb.GetTaskRequest{
+		AgentId: w.agent.agentID,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -92,8 +192,34 @@ func (w *agentWorker) sendTaskResult(task *pb.TaskResult) error {
 	return nil
 }
 
-func compute(task *pb.TaskToProcess) (float64, error) {
-	time.Sleep(time.Duration(task.OperationTime))
+// computeSafely не дает упавшему вычислению уронить воркер.
+// Паника считается инфраструктурной ошибкой, такую задачу оркестратор
+// повторит, а ошибки в самой операции — математическими.
+func computeSafely(
+	ctx context.Context,
+	task *pb.TaskToProcess,
+) (res float64, kind pb.ErrorKind, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			kind = pb.ErrorKind_ERROR_KIND_INFRASTRUCTURE
+			err = fmt.Errorf("worker panicked: %v", r)
+		}
+	}()
+
+	res, err = compute(ctx, task)
+	if err != nil {
+		kind = pb.ErrorKind_ERROR_KIND_MATH
+	}
+
+	return res, kind, err
+}
+
+func compute(ctx context.Context, task *pb.TaskToProcess) (float64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(time.Duration(task.OperationTime)):
+	}
 
 	var result float64
 
@@ -20,14 +20,21 @@ type AccessPayload struct {
 	User  repo.User `json:"user"`
 }
 
-func NewService() Service {
+func NewService(
+	users repo.UserRepository,
+	tokens *security.TokenManager,
+) Service {
 	return &ServiceImpl{
+		users:   users,
+		tokens:  tokens,
 		userVal: DefaultUsernameValidator,
 		passVal: DefaultPasswordValidator,
 	}
 }
 
 type ServiceImpl struct {
+	users   repo.UserRepository
+	tokens  *security.TokenManager
 	userVal Validator
 	passVal Validator
 }
@@ -41,9 +48,7 @@ func (s *ServiceImpl) Login(username, password string) (AccessPayload, error) {
 		return AccessPayload{}, fmt.Errorf(errFmt, err)
 	}
 
-	ur := repo.NewUserRepository()
-
-	user, err := ur.GetByCredentials(username, password)
+	user, err := s.users.GetByCredentials(username, password)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			err = fmt.Errorf("invalid credentials")
@@ -66,9 +71,7 @@ func (s *ServiceImpl) Register(
 		return AccessPayload{}, err
 	}
 
-	ur := repo.NewUserRepository()
-
-	user, err := ur.Create(repo.User{
+	user, err := s.users.Create(repo.User{
 		Username: username,
 		Password: password,
 	})
@@ -100,7 +103,7 @@ func (s *ServiceImpl) issueToken(
 	user repo.User,
 	context string,
 ) (AccessPayload, error) {
-	token, err := security.IssueAccessToken(user.ID)
+	token, err := s.tokens.IssueAccessToken(user.ID)
 	if err != nil {
 		return AccessPayload{}, fmt.Errorf(context, err)
 	}
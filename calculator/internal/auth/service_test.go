@@ -9,24 +9,29 @@ import (
 	"github.com/dzherb/go_calculator/calculator/internal/repository"
 	"github.com/dzherb/go_calculator/calculator/internal/storage"
 	"github.com/dzherb/go_calculator/calculator/pkg/security"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func TestMain(m *testing.M) {
-	security.Init(security.Config{
-		SecretKey:      "secret",
-		AccessTokenTTL: time.Hour,
-	})
+var tokens = security.NewTokenManager(security.Config{
+	SecretKey:      "secret",
+	AccessTokenTTL: time.Hour,
+})
 
-	code := storage.RunTestsWithTempDB(func() int {
-		return storage.RunTestsWithMigratedDB(m.Run)
+// testDB — база, которую тестам пакета поднимает TestMain.
+var testDB *pgxpool.Pool
+
+func TestMain(m *testing.M) {
+	code := storage.RunTestsWithTempDB(func(db *pgxpool.Pool) int {
+		testDB = db
+		return storage.RunTestsWithMigratedDB(db, m.Run)
 	})
 	os.Exit(code)
 }
 
 func TestLogin(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 	user, err := ur.Create(repo.User{
 		Username: "test",
 		Password: "test_pass",
@@ -36,7 +41,7 @@ func TestLogin(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	as := auth.NewService()
+	as := auth.NewService(repo.NewUserRepository(db), tokens)
 
 	got, err := as.Login(user.Username, "test_pass")
 	if err != nil {
@@ -49,7 +54,7 @@ func TestLogin(t *testing.T) {
 		return
 	}
 
-	userID, err := security.ValidateToken(got.Token)
+	userID, err := tokens.ValidateToken(got.Token)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 		return
@@ -61,9 +66,9 @@ func TestLogin(t *testing.T) {
 }
 
 func TestRegister(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	as := auth.NewService()
+	as := auth.NewService(repo.NewUserRepository(db), tokens)
 
 	res, err := as.Register("user", "strongPass11")
 	if err != nil {
@@ -71,7 +76,7 @@ func TestRegister(t *testing.T) {
 		return
 	}
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	userFromDB, err := ur.Get(res.User.ID)
 	if err != nil {
@@ -83,7 +88,7 @@ func TestRegister(t *testing.T) {
 		t.Errorf("got user %v, want %v", userFromDB, res.User)
 	}
 
-	userID, err := security.ValidateToken(res.Token)
+	userID, err := tokens.ValidateToken(res.Token)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 		return
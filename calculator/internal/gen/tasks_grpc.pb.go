@@ -19,16 +19,40 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	TaskService_GetTask_FullMethodName   = "/tasks.TaskService/GetTask"
-	TaskService_AddResult_FullMethodName = "/tasks.TaskService/AddResult"
+	TaskService_RegisterAgent_FullMethodName = "/tasks.TaskService/RegisterAgent"
+	TaskService_Heartbeat_FullMethodName     = "/tasks.TaskService/Heartbeat"
+	TaskService_GetTask_FullMethodName       = "/tasks.TaskService/GetTask"
+	TaskService_AddResult_FullMethodName     = "/tasks.TaskService/AddResult"
+	TaskService_GetTasks_FullMethodName      = "/tasks.TaskService/GetTasks"
+	TaskService_AddResults_FullMethodName    = "/tasks.TaskService/AddResults"
+	TaskService_RenewLease_FullMethodName    = "/tasks.TaskService/RenewLease"
+	TaskService_Work_FullMethodName          = "/tasks.TaskService/Work"
 )
 
 // TaskServiceClient is the client API for TaskService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TaskServiceClient interface {
+	// Introduces the agent. Agents that registered get only tasks
+	// with operators they support.
+	RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error)
+	// Tells the orchestrator that a registered agent is alive.
+	// Agents that stay silent are forgotten and their tasks
+	// are handed out again.
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
 	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*TaskToProcess, error)
 	AddResult(ctx context.Context, in *TaskResult, opts ...grpc.CallOption) (*AddResultResponse, error)
+	// Hands out up to max_count tasks with a single round-trip.
+	GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*TasksToProcess, error)
+	// Accepts several results at once. Rejected results are listed
+	// in the response instead of failing the whole call.
+	AddResults(ctx context.Context, in *TaskResults, opts ...grpc.CallOption) (*AddResultsResponse, error)
+	// Extends the lease of a task that is still being computed.
+	RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*Lease, error)
+	// Long-lived alternative to polling: the agent announces free capacity,
+	// the orchestrator pushes tasks as soon as they are ready,
+	// and results flow back on the same stream.
+	Work(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[WorkRequest, WorkResponse], error)
 }
 
 type taskServiceClient struct {
@@ -39,6 +63,26 @@ func NewTaskServiceClient(cc grpc.ClientConnInterface) TaskServiceClient {
 	return &taskServiceClient{cc}
 }
 
+func (c *taskServiceClient) RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterAgentResponse)
+	err := c.cc.Invoke(ctx, TaskService_RegisterAgent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, TaskService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *taskServiceClient) GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*TaskToProcess, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(TaskToProcess)
@@ -59,12 +103,73 @@ func (c *taskServiceClient) AddResult(ctx context.Context, in *TaskResult, opts
 	return out, nil
 }
 
+func (c *taskServiceClient) GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*TasksToProcess, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TasksToProcess)
+	err := c.cc.Invoke(ctx, TaskService_GetTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) AddResults(ctx context.Context, in *TaskResults, opts ...grpc.CallOption) (*AddResultsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddResultsResponse)
+	err := c.cc.Invoke(ctx, TaskService_AddResults_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*Lease, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, TaskService_RenewLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) Work(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[WorkRequest, WorkResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TaskService_ServiceDesc.Streams[0], TaskService_Work_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WorkRequest, WorkResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskService_WorkClient = grpc.BidiStreamingClient[WorkRequest, WorkResponse]
+
 // TaskServiceServer is the server API for TaskService service.
 // All implementations must embed UnimplementedTaskServiceServer
 // for forward compatibility.
 type TaskServiceServer interface {
+	// Introduces the agent. Agents that registered get only tasks
+	// with operators they support.
+	RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error)
+	// Tells the orchestrator that a registered agent is alive.
+	// Agents that stay silent are forgotten and their tasks
+	// are handed out again.
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	GetTask(context.Context, *GetTaskRequest) (*TaskToProcess, error)
 	AddResult(context.Context, *TaskResult) (*AddResultResponse, error)
+	// Hands out up to max_count tasks with a single round-trip.
+	GetTasks(context.Context, *GetTasksRequest) (*TasksToProcess, error)
+	// Accepts several results at once. Rejected results are listed
+	// in the response instead of failing the whole call.
+	AddResults(context.Context, *TaskResults) (*AddResultsResponse, error)
+	// Extends the lease of a task that is still being computed.
+	RenewLease(context.Context, *RenewLeaseRequest) (*Lease, error)
+	// Long-lived alternative to polling: the agent announces free capacity,
+	// the orchestrator pushes tasks as soon as they are ready,
+	// and results flow back on the same stream.
+	Work(grpc.BidiStreamingServer[WorkRequest, WorkResponse]) error
 	mustEmbedUnimplementedTaskServiceServer()
 }
 
@@ -75,12 +180,30 @@ type TaskServiceServer interface {
 // pointer dereference when methods are called.
 type UnimplementedTaskServiceServer struct{}
 
+func (UnimplementedTaskServiceServer) RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedTaskServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedTaskServiceServer) GetTask(context.Context, *GetTaskRequest) (*TaskToProcess, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
 }
 func (UnimplementedTaskServiceServer) AddResult(context.Context, *TaskResult) (*AddResultResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AddResult not implemented")
 }
+func (UnimplementedTaskServiceServer) GetTasks(context.Context, *GetTasksRequest) (*TasksToProcess, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTasks not implemented")
+}
+func (UnimplementedTaskServiceServer) AddResults(context.Context, *TaskResults) (*AddResultsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddResults not implemented")
+}
+func (UnimplementedTaskServiceServer) RenewLease(context.Context, *RenewLeaseRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewLease not implemented")
+}
+func (UnimplementedTaskServiceServer) Work(grpc.BidiStreamingServer[WorkRequest, WorkResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Work not implemented")
+}
 func (UnimplementedTaskServiceServer) mustEmbedUnimplementedTaskServiceServer() {}
 func (UnimplementedTaskServiceServer) testEmbeddedByValue()                     {}
 
@@ -102,6 +225,42 @@ func RegisterTaskServiceServer(s grpc.ServiceRegistrar, srv TaskServiceServer) {
 	s.RegisterService(&TaskService_ServiceDesc, srv)
 }
 
+func _TaskService_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_RegisterAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).RegisterAgent(ctx, req.(*RegisterAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _TaskService_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetTaskRequest)
 	if err := dec(in); err != nil {
@@ -138,6 +297,67 @@ func _TaskService_AddResult_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TaskService_GetTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).GetTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_GetTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).GetTasks(ctx, req.(*GetTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_AddResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskResults)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).AddResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_AddResults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).AddResults(ctx, req.(*TaskResults))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_RenewLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).RenewLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_RenewLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).RenewLease(ctx, req.(*RenewLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_Work_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TaskServiceServer).Work(&grpc.GenericServerStream[WorkRequest, WorkResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskService_WorkServer = grpc.BidiStreamingServer[WorkRequest, WorkResponse]
+
 // TaskService_ServiceDesc is the grpc.ServiceDesc for TaskService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -145,6 +365,14 @@ var TaskService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "tasks.TaskService",
 	HandlerType: (*TaskServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _TaskService_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _TaskService_Heartbeat_Handler,
+		},
 		{
 			MethodName: "GetTask",
 			Handler:    _TaskService_GetTask_Handler,
@@ -153,7 +381,26 @@ var TaskService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AddResult",
 			Handler:    _TaskService_AddResult_Handler,
 		},
+		{
+			MethodName: "GetTasks",
+			Handler:    _TaskService_GetTasks_Handler,
+		},
+		{
+			MethodName: "AddResults",
+			Handler:    _TaskService_AddResults_Handler,
+		},
+		{
+			MethodName: "RenewLease",
+			Handler:    _TaskService_RenewLease_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Work",
+			Handler:       _TaskService_Work_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "tasks.proto",
 }
@@ -0,0 +1,138 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+// isAdmin проверяет, есть ли пользователь в списке ADMIN_USERNAMES.
+func (o *Orchestrator) isAdmin(userID uint64) (bool, error) {
+	if len(o.config.AdminUsernames) == 0 {
+		return false, nil
+	}
+
+	user, err := o.repos.Users.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(o.config.AdminUsernames, user.Username), nil
+}
+
+type AgentsResponse struct {
+	Agents []AgentStatus `json:"agents"`
+}
+
+func (o *Orchestrator) AgentsHandler(w http.ResponseWriter, _ *http.Request) {
+	err := json.NewEncoder(w).Encode(AgentsResponse{
+		Agents: o.registry.All(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// DrainAgentHandler перестает выдавать агенту новые задачи.
+// Уже выданные задачи агент может досчитать.
+func (o *Orchestrator) DrainAgentHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	agent, ok := o.registry.Drain(r.PathValue("id"))
+	if ok {
+		slog.Info("Agent is draining", "agent_id", agent.ID)
+	}
+
+	writeAgentStatus(w, agent, ok)
+}
+
+// RestoreAgentHandler снова выдает задачи агенту,
+// выведенному из работы или отправленному в карантин.
+func (o *Orchestrator) RestoreAgentHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	agent, ok := o.registry.Restore(r.PathValue("id"))
+	if ok {
+		slog.Info("Agent is restored", "agent_id", agent.ID)
+	}
+
+	writeAgentStatus(w, agent, ok)
+}
+
+func writeAgentStatus(w http.ResponseWriter, agent AgentStatus, found bool) {
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		WriteError(w, errAgentNotRegistered)
+
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(agent)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+const (
+	defaultMismatchesLimit = 100
+	maxMismatchesLimit     = 1000
+)
+
+type MismatchesResponse struct {
+	Mismatches []repo.Mismatch `json:"mismatches"`
+}
+
+// MismatchesHandler возвращает последние расхождения в ответах агентов.
+func (o *Orchestrator) MismatchesHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	limit, err := limitFromQuery(r, defaultMismatchesLimit, maxMismatchesLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, err)
+
+		return
+	}
+
+	mismatches, err := o.repos.Mismatches.Recent(limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	if mismatches == nil {
+		mismatches = []repo.Mismatch{}
+	}
+
+	err = json.NewEncoder(w).Encode(MismatchesResponse{Mismatches: mismatches})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// limitFromQuery читает параметр limit, ограничивая его сверху.
+func limitFromQuery(r *http.Request, def, maxLimit int) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, errInvalidLimit
+	}
+
+	return min(limit, maxLimit), nil
+}
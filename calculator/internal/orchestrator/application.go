@@ -2,45 +2,51 @@ package orchestrator
 
 import (
 	"context"
-
-	"github.com/dzherb/go_calculator/calculator/pkg/security"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-type App struct {
-	config *Config
-}
+func (o *Orchestrator) Serve() error {
+	// Читаем только первую ошибку: второй сервер не должен
+	// навсегда застрять на отправке своей
+	errChan := make(chan error, 2)
+	// При остановке отдаем выражения другим оркестраторам
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
 
-func New() *App {
-	cfg := ConfigFromEnv()
+	defer cancel()
 
-	security.Init(security.Config{
-		SecretKey:      cfg.SecretKey,
-		AccessTokenTTL: cfg.AccessTokenTTL,
-	})
+	slog.Info("Starting orchestrator", "instance_id", o.instanceID)
 
-	app := &App{
-		config: cfg,
+	err := o.Resume()
+	if err != nil {
+		return err
 	}
-	orchestrator.app = app
-
-	return app
-}
 
-func (a *App) Serve() error {
-	errChan := make(chan error)
-	ctx, cancel := context.WithCancel(context.Background())
-
-	defer cancel()
-
-	go NewDaemon().Start(ctx)
+	go NewDaemon(o).Start(ctx)
+	go o.leases.Run(ctx, o.now, o.reclaimExpired)
+	go o.webhooks.Run(ctx)
+	go o.listenExpressionChanges(ctx)
+	go o.listenTaskReports(ctx)
+	go o.maintainOwnership(ctx)
 
 	go func() {
-		errChan <- a.ServeGRPC(ctx)
+		errChan <- o.ServeGRPC(ctx)
 	}()
 
 	go func() {
-		errChan <- a.ServeHTTP(ctx)
+		errChan <- o.ServeHTTP(ctx)
 	}()
 
-	return <-errChan
+	err = <-errChan
+
+	cancel()
+	o.releaseOwnership()
+
+	return err
 }
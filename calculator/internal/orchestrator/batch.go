@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// maxBatchSize ограничивает число выражений в одном пакетном запросе.
+const maxBatchSize = 1000
+
+// BatchItem is an expression of a batch request. ClientID is an optional
+// client-side id that is echoed back in the result.
+type BatchItem struct {
+	ClientID   string `json:"client_id,omitempty"`
+	Expression string `json:"expression"`
+}
+
+// BatchItemResult holds either the id of the created expression
+// or the reason it was rejected.
+type BatchItemResult struct {
+	ClientID string `json:"client_id,omitempty"`
+	Id       uint64 `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CreateExpressions создает выражения пакета в одной транзакции.
+// Выражения, которые не удалось разобрать, пропускаются и не мешают
+// создать остальные. Результаты идут в порядке выражений.
+func (o *Orchestrator) CreateExpressions(
+	items []BatchItem,
+	userID uint64,
+) ([]BatchItemResult, error) {
+	results := make([]BatchItemResult, len(items))
+	parsed := make([]*calc.Expression, len(items))
+
+	for i, item := range items {
+		results[i].ClientID = item.ClientID
+
+		expr, err := calc.NewExpression(item.Expression, o.taskIDs)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		parsed[i] = expr
+	}
+
+	var toCreate []repo.Expression
+
+	for i, expr := range parsed {
+		if expr != nil {
+			toCreate = append(toCreate, o.own(repo.Expression{
+				UserID:     userID,
+				Expression: items[i].Expression,
+			}))
+		}
+	}
+
+	created, err := o.repos.Expressions.CreateMany(toCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Вычислять начинаем только после коммита,
+	// иначе задачи могут сослаться на несуществующие выражения
+	for i, expr := range parsed {
+		if expr == nil {
+			continue
+		}
+
+		results[i].Id = created[0].ID
+		o.startEvaluation(expr, created[0])
+		created = created[1:]
+	}
+
+	return results, nil
+}
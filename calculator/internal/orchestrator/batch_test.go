@@ -0,0 +1,82 @@
+package orchestrator_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+)
+
+func TestCalculateBatchHandlerRejectsBadBatches(t *testing.T) {
+	tooLarge := `{"expressions": [` +
+		strings.Repeat(`{"expression": "1+1"},`, 1000) +
+		`{"expression": "1+1"}]}`
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "invalid json", body: `{"expressions": `},
+		{name: "empty", body: `{"expressions": []}`},
+		{name: "too large", body: tooLarge},
+	}
+
+	o := newTestOrchestrator("secret")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/v1/calculate/batch",
+				strings.NewReader(tt.body),
+			)
+			rr := httptest.NewRecorder()
+
+			o.CalculateBatchHandler(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("got status %v, want %v",
+					rr.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCalculateBatchWithoutAgents(t *testing.T) {
+	t.Parallel()
+
+	o := newTestOrchestrator("secret")
+	token := issueToken(t, "secret", 1)
+
+	rr := serve(o, http.MethodPost, "/api/v1/calculate/batch", token,
+		`{"expressions": [
+			{"client_id": "a", "expression": "2+2"},
+			{"client_id": "b", "expression": "2+"},
+			{"client_id": "c", "expression": "3*3"}
+		]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	resp := orchestrator.BatchResponse{}
+
+	err := json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(resp.Results))
+	}
+
+	for i, res := range resp.Results {
+		failed := i == 1
+		if res.ClientID != string(rune('a'+i)) ||
+			(res.Error != "") != failed || (res.Id == 0) != failed {
+			t.Errorf("got result %+v at %d", res, i)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+	"github.com/jackc/pgx/v5"
+)
+
+// cancelBuffer — сколько отмен может ждать отправки в стрим агента.
+const cancelBuffer = 16
+
+// cancelNotifier передает стримам агентов задачи, которые
+// больше не нужно вычислять.
+type cancelNotifier struct {
+	subscribers map[string]map[chan uint64]struct{}
+	mu          sync.Mutex
+}
+
+func newCancelNotifier() *cancelNotifier {
+	return &cancelNotifier{
+		subscribers: make(map[string]map[chan uint64]struct{}),
+	}
+}
+
+// Subscribe returns a channel with ids of tasks canceled for the agent.
+// The returned function must be called once the stream is closed.
+func (n *cancelNotifier) Subscribe(agentID string) (<-chan uint64, func()) {
+	ch := make(chan uint64, cancelBuffer)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.subscribers[agentID] == nil {
+		n.subscribers[agentID] = make(map[chan uint64]struct{})
+	}
+
+	n.subscribers[agentID][ch] = struct{}{}
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.subscribers[agentID], ch)
+
+		if len(n.subscribers[agentID]) == 0 {
+			delete(n.subscribers, agentID)
+		}
+	}
+}
+
+// Notify tells the streams of the agent that the task was canceled.
+// Agents without a stream are not notified: their result is rejected.
+func (n *cancelNotifier) Notify(agentID string, taskID uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subscribers[agentID] {
+		// Не ждем медленный стрим: результат все равно отклоним
+		select {
+		case ch <- taskID:
+		default:
+		}
+	}
+}
+
+// CancelExpression останавливает вычисление выражения: его задачи
+// больше не выдаются, а результаты по ним отклоняются.
+// Вычисление останавливается только после отмены в базе: если
+// отменить не удалось, выражение продолжает вычисляться.
+func (o *Orchestrator) CancelExpression(id uint64) (repo.Expression, error) {
+	expr, err := o.repos.Expressions.Cancel(id, webhookPayload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Expression{}, errExpressionFinished
+	}
+
+	if err != nil {
+		return repo.Expression{}, err
+	}
+
+	o.hub.Publish(expressionEvent(expr))
+	o.webhooks.Wake()
+	o.stopExpression(id)
+
+	// Задачи из очереди отменятся, когда до них дойдет очередь,
+	// но в базе они не должны дожить до перезапуска. Выражение уже
+	// отменено, поэтому ошибку только логируем: задачи отмененного
+	// выражения после перезапуска все равно не возобновятся
+	_, err = o.repos.Tasks.CancelForExpression(id)
+	if err != nil {
+		slog.Error("Failed to cancel expression tasks",
+			slog.Uint64("expression_id", id),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	return expr, nil
+}
+
+// stopExpression прекращает вычисление выражения и отзывает задачи,
+// которые по нему вычисляют агенты. Для вычисления отмена не отличается
+// от ошибки: задачи выражения перестают выдаваться и завершаться.
+func (o *Orchestrator) stopExpression(id uint64) {
+	if memExpr, ok := o.exprMemStorage.Get(id); ok {
+		memExpr.MarkAsFailed()
+	}
+
+	o.exprMemStorage.Delete(id)
+
+	var tasks []*calc.Task
+
+	for task := range o.taskMemStorage.All() {
+		if task.GetExpression().Id == id {
+			tasks = append(tasks, task)
+		}
+	}
+
+	for _, task := range tasks {
+		o.revokeTask(task)
+	}
+}
+
+// revokeTask отменяет задачу, которую вычисляют агенты, и сообщает
+// им об этом, чтобы они не тратили время зря.
+func (o *Orchestrator) revokeTask(task *calc.Task) {
+	o.leases.Release(task.Id)
+
+	for _, agentID := range o.registry.Release(task.Id) {
+		o.cancels.Notify(agentID, task.Id)
+	}
+
+	// Задача могла завершиться раньше, тогда отменять нечего
+	_ = o.cancelTask(task)
+}
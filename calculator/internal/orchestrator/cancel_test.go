@@ -0,0 +1,76 @@
+package orchestrator_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+func TestCancelNotifier(t *testing.T) {
+	n := orchestrator.NewCancelNotifier()
+
+	canceled, unsubscribe := n.Subscribe("a")
+	other, _ := n.Subscribe("b")
+
+	n.Notify("a", 7)
+
+	select {
+	case id := <-canceled:
+		if id != 7 {
+			t.Errorf("got canceled task %d, want 7", id)
+		}
+	default:
+		t.Fatal("agent was not notified")
+	}
+
+	select {
+	case id := <-other:
+		t.Errorf("other agent got canceled task %d", id)
+	default:
+	}
+
+	unsubscribe()
+	n.Notify("a", 8)
+
+	select {
+	case id := <-canceled:
+		t.Errorf("got canceled task %d after unsubscribing", id)
+	default:
+	}
+}
+
+func TestCancelBetweenDispatchAndCompletion(t *testing.T) {
+	t.Parallel()
+
+	exprs := &fakeExpressions{exprs: make(map[uint64]repo.Expression)}
+	repos := fakeRepositories()
+	repos.Expressions = exprs
+	o := newTestOrchestratorWithRepos("secret", repos)
+	token := issueToken(t, "secret", 1)
+
+	o.RegisterAgent("agent")
+	target := calculate(t, o, token, "2+3")
+
+	task, err := o.StartProcessingNextTask("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Выражение отменяет другой оркестратор, пока агент вычисляет задачу
+	_, err = exprs.Cancel(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.CompleteTask("agent", task.Id, 5)
+	if !errors.Is(err, orchestrator.ErrTaskCanceled) {
+		t.Errorf("got error %v, want %v", err, orchestrator.ErrTaskCanceled)
+	}
+
+	expr := getExpression(t, o, token, target)
+	if expr.Status != repo.ExpressionCanceled || expr.Result != nil {
+		t.Errorf("got expression %+v, want a canceled one", expr)
+	}
+}
@@ -1,8 +1,10 @@
 package orchestrator
 
 import (
+	"crypto/tls"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dzherb/go_calculator/calculator/internal/pkg"
@@ -19,6 +21,35 @@ type Config struct {
 	TaskMaxProcessTime time.Duration
 	SecretKey          string
 	AccessTokenTTL     time.Duration
+	OperatorCosts      CostModel
+	LocalFoldThreshold float64
+	AgentIdleTimeout   time.Duration
+	// Если оркестратор не продлит владение выражением за это время,
+	// его заберет другой оркестратор
+	OwnershipLeaseTTL time.Duration
+	// Повторы задач, упавших не по математической причине
+	TaskMaxAttempts     int
+	TaskRetryBackoff    time.Duration
+	TaskRetryMaxBackoff time.Duration
+	AdminUsernames      []string
+	// Сколько помнить ответы на запросы с Idempotency-Key
+	IdempotencyKeyTTL time.Duration
+	// Сколько ждать ответа на запрос, прежде чем разрешить его повтор
+	IdempotencyLockTTL time.Duration
+	// Доставка вебхуков: таймаут запроса и повторы с растущей паузой
+	WebhookTimeout         time.Duration
+	WebhookMaxAttempts     int
+	WebhookRetryBackoff    time.Duration
+	WebhookRetryMaxBackoff time.Duration
+	// Каждая задача вычисляется VerifyReplicas разными агентами,
+	// результат принимается, если совпал у VerifyQuorum из них
+	VerifyReplicas int
+	VerifyQuorum   int
+	// Пустой токен отключает проверку агентов
+	AgentToken string
+	// Без сертификата сервер работает без TLS
+	HTTPTLS common.TLSFiles
+	GRPCTLS common.TLSFiles
 }
 
 func ConfigFromEnv() *Config {
@@ -58,9 +89,116 @@ func ConfigFromEnv() *Config {
 		config.AccessTokenTTL = 1 * time.Hour
 	}
 
+	config.OperatorCosts = parseCostModel(
+		common.EnvOrDefault("OPERATOR_COSTS", ""),
+	)
+
+	if threshold, exists := os.LookupEnv("LOCAL_FOLD_THRESHOLD"); exists {
+		config.LocalFoldThreshold, _ = strconv.ParseFloat(threshold, 64)
+	}
+
+	if idleTimeout, exists := os.LookupEnv("AGENT_IDLE_TIMEOUT_MS"); exists {
+		config.AgentIdleTimeout = getDurationInMs(idleTimeout)
+	} else {
+		config.AgentIdleTimeout = 10 * time.Second
+	}
+
+	if ownershipTTL, exists := os.LookupEnv("OWNERSHIP_LEASE_TTL_MS"); exists {
+		config.OwnershipLeaseTTL = getDurationInMs(ownershipTTL)
+	} else {
+		config.OwnershipLeaseTTL = 30 * time.Second
+	}
+
+	attempts := common.EnvOrDefault("TASK_MAX_ATTEMPTS", "3")
+	config.TaskMaxAttempts, _ = strconv.Atoi(attempts)
+
+	if backoff, exists := os.LookupEnv("TASK_RETRY_BACKOFF_MS"); exists {
+		config.TaskRetryBackoff = getDurationInMs(backoff)
+	} else {
+		config.TaskRetryBackoff = 500 * time.Millisecond
+	}
+
+	if maxBackoff, exists := os.LookupEnv("TASK_RETRY_MAX_BACKOFF_MS"); exists {
+		config.TaskRetryMaxBackoff = getDurationInMs(maxBackoff)
+	} else {
+		config.TaskRetryMaxBackoff = 30 * time.Second
+	}
+
+	if keyTTL, exists := os.LookupEnv("IDEMPOTENCY_KEY_TTL"); exists {
+		config.IdempotencyKeyTTL = getDurationInMin(keyTTL)
+	} else {
+		config.IdempotencyKeyTTL = 24 * time.Hour
+	}
+
+	if lockTTL, exists := os.LookupEnv("IDEMPOTENCY_LOCK_TTL_MS"); exists {
+		config.IdempotencyLockTTL = getDurationInMs(lockTTL)
+	} else {
+		config.IdempotencyLockTTL = time.Minute
+	}
+
+	if timeout, exists := os.LookupEnv("WEBHOOK_TIMEOUT_MS"); exists {
+		config.WebhookTimeout = getDurationInMs(timeout)
+	} else {
+		config.WebhookTimeout = 10 * time.Second
+	}
+
+	webhookAttempts := common.EnvOrDefault("WEBHOOK_MAX_ATTEMPTS", "10")
+	config.WebhookMaxAttempts, _ = strconv.Atoi(webhookAttempts)
+
+	if backoff, exists := os.LookupEnv("WEBHOOK_RETRY_BACKOFF_MS"); exists {
+		config.WebhookRetryBackoff = getDurationInMs(backoff)
+	} else {
+		config.WebhookRetryBackoff = 5 * time.Second
+	}
+
+	if maxBackoff, exists := os.LookupEnv("WEBHOOK_RETRY_MAX_BACKOFF_MS"); exists {
+		config.WebhookRetryMaxBackoff = getDurationInMs(maxBackoff)
+	} else {
+		config.WebhookRetryMaxBackoff = time.Hour
+	}
+
+	if admins := common.EnvOrDefault("ADMIN_USERNAMES", ""); admins != "" {
+		config.AdminUsernames = strings.Split(admins, ",")
+	}
+
+	replicas := common.EnvOrDefault("VERIFY_REPLICAS", "1")
+	config.VerifyReplicas, _ = strconv.Atoi(replicas)
+	config.VerifyReplicas = max(config.VerifyReplicas, 1)
+
+	// По умолчанию нужно большинство
+	quorum := common.EnvOrDefault(
+		"VERIFY_QUORUM",
+		strconv.Itoa(config.VerifyReplicas/2+1),
+	)
+	config.VerifyQuorum, _ = strconv.Atoi(quorum)
+	config.VerifyQuorum = min(max(config.VerifyQuorum, 1), config.VerifyReplicas)
+
+	config.AgentToken = common.EnvOrDefault("AGENT_TOKEN", "")
+
+	minVersion, ok := common.ParseTLSVersion(
+		common.EnvOrDefault("TLS_MIN_VERSION", "1.2"),
+	)
+	if !ok {
+		minVersion = tls.VersionTLS12
+	}
+
+	config.HTTPTLS = tlsFilesFromEnv("HTTP", minVersion)
+	config.GRPCTLS = tlsFilesFromEnv("GRPC", minVersion)
+
 	return config
 }
 
+// tlsFilesFromEnv читает <prefix>_TLS_CERT_FILE, <prefix>_TLS_KEY_FILE
+// и <prefix>_TLS_CLIENT_CA_FILE.
+func tlsFilesFromEnv(prefix string, minVersion uint16) common.TLSFiles {
+	return common.TLSFiles{
+		CertFile:     common.EnvOrDefault(prefix+"_TLS_CERT_FILE", ""),
+		KeyFile:      common.EnvOrDefault(prefix+"_TLS_KEY_FILE", ""),
+		ClientCAFile: common.EnvOrDefault(prefix+"_TLS_CLIENT_CA_FILE", ""),
+		MinVersion:   minVersion,
+	}
+}
+
 func getDurationInMs(duration string) time.Duration {
 	t, _ := strconv.Atoi(duration)
 	return time.Duration(t) * time.Millisecond
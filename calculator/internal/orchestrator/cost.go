@@ -0,0 +1,42 @@
+package orchestrator
+
+import (
+	"strconv"
+	"strings"
+)
+
+const defaultOperatorCost = 1.0
+
+// CostModel задает относительную стоимость вычисления операторов.
+// Операторы, которых нет в модели, стоят defaultOperatorCost.
+type CostModel map[string]float64
+
+func (m CostModel) Cost(operator string) float64 {
+	if cost, ok := m[operator]; ok {
+		return cost
+	}
+
+	return defaultOperatorCost
+}
+
+// parseCostModel разбирает строку вида "+:1,-:1,*:2,/:4".
+// Некорректные элементы пропускаются.
+func parseCostModel(s string) CostModel {
+	model := make(CostModel)
+
+	for _, item := range strings.Split(s, ",") {
+		operator, cost, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(cost), 64)
+		if err != nil {
+			continue
+		}
+
+		model[strings.TrimSpace(operator)] = value
+	}
+
+	return model
+}
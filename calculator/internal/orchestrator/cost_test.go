@@ -0,0 +1,27 @@
+package orchestrator_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+)
+
+func TestParseCostModel(t *testing.T) {
+	model := orchestrator.ParseCostModel("+:0.5, *:2,/:x,-")
+
+	cases := []struct {
+		operator string
+		cost     float64
+	}{
+		{"+", 0.5},
+		{"*", 2},
+		{"/", 1}, // invalid cost falls back to default
+		{"-", 1}, // missing cost falls back to default
+	}
+
+	for _, c := range cases {
+		if got := model.Cost(c.operator); got != c.cost {
+			t.Errorf("Cost(%q) = %v, want %v", c.operator, got, c.cost)
+		}
+	}
+}
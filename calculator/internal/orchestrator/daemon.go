@@ -4,92 +4,99 @@ import (
 	"context"
 	"log/slog"
 	"time"
-
-	"github.com/dzherb/go_calculator/calculator/internal/repository"
 )
 
+// Daemon периодически обслуживает оркестратор: чистит память,
+// забывает пропавших агентов и удаляет просроченное.
 type Daemon struct {
+	o *Orchestrator
 }
 
-func NewDaemon() *Daemon {
-	return &Daemon{}
+func NewDaemon(o *Orchestrator) *Daemon {
+	return &Daemon{o: o}
 }
 
-const ExprAbortPeriod = time.Minute * 5
 const ExprCleanPeriod = time.Minute * 2
 const TasksCleanPeriod = time.Minute * 1
+const LocalFoldPeriod = time.Second * 5
+const AgentExpirePeriod = time.Second * 2
+const IdempotencyCleanPeriod = time.Minute * 10
 
 func (d *Daemon) Start(ctx context.Context) {
 	slog.Info("starting orchestrator daemon")
 
-	ExprAbortTicker := time.Tick(ExprAbortPeriod)
 	ExprCleanTicker := time.Tick(ExprCleanPeriod)
 	TasksCleanTicker := time.Tick(TasksCleanPeriod)
+	LocalFoldTicker := time.Tick(LocalFoldPeriod)
+	AgentExpireTicker := time.Tick(AgentExpirePeriod)
+	IdempotencyCleanTicker := time.Tick(IdempotencyCleanPeriod)
 
 	for {
 		select {
 		case <-ctx.Done():
 			slog.Info("stopping orchestrator daemon")
 			return
-		case <-ExprAbortTicker:
-			go d.AbortUnprocessedExpr()
 		case <-ExprCleanTicker:
 			go d.CleanExprStorage()
 		case <-TasksCleanTicker:
 			go d.CleanTasksStorage()
+		case <-LocalFoldTicker:
+			go d.FoldQueuedTasks()
+		case <-AgentExpireTicker:
+			go d.ExpireSilentAgents()
+		case <-IdempotencyCleanTicker:
+			go d.CleanIdempotencyKeys()
 		}
 	}
 }
 
-func (d *Daemon) AbortUnprocessedExpr() {
-	expressions, err := ExpressionRepo().Unprocessed()
-	if err != nil {
-		slog.Error("Failed to retrieve unprocessed expressions", "error", err)
-		return
-	}
-
-	for _, expr := range expressions {
-		_, err = ExpressionRepo().Update(repo.Expression{
-			ID:     expr.ID,
-			Status: repo.ExpressionAborted,
-		})
-		if err != nil {
-			slog.Error("Failed to update expression status",
-				"expression_id", expr.ID,
-				"error", err,
-			)
-
-			return
-		}
-
-		orchestrator.exprMemStorage.Delete(expr.ID)
-	}
-}
-
 func (d *Daemon) CleanExprStorage() {
 	var exprs []uint64
 
-	for e := range orchestrator.exprMemStorage.All() {
+	for e := range d.o.exprMemStorage.All() {
 		if e.IsFailed || e.IsEvaluated() {
 			exprs = append(exprs, e.Id)
 		}
 	}
 
 	for _, id := range exprs {
-		orchestrator.exprMemStorage.Delete(id)
+		d.o.exprMemStorage.Delete(id)
 	}
 }
 
 func (d *Daemon) CleanTasksStorage() {
 	var tasks []uint64
 
-	for t := range orchestrator.taskMemStorage.All() {
+	for t := range d.o.taskMemStorage.All() {
 		if t.IsCanceled || t.IsCompleted {
 			tasks = append(tasks, t.Id)
 		}
 	}
 
 	for _, id := range tasks {
-		orchestrator.taskMemStorage.Delete(id)
+		d.o.taskMemStorage.Delete(id)
+	}
+}
+
+// FoldQueuedTasks вычисляет на месте задачи из очереди,
+// если их некому отправить, например когда все агенты пропали.
+func (d *Daemon) FoldQueuedTasks() {
+	err := d.o.foldQueued()
+	if err != nil {
+		slog.Error("Failed to evaluate queued tasks locally", "error", err)
+	}
+}
+
+// ExpireSilentAgents забывает агентов, которые перестали выходить на связь,
+// и отдает их задачи другим.
+func (d *Daemon) ExpireSilentAgents() {
+	d.o.expireSilentAgents()
+}
+
+// CleanIdempotencyKeys удаляет просроченные ключи идемпотентности.
+func (d *Daemon) CleanIdempotencyKeys() {
+	_, err := d.o.repos.IdempotencyKeys.DeleteExpired()
+	if err != nil {
+		slog.Error("Failed to delete expired idempotency keys", "error", err)
 	}
 }
@@ -1,11 +1,55 @@
 package orchestrator
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var errInvalidRequestBody = errors.New("invalid request body")
 var errInvalidIdInUrl = errors.New("invalid id in url")
+var errInvalidWait = errors.New("wait must be a duration, e.g. 30s")
+var errInvalidLimit = errors.New("limit must be a positive integer")
+var errInvalidCursor = errors.New("invalid cursor")
+var errInvalidSort = errors.New(
+	"sort must be one of created_at, -created_at, updated_at, -updated_at",
+)
+var errInvalidStatus = errors.New("unknown expression status")
+var errInvalidDate = errors.New("dates must be in RFC 3339 format")
+var errEmptyBatch = errors.New("batch contains no expressions")
+var errBatchTooLarge = fmt.Errorf(
+	"batch contains more than %d expressions", maxBatchSize,
+)
+
+var errIdempotencyKeyTooLong = errors.New("idempotency key is too long")
+var errIdempotencyKeyInUse = errors.New(
+	"request with this idempotency key is still in progress",
+)
+var errIdempotencyKeyReused = errors.New(
+	"idempotency key was already used for a different request",
+)
+
+var errInvalidWebhookURL = errors.New(
+	"webhook url must be an absolute http or https url",
+)
+var errForbiddenWebhookAddress = errors.New(
+	"webhook url must point to a public address",
+)
+var errUnresolvedWebhookHost = errors.New("webhook host cannot be resolved")
+var errWebhookNotFound = errors.New("webhook not found")
 
 var errExpressionNotFound = errors.New("expression not found")
+var errExpressionFinished = errors.New("expression is already finished")
+var errOwnershipLost = errors.New(
+	"expression is evaluated by another orchestrator",
+)
 
 var errTaskNotFound = errors.New("task not found")
 var errNoTasksToProcess = errors.New("no tasks to process")
+var errLeaseExpired = errors.New("task lease has expired")
+var errTaskCanceled = errors.New("task was canceled")
+var errTaskReassigned = errors.New("task was handed to another agent")
+var errAgentNotRegistered = errors.New("agent is not registered")
+var errAgentSilent = errors.New("agent stopped responding")
+var errInvalidAgentToken = errors.New("invalid agent token")
+var errReplicaNotAssigned = errors.New("task was not handed to this agent")
+var errReplicasDisagree = errors.New("agents returned different results")
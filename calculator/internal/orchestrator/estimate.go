@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+type Estimate struct {
+	Nodes          int       `json:"nodes"`
+	Depth          int       `json:"depth"`
+	CriticalPathMs int64     `json:"critical_path_ms"`
+	DurationMs     int64     `json:"duration_ms"`
+	QueueLength    int       `json:"queue_length"`
+	Agents         int       `json:"agents"`
+	ETA            time.Time `json:"eta"`
+}
+
+// EstimateExpression оценивает, сколько займет вычисление выражения,
+// если отправить его прямо сейчас.
+func (o *Orchestrator) EstimateExpression(expression string) (Estimate, error) {
+	expr, err := calc.NewExpression(expression, o.taskIDs)
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	stats := expr.Stats(o.getOperationTime)
+	queueLength := o.queue.Len()
+	agents := o.aliveAgents()
+	duration := o.estimateDuration(stats, queueLength, agents)
+
+	return Estimate{
+		Nodes:          stats.Nodes,
+		Depth:          stats.Depth,
+		CriticalPathMs: stats.CriticalPath.Milliseconds(),
+		DurationMs:     duration.Milliseconds(),
+		QueueLength:    queueLength,
+		Agents:         agents,
+		ETA:            o.now().Add(duration).UTC(),
+	}, nil
+}
+
+// estimateDuration грубо оценивает время вычисления: сначала агенты
+// разбирают очередь, затем само выражение, которое не может посчитаться
+// быстрее своего критического пути.
+func (o *Orchestrator) estimateDuration(
+	stats calc.Stats,
+	queueLength int,
+	agents int,
+) time.Duration {
+	// Без агентов оркестратор вычисляет все сам и без задержек
+	if agents == 0 {
+		return 0
+	}
+
+	backlog := time.Duration(queueLength) * o.averageOperationTime() /
+		time.Duration(agents)
+	work := max(stats.CriticalPath, stats.TotalWork/time.Duration(agents))
+
+	return backlog + work
+}
+
+func (o *Orchestrator) averageOperationTime() time.Duration {
+	operators := []string{"+", "-", "*", "/"}
+
+	var total time.Duration
+
+	for _, op := range operators {
+		total += o.getOperationTime(op)
+	}
+
+	return total / time.Duration(len(operators))
+}
+
+// ExpressionView — выражение вместе с оценкой времени готовности,
+// если оно еще вычисляется.
+type ExpressionView struct {
+	repo.Expression
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+func (o *Orchestrator) withETA(expr repo.Expression) ExpressionView {
+	view := ExpressionView{Expression: expr}
+
+	memExpr, ok := o.exprMemStorage.Get(expr.ID)
+	if !ok || memExpr.HasFailed() || memExpr.IsEvaluated() {
+		return view
+	}
+
+	duration := o.estimateDuration(
+		memExpr.Stats(o.getOperationTime),
+		o.queue.Len(),
+		o.aliveAgents(),
+	)
+	eta := o.now().Add(duration).UTC()
+	view.ETA = &eta
+
+	return view
+}
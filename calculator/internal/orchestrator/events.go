@@ -0,0 +1,204 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Комментарий раз в этот период не дает прокси закрыть молчащий стрим.
+const sseKeepAlivePeriod = 15 * time.Second
+
+// ExpressionEventsHandler streams changes of the user's expressions
+// as Server-Sent Events. A reconnecting client gets the events it missed
+// after the one in the Last-Event-ID header.
+func (o *Orchestrator) ExpressionEventsHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userID := r.Context().Value(UserIDKey).(uint64)
+
+	sub, err := o.SubscribeUserExpressions(userID, lastEventID(r))
+	if err != nil {
+		slog.Error("Failed to subscribe to expression events",
+			slog.String("error", err.Error()),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+
+	// Стрим живет дольше таймаута записи сервера
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("Failed to extend write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range sub.Missed {
+		if writeSSE(w, event) != nil {
+			return
+		}
+	}
+
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlivePeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Клиент отстал, он переподключится и получит пропущенное
+				return
+			}
+
+			err = writeSSE(w, event)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w io.Writer, event ExpressionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if event.Seq != 0 {
+		_, err = fmt.Fprintf(w, "id: %d\n", event.Seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: expression\ndata: %s\n\n", data)
+
+	return err
+}
+
+// expressionMessage — событие в WebSocket. Номер события клиент передает
+// в last_event_id при переподключении.
+type expressionMessage struct {
+	EventID uint64 `json:"event_id,omitempty"`
+	ExpressionEvent
+}
+
+// ExpressionEventsWebSocketHandler is the WebSocket equivalent
+// of ExpressionEventsHandler: every event is sent as a JSON message.
+func (o *Orchestrator) ExpressionEventsWebSocketHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	userID := r.Context().Value(UserIDKey).(uint64)
+
+	sub, err := o.SubscribeUserExpressions(userID, lastEventID(r))
+	if err != nil {
+		slog.Error("Failed to subscribe to expression events",
+			slog.String("error", err.Error()),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+	defer sub.Close()
+
+	server := websocket.Server{
+		// Origin не проверяем: как и остальное API, стрим доступен
+		// с любых сайтов, а доступ дает токен
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			streamToWebSocket(ws, sub)
+		},
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+func streamToWebSocket(ws *websocket.Conn, sub UserSubscription) {
+	// Соединение перехвачено у HTTP-сервера вместе с его таймаутами
+	err := ws.SetDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("Failed to reset websocket deadline", "error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Клиенту писать нечего, читаем только чтобы заметить закрытие
+	go func() {
+		_, _ = io.Copy(io.Discard, ws)
+
+		cancel()
+	}()
+
+	for _, event := range sub.Missed {
+		if sendWebSocketEvent(ws, event) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok || sendWebSocketEvent(ws, event) != nil {
+				return
+			}
+		}
+	}
+}
+
+func sendWebSocketEvent(ws *websocket.Conn, event ExpressionEvent) error {
+	return websocket.JSON.Send(ws, expressionMessage{
+		EventID:         event.Seq,
+		ExpressionEvent: event,
+	})
+}
+
+// lastEventID возвращает номер последнего события, которое получил
+// клиент. Браузер сам передает его в заголовке при переподключении
+// к SSE, остальные клиенты могут передать его в параметре запроса.
+func lastEventID(r *http.Request) uint64 {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+
+	// С неизвестного номера клиент получит текущее состояние
+	id, _ := strconv.ParseUint(raw, 10, 64)
+
+	return id
+}
@@ -0,0 +1,110 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+var ParseCostModel = parseCostModel
+var NewTaskQueue = newTaskQueue
+var NewLeaseWheel = newLeaseWheel
+var NewRangeAllocator = newRangeAllocator
+var RetryBackoff = retryBackoff
+var AgentAuthUnaryInterceptor = agentAuthUnaryInterceptor
+var NewVerifier = newVerifier
+var NewCancelNotifier = newCancelNotifier
+var NewWebhookDispatcher = newWebhookDispatcher
+var SignWebhook = signWebhook
+var ValidateWebhookURL = validateWebhookURL
+var ErrReplicaNotAssigned = errReplicaNotAssigned
+var ErrTaskCanceled = errTaskCanceled
+var ErrTaskReassigned = errTaskReassigned
+
+func NewAgentRegistry() *agentRegistry {
+	return newAgentRegistry(time.Now)
+}
+
+func (r *agentRegistry) OperatorFilter(id string) func(string) bool {
+	return r.operatorFilter(id)
+}
+
+// RegisterAgent регистрирует агента, который принимает любые операторы.
+func (o *Orchestrator) RegisterAgent(id string) {
+	o.registry.Register(AgentInfo{ID: id, RegisteredAt: o.now()})
+}
+
+// ReclaimExpired возвращает в очередь задачи, как если бы их аренда
+// истекла.
+func (o *Orchestrator) ReclaimExpired(ids ...uint64) {
+	o.reclaimExpired(ids)
+}
+
+func NewExpressionHub() *expressionHub {
+	return newExpressionHub(time.Now)
+}
+
+// OnExpressionChange передает хабу уведомление об изменении выражения,
+// полученное оркестратором с указанным идентификатором.
+func OnExpressionChange(
+	hub *expressionHub,
+	exprs repo.ExpressionRepository,
+	instanceID string,
+	payload string,
+) {
+	o := &Orchestrator{
+		instanceID: instanceID,
+		repos:      Repositories{Expressions: exprs},
+		hub:        hub,
+	}
+	o.onExpressionChange(payload)
+}
+
+type WebhookDispatcher = webhookDispatcher
+
+// AllowPrivateAddresses lets the dispatcher deliver to test servers
+// listening on the loopback.
+func (d *webhookDispatcher) AllowPrivateAddresses() {
+	d.client.Transport = http.DefaultTransport
+}
+
+func (d *webhookDispatcher) Deliver(
+	ctx context.Context,
+	due repo.DueDelivery,
+) repo.WebhookDelivery {
+	return d.deliver(ctx, due)
+}
+
+const (
+	VerdictPending  = verdictPending
+	VerdictAccepted = verdictAccepted
+	VerdictDisputed = verdictDisputed
+)
+
+func Answer(value float64) outcome {
+	return outcome{Value: value}
+}
+
+func MathError(cause string) outcome {
+	return outcome{Failed: true, Error: cause}
+}
+
+func (v verdict) Kind() verdictKind {
+	return v.kind
+}
+
+func (v verdict) Accepted() outcome {
+	return v.accepted
+}
+
+func (v verdict) DissenterIDs() []string {
+	var ids []string
+
+	for _, vote := range v.Dissenters() {
+		ids = append(ids, vote.agentID)
+	}
+
+	return ids
+}
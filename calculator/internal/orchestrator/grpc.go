@@ -3,34 +3,41 @@ package orchestrator
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
 	"log/slog"
 	"net"
 	"strconv"
+	"time"
 
 	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func (a *App) ServeGRPC(ctx context.Context) error {
-	addr := a.config.Host + ":" + a.config.GRPCPort
+func (o *Orchestrator) ServeGRPC(ctx context.Context) error {
+	addr := o.config.Host + ":" + o.config.GRPCPort
 
-	lis, err := net.Listen("tcp", a.config.Host+":"+a.config.GRPCPort)
+	s, err := o.NewGRPCServer(ctx)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return err
 	}
 
-	s := grpc.NewServer()
+	lis, err := net.Listen("tcp", o.config.Host+":"+o.config.GRPCPort)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
 
 	go func() {
 		<-ctx.Done()
 		s.GracefulStop()
 	}()
 
-	pb.RegisterTaskServiceServer(s, &grpcServer{})
-
 	slog.Info("GRPC server is listening on " + addr)
 
 	err = s.Serve(lis)
@@ -47,15 +54,137 @@ func (a *App) ServeGRPC(ctx context.Context) error {
 	return nil
 }
 
+// NewGRPCServer returns the gRPC server that agents talk to,
+// not listening yet.
+func (o *Orchestrator) NewGRPCServer(
+	ctx context.Context,
+) (*grpc.Server, error) {
+	opts, err := o.grpcServerOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterTaskServiceServer(s, &grpcServer{o: o})
+
+	return s, nil
+}
+
+func (o *Orchestrator) grpcServerOptions(
+	ctx context.Context,
+) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+
+	if token := o.config.AgentToken; token != "" {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(agentAuthUnaryInterceptor(token)),
+			grpc.ChainStreamInterceptor(agentAuthStreamInterceptor(token)),
+		)
+	} else {
+		slog.Warn("AGENT_TOKEN is not set, agents are not authenticated")
+	}
+
+	if o.config.GRPCTLS.Enabled() {
+		tlsConfig, err := serverTLSConfig(ctx, o.config.GRPCTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	return opts, nil
+}
+
 type grpcServer struct {
 	pb.UnimplementedTaskServiceServer
+	o *Orchestrator
 }
 
-func (gs *grpcServer) GetTask(
+func (gs *grpcServer) RegisterAgent(
+	ctx context.Context,
+	req *pb.RegisterAgentRequest,
+) (*pb.RegisterAgentResponse, error) {
+	if req.AgentId == "" {
+		return nil, status.Error(
+			codes.InvalidArgument,
+			"agent id must be provided",
+		)
+	}
+
+	gs.o.registry.Register(AgentInfo{
+		ID:           req.AgentId,
+		Hostname:     req.Hostname,
+		Version:      req.Version,
+		Workers:      int(req.Workers),
+		Operators:    req.Operators,
+		RegisteredAt: gs.o.now(),
+	})
+
+	slog.Info("Agent registered",
+		"agent_id", req.AgentId,
+		"hostname", req.Hostname,
+		"version", req.Version,
+		"workers", req.Workers,
+		"operators", req.Operators,
+	)
+
+	return &pb.RegisterAgentResponse{
+		HeartbeatInterval: durationpb.New(gs.heartbeatInterval()),
+	}, nil
+}
+
+// heartbeatInterval выбран так, чтобы агент успел отправить
+// несколько сигналов, прежде чем его сочтут пропавшим.
+func (gs *grpcServer) heartbeatInterval() time.Duration {
+	return gs.o.config.AgentIdleTimeout / 3
+}
+
+func (gs *grpcServer) Heartbeat(
 	_ context.Context,
-	_ *pb.GetTaskRequest,
+	req *pb.HeartbeatRequest,
+) (*pb.HeartbeatResponse, error) {
+	if !gs.o.registry.Touch(req.AgentId) {
+		return nil, status.Error(
+			codes.NotFound,
+			errAgentNotRegistered.Error(),
+		)
+	}
+
+	return &pb.HeartbeatResponse{}, nil
+}
+
+// identifyAgent отмечает, что агент жив, и возвращает его id.
+// Незарегистрированные агенты различаются по адресу. Если агента
+// забыли, например после перезапуска оркестратора, возвращает NotFound.
+func (gs *grpcServer) identifyAgent(
+	ctx context.Context,
+	agentID string,
+) (string, error) {
+	if agentID == "" {
+		return gs.o.registry.TouchAnonymous(peerAddr(ctx)), nil
+	}
+
+	if !gs.o.registry.Touch(agentID) {
+		return "", status.Error(
+			codes.NotFound,
+			errAgentNotRegistered.Error(),
+		)
+	}
+
+	return agentID, nil
+}
+
+func (gs *grpcServer) GetTask(
+	ctx context.Context,
+	req *pb.GetTaskRequest,
 ) (*pb.TaskToProcess, error) {
-	task, err := orchestrator.StartProcessingNextTask()
+	agentID, err := gs.identifyAgent(ctx, req.AgentId)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := gs.o.StartProcessingNextTask(agentID)
 	if err != nil {
 		return nil, status.Error(codes.ResourceExhausted, err.Error())
 	}
@@ -63,22 +192,119 @@ func (gs *grpcServer) GetTask(
 	return task, nil
 }
 
+// maxTasksPerBatch ограничивает число задач, выдаваемых за один вызов.
+const maxTasksPerBatch = 256
+
+func (gs *grpcServer) GetTasks(
+	ctx context.Context,
+	req *pb.GetTasksRequest,
+) (*pb.TasksToProcess, error) {
+	agentID, err := gs.identifyAgent(ctx, req.AgentId)
+	if err != nil {
+		return nil, err
+	}
+
+	count := min(int(req.MaxCount), maxTasksPerBatch)
+	tasks := make([]*pb.TaskToProcess, 0, count)
+
+	for range count {
+		task, err := gs.o.StartProcessingNextTask(agentID)
+		if err != nil {
+			if !errors.Is(err, errNoTasksToProcess) {
+				slog.Error("failed to start processing a task", "error", err)
+			}
+
+			break
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	if len(tasks) == 0 {
+		return nil, status.Error(
+			codes.ResourceExhausted,
+			errNoTasksToProcess.Error(),
+		)
+	}
+
+	return &pb.TasksToProcess{Tasks: tasks}, nil
+}
+
+func (gs *grpcServer) AddResults(
+	ctx context.Context,
+	req *pb.TaskResults,
+) (*pb.AddResultsResponse, error) {
+	resp := &pb.AddResultsResponse{}
+
+	for _, result := range req.Results {
+		err := gs.addResult(resultAgentID(ctx, result.AgentId), result)
+		if err != nil {
+			st := status.Convert(err)
+
+			resp.Rejected = append(resp.Rejected, &pb.RejectedResult{
+				Id:      result.Id,
+				Code:    uint32(st.Code()),
+				Message: st.Message(),
+			})
+		}
+	}
+
+	return resp, nil
+}
+
 func (gs *grpcServer) AddResult(
-	_ context.Context,
+	ctx context.Context,
 	task *pb.TaskResult,
 ) (*pb.AddResultResponse, error) {
+	return nil, gs.addResult(resultAgentID(ctx, task.AgentId), task)
+}
+
+// resultAgentID определяет, какой агент прислал результат. В отличие
+// от identifyAgent не требует регистрации: результат агента, которого
+// успели забыть, все еще может пригодиться.
+func resultAgentID(ctx context.Context, agentID string) string {
+	if agentID != "" {
+		return agentID
+	}
+
+	return anonymousAgentPrefix + peerAddr(ctx)
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+
+	return "unknown"
+}
+
+// addResult принимает результат задачи и возвращает ошибку
+// в виде статуса gRPC.
+func (gs *grpcServer) addResult(agentID string, task *pb.TaskResult) error {
+	gs.o.registry.TouchByTask(task.Id)
+
 	if task.Error != "" {
 		slog.Warn(
 			"Agent returned calculation error",
 			slog.String("error", task.Error),
+			slog.String("kind", task.ErrorKind.String()),
+		)
+
+		err := gs.o.OnCalculationFailure(
+			agentID,
+			task.Id,
+			task.Error,
+			task.ErrorKind,
 		)
+		if isStaleResult(err) {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
 
-		err := orchestrator.OnCalculationFailure(task.Id)
 		if err != nil {
 			slog.Error(err.Error())
 		}
 
-		return nil, nil
+		return nil
 	}
 
 	slog.Info(
@@ -86,7 +312,17 @@ func (gs *grpcServer) AddResult(
 		slog.String("id", strconv.FormatUint(task.Id, 10)),
 	)
 
-	err := orchestrator.CompleteTask(task.Id, task.Result)
+	err := gs.o.CompleteTask(agentID, task.Id, task.Result)
+	if isStaleResult(err) {
+		slog.Warn(
+			"Agent sent a result for a task it no longer holds",
+			"id", task.Id,
+			"error", err,
+		)
+
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
 	if err != nil {
 		if !errors.Is(err, errTaskNotFound) {
 			slog.Warn(
@@ -96,8 +332,169 @@ func (gs *grpcServer) AddResult(
 			)
 		}
 
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return nil
+}
+
+// isStaleResult сообщает, что результат опоздал: аренда истекла,
+// задачу уже отдали другим агентам или выражение отменили.
+func isStaleResult(err error) bool {
+	return errors.Is(err, errLeaseExpired) ||
+		errors.Is(err, errReplicaNotAssigned) ||
+		errors.Is(err, errTaskReassigned) ||
+		errors.Is(err, errTaskCanceled)
+}
+
+func (gs *grpcServer) RenewLease(
+	_ context.Context,
+	req *pb.RenewLeaseRequest,
+) (*pb.Lease, error) {
+	gs.o.registry.TouchByTask(req.TaskId)
+
+	deadline, err := gs.o.RenewLease(req.TaskId)
+
+	switch {
+	case errors.Is(err, errTaskNotFound):
 		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errLeaseExpired):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return nil, nil
+	return &pb.Lease{
+		TaskId:   req.TaskId,
+		Deadline: timestamppb.New(deadline),
+	}, nil
+}
+
+// streamRetryDelay — пауза перед новой попыткой выдать задачу по стриму,
+// если выдача сорвалась, например из-за недоступности базы.
+const streamRetryDelay = time.Second
+
+// workCredit — сообщение агента о том, сколько еще задач он готов взять.
+type workCredit struct {
+	capacity uint32
+	agentID  string
+}
+
+// Work отправляет задачи агенту, как только они появляются в очереди,
+// не больше, чем агент готов принять. Результаты приходят по тому же
+// стриму.
+func (gs *grpcServer) Work(stream pb.TaskService_WorkServer) error {
+	ctx := stream.Context()
+
+	credits := make(chan workCredit)
+	recvErr := make(chan error, 1)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+
+			switch msg := req.Message.(type) {
+			case *pb.WorkRequest_Capacity:
+				agentID, err := gs.identifyAgent(ctx, req.AgentId)
+				if err != nil {
+					recvErr <- err
+					return
+				}
+
+				select {
+				case credits <- workCredit{msg.Capacity, agentID}:
+				case <-ctx.Done():
+					return
+				}
+			case *pb.WorkRequest_Result:
+				agentID := msg.Result.AgentId
+				if agentID == "" {
+					agentID = resultAgentID(ctx, req.AgentId)
+				}
+
+				err = gs.addResult(agentID, msg.Result)
+				if err != nil {
+					slog.Warn("Rejected task result from the stream",
+						"id", msg.Result.Id,
+						"error", err,
+					)
+				}
+			}
+		}
+	}()
+
+	var (
+		capacity    uint32
+		agentID     string
+		canceled    <-chan uint64
+		unsubscribe = func() {}
+	)
+
+	defer func() { unsubscribe() }()
+
+	for {
+		// Канал берем до попытки достать задачу,
+		// чтобы не пропустить появившиеся за это время
+		changed := gs.o.queue.Changed()
+		retry := (<-chan time.Time)(nil)
+
+		for capacity > 0 {
+			task, err := gs.o.StartProcessingNextTask(agentID)
+			if errors.Is(err, errNoTasksToProcess) {
+				break
+			}
+
+			if err != nil {
+				slog.Error("failed to start processing a task", "error", err)
+				retry = time.After(streamRetryDelay)
+
+				break
+			}
+
+			err = stream.Send(&pb.WorkResponse{
+				Message: &pb.WorkResponse_Task{Task: task},
+			})
+			if err != nil {
+				// Задачу вернет в очередь истечение аренды
+				return err
+			}
+
+			capacity--
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		case credit := <-credits:
+			capacity += credit.capacity
+
+			if credit.agentID != agentID || canceled == nil {
+				agentID = credit.agentID
+
+				unsubscribe()
+				canceled, unsubscribe = gs.o.cancels.Subscribe(agentID)
+			}
+		case taskID := <-canceled:
+			err := stream.Send(&pb.WorkResponse{
+				Message: &pb.WorkResponse_Cancel{
+					Cancel: &pb.TaskCancellation{TaskId: taskID},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		case <-changed:
+		case <-retry:
+		}
+	}
 }
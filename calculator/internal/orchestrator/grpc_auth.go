@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// checkAgentToken пропускает только вызовы, в метаданных которых
+// передан токен агента: "authorization: Bearer <токен>".
+func checkAgentToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, value := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(value, "Bearer ")
+		if !ok {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, errInvalidAgentToken.Error())
+}
+
+func agentAuthUnaryInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		_ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if err := checkAgentToken(ctx, token); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func agentAuthStreamInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		_ *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := checkAgentToken(ss.Context(), token); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,60 @@
+package orchestrator_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAgentAuthInterceptor(t *testing.T) {
+	interceptor := orchestrator.AgentAuthUnaryInterceptor("secret")
+
+	handler := func(context.Context, any) (any, error) {
+		return "ok", nil
+	}
+
+	cases := []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{
+			name: "valid token",
+			md:   metadata.Pairs("authorization", "Bearer secret"),
+			code: codes.OK,
+		},
+		{
+			name: "wrong token",
+			md:   metadata.Pairs("authorization", "Bearer nope"),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "no scheme",
+			md:   metadata.Pairs("authorization", "secret"),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "no metadata",
+			code: codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			if code := status.Code(err); code != tc.code {
+				t.Errorf("got code %v, want %v", code, tc.code)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dzherb/go_calculator/calculator/internal/auth"
 	"github.com/dzherb/go_calculator/calculator/internal/repository"
@@ -44,7 +46,10 @@ type ExpressionSimpleResponse struct {
 	Id uint64 `json:"id"`
 }
 
-func CalculateHandler(w http.ResponseWriter, r *http.Request) {
+func (o *Orchestrator) CalculateHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
 	exp := ExpressionRequest{}
 
 	err := json.NewDecoder(r.Body).Decode(&exp)
@@ -57,7 +62,7 @@ func CalculateHandler(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.Context().Value(UserIDKey).(uint64)
 
-	expId, err := orchestrator.CreateExpression(exp.Expression, userID)
+	expId, err := o.CreateExpression(exp.Expression, userID)
 	if err != nil {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		WriteError(w, err)
@@ -80,14 +85,124 @@ func CalculateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type BatchRequest struct {
+	Expressions []BatchItem `json:"expressions"`
+}
+
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// CalculateBatchHandler creates several expressions at once.
+// Invalid expressions are reported per item and do not fail the batch.
+func (o *Orchestrator) CalculateBatchHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	req := BatchRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, errInvalidRequestBody)
+
+		return
+	}
+
+	switch {
+	case len(req.Expressions) == 0:
+		err = errEmptyBatch
+	case len(req.Expressions) > maxBatchSize:
+		err = errBatchTooLarge
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, err)
+
+		return
+	}
+
+	userID := r.Context().Value(UserIDKey).(uint64)
+
+	results, err := o.CreateExpressions(req.Expressions, userID)
+	if err != nil {
+		slog.Error("Failed to create expressions",
+			slog.String("error", err.Error()),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	slog.Info(
+		"Created expression batch",
+		slog.Int("size", len(req.Expressions)),
+	)
+
+	err = json.NewEncoder(w).Encode(BatchResponse{Results: results})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+func (o *Orchestrator) EstimateHandler(w http.ResponseWriter, r *http.Request) {
+	exp := ExpressionRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&exp)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, errInvalidRequestBody)
+
+		return
+	}
+
+	estimate, err := o.EstimateExpression(exp.Expression)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		WriteError(w, err)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(estimate)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
 type ExpressionsResponse struct {
-	Expressions []repo.Expression `json:"expressions"`
+	Expressions []ExpressionView `json:"expressions"`
+	Total       int              `json:"total"`
+	// Пустой на последней странице
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-func ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+const (
+	defaultExpressionsLimit = 100
+	maxExpressionsLimit     = 1000
+)
+
+// ExpressionsHandler returns a page of the user's expressions.
+// The next page is requested with the next_cursor of the response.
+func (o *Orchestrator) ExpressionsHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
 	userID := r.Context().Value(UserIDKey).(uint64)
 
-	expressions, err := orchestrator.GetUserExpressions(userID)
+	query, err := expressionQueryFromRequest(r, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, err)
+
+		return
+	}
+
+	page, err := o.ListUserExpressions(query)
 	if err != nil {
 		slog.Error(
 			"Failed to get expressions",
@@ -100,7 +215,19 @@ func ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := ExpressionsResponse{
-		Expressions: expressions,
+		Expressions: make([]ExpressionView, 0, len(page.Expressions)),
+		Total:       page.Total,
+	}
+
+	if page.Next != nil {
+		response.NextCursor = page.Next.Encode()
+	}
+
+	for _, expr := range page.Expressions {
+		response.Expressions = append(
+			response.Expressions,
+			o.withETA(expr),
+		)
 	}
 
 	err = json.NewEncoder(w).Encode(&response)
@@ -116,16 +243,260 @@ func ExpressionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func ExpressionHandler(w http.ResponseWriter, r *http.Request) {
+// expressionQueryFromRequest читает из параметров запроса
+// страницу, фильтры и порядок списка выражений.
+func expressionQueryFromRequest(
+	r *http.Request,
+	userID uint64,
+) (repo.ExpressionListQuery, error) {
+	query := repo.ExpressionListQuery{
+		UserID: userID,
+		Filter: repo.ExpressionFilter{Contains: r.URL.Query().Get("search")},
+	}
+
+	var err error
+
+	query.Limit, err = limitFromQuery(
+		r,
+		defaultExpressionsLimit,
+		maxExpressionsLimit,
+	)
+	if err != nil {
+		return query, err
+	}
+
+	query.Sort, query.After, err = sortFromQuery(r)
+	if err != nil {
+		return query, err
+	}
+
+	query.Filter.Statuses, err = statusesFromQuery(r)
+	if err != nil {
+		return query, err
+	}
+
+	query.Filter.CreatedAfter, err = timeFromQuery(r, "created_after")
+	if err != nil {
+		return query, err
+	}
+
+	query.Filter.CreatedBefore, err = timeFromQuery(r, "created_before")
+	if err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// sortFromQuery читает порядок списка и курсор. Курсор задает порядок
+// сам, менять его посреди списка нельзя.
+func sortFromQuery(
+	r *http.Request,
+) (repo.ExpressionSort, *repo.ExpressionCursor, error) {
+	sort := repo.SortCreatedDesc
+
+	raw := r.URL.Query().Get("sort")
+	if raw != "" {
+		sort = repo.ExpressionSort(raw)
+		if !sort.IsValid() {
+			return "", nil, errInvalidSort
+		}
+	}
+
+	rawCursor := r.URL.Query().Get("cursor")
+	if rawCursor == "" {
+		return sort, nil, nil
+	}
+
+	cursor, err := repo.DecodeExpressionCursor(rawCursor)
+	if err != nil || (raw != "" && cursor.Sort != sort) {
+		return "", nil, errInvalidCursor
+	}
+
+	return cursor.Sort, &cursor, nil
+}
+
+// statusesFromQuery читает статусы через запятую, например
+// status=new,processing.
+func statusesFromQuery(r *http.Request) ([]repo.ExpressionStatus, error) {
+	raw := r.URL.Query().Get("status")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var statuses []repo.ExpressionStatus
+
+	for _, s := range strings.Split(raw, ",") {
+		status := repo.ExpressionStatus(strings.TrimSpace(s))
+		if !status.IsValid() {
+			return nil, errInvalidStatus
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func timeFromQuery(r *http.Request, name string) (*time.Time, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, errInvalidDate
+	}
+
+	return &t, nil
+}
+
+// maxExpressionWait ограничивает, сколько можно ждать завершения
+// выражения одним запросом.
+const maxExpressionWait = time.Minute
+
+// ExpressionHandler returns the expression. With the wait query parameter,
+// e.g. ?wait=30s, it responds once the expression is finished
+// or the time is up, whichever comes first.
+func (o *Orchestrator) ExpressionHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	wait, err := waitFromQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, err)
+
+		return
+	}
+
+	expr, ok := o.userExpressionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if wait > 0 && !expr.Status.IsFinished() {
+		// Ожидание не должно упираться в таймаут записи сервера
+		err = http.NewResponseController(w).
+			SetWriteDeadline(time.Now().Add(wait + writeTimeout))
+		if err != nil {
+			slog.Warn("Failed to extend write deadline", "error", err)
+		}
+
+		expr, err = o.WaitForExpression(r.Context(), expr.ID, wait)
+		if err != nil {
+			slog.Error("Failed to wait for expression",
+				slog.String("error", err.Error()),
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			WriteError(w, err)
+
+			return
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(o.withETA(expr))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// CancelExpressionHandler stops the evaluation of the user's expression.
+// Finished expressions cannot be canceled.
+func (o *Orchestrator) CancelExpressionHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	expr, ok := o.userExpressionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	expr, err := o.CancelExpression(expr.ID)
+	if err != nil {
+		if errors.Is(err, errExpressionFinished) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			slog.Error("Failed to cancel expression",
+				slog.String("error", err.Error()),
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		WriteError(w, err)
+
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(o.withETA(expr))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+func waitFromQuery(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, errInvalidWait
+	}
+
+	return min(wait, maxExpressionWait), nil
+}
+
+type StepsResponse struct {
+	Steps []repo.Step `json:"steps"`
+}
+
+func (o *Orchestrator) StepsHandler(w http.ResponseWriter, r *http.Request) {
+	expr, ok := o.userExpressionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	steps, err := o.GetExpressionSteps(expr.ID)
+	if err != nil {
+		slog.Error("Failed to get steps", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	if steps == nil {
+		steps = []repo.Step{}
+	}
+
+	err = json.NewEncoder(w).Encode(&StepsResponse{Steps: steps})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// userExpressionFromPath достает выражение по id из url
+// и проверяет, что оно принадлежит текущему пользователю.
+// В случае ошибки пишет ответ сам и возвращает false.
+func (o *Orchestrator) userExpressionFromPath(
+	w http.ResponseWriter,
+	r *http.Request,
+) (repo.Expression, bool) {
 	expressionId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		WriteError(w, errInvalidIdInUrl)
 
-		return
+		return repo.Expression{}, false
 	}
 
-	expr, err := orchestrator.GetExpression(expressionId)
+	expr, err := o.GetExpression(expressionId)
 	if err != nil {
 		if errors.Is(err, errExpressionNotFound) {
 			w.WriteHeader(http.StatusNotFound)
@@ -136,7 +507,7 @@ func ExpressionHandler(w http.ResponseWriter, r *http.Request) {
 
 		WriteError(w, err)
 
-		return
+		return repo.Expression{}, false
 	}
 
 	userID := r.Context().Value(UserIDKey).(uint64)
@@ -144,14 +515,10 @@ func ExpressionHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		WriteError(w, errExpressionNotFound)
 
-		return
+		return repo.Expression{}, false
 	}
 
-	err = json.NewEncoder(w).Encode(expr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		WriteError(w, err)
-	}
+	return expr, true
 }
 
 type authRequest struct {
@@ -159,14 +526,15 @@ type authRequest struct {
 	Password string `json:"password"`
 }
 
-var authService = auth.NewService()
-
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	baseAuthHandler(w, r, authService.Register)
+func (o *Orchestrator) RegisterHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	baseAuthHandler(w, r, o.auth.Register)
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	baseAuthHandler(w, r, authService.Login)
+func (o *Orchestrator) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	baseAuthHandler(w, r, o.auth.Login)
 }
 
 func baseAuthHandler(
@@ -199,10 +567,13 @@ func baseAuthHandler(
 	}
 }
 
-func CurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+func (o *Orchestrator) CurrentUserHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
 	userID := r.Context().Value(UserIDKey).(uint64)
 
-	user, err := repo.NewUserRepository().Get(userID)
+	user, err := o.repos.Users.Get(userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		WriteError(w, fmt.Errorf("failed to get user"))
@@ -12,15 +12,12 @@ import (
 const readTimeout = 15 * time.Second
 const writeTimeout = 15 * time.Second
 
-func (a *App) ServeHTTP(ctx context.Context) error {
-	mux := http.NewServeMux()
-	registerHandlers(mux)
-
-	addr := a.config.Host + ":" + a.config.Port
+func (o *Orchestrator) ServeHTTP(ctx context.Context) error {
+	addr := o.config.Host + ":" + o.config.Port
 
 	srv := http.Server{
-		Addr:         a.config.Host + ":" + a.config.Port,
-		Handler:      RecoverMiddleware(CommonMiddleware(mux)),
+		Addr:         o.config.Host + ":" + o.config.Port,
+		Handler:      o.Handler(),
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 	}
@@ -34,9 +31,23 @@ func (a *App) ServeHTTP(ctx context.Context) error {
 		}
 	}()
 
+	var err error
+
+	if o.config.HTTPTLS.Enabled() {
+		srv.TLSConfig, err = serverTLSConfig(ctx, o.config.HTTPTLS)
+		if err != nil {
+			return err
+		}
+	}
+
 	slog.Info(fmt.Sprintf("HTTP server is listening on %s", addr))
 
-	err := srv.ListenAndServe()
+	if srv.TLSConfig != nil {
+		// Сертификат уже в TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error(
@@ -52,42 +63,161 @@ func (a *App) ServeHTTP(ctx context.Context) error {
 	return nil
 }
 
-func registerHandlers(mux *http.ServeMux) {
+// Handler returns the HTTP API of the orchestrator.
+func (o *Orchestrator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	o.registerHandlers(mux)
+
+	return RecoverMiddleware(CommonMiddleware(mux))
+}
+
+func (o *Orchestrator) registerHandlers(mux *http.ServeMux) {
+	authRequired := AuthRequired(o.tokens.ValidateToken)
+	adminOnly := AdminOnly(o.isAdmin)
+	idempotent := Idempotent(
+		o.repos.IdempotencyKeys,
+		o.config.IdempotencyKeyTTL,
+		o.config.IdempotencyLockTTL,
+	)
+
 	mux.Handle("/api/v1/auth/register",
 		EnsureMethodsMiddleware(http.MethodPost)(
-			http.HandlerFunc(RegisterHandler),
+			http.HandlerFunc(o.RegisterHandler),
 		),
 	)
 	mux.Handle("/api/v1/auth/login",
 		EnsureMethodsMiddleware(http.MethodPost)(
-			http.HandlerFunc(LoginHandler),
+			http.HandlerFunc(o.LoginHandler),
 		),
 	)
 	mux.Handle("/api/v1/users/me",
-		AuthRequired(
+		authRequired(
 			EnsureMethodsMiddleware(http.MethodGet)(
-				http.HandlerFunc(CurrentUserHandler),
+				http.HandlerFunc(o.CurrentUserHandler),
 			),
 		),
 	)
 	mux.Handle("/api/v1/calculate",
-		AuthRequired(
+		authRequired(
 			EnsureMethodsMiddleware(http.MethodPost)(
-				http.HandlerFunc(CalculateHandler),
+				idempotent(
+					http.HandlerFunc(o.CalculateHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/v1/calculate/batch",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodPost)(
+				idempotent(
+					http.HandlerFunc(o.CalculateBatchHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/v1/estimate",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodPost)(
+				http.HandlerFunc(o.EstimateHandler),
 			),
 		),
 	)
 	mux.Handle("/api/v1/expressions",
-		AuthRequired(
+		authRequired(
 			EnsureMethodsMiddleware(http.MethodGet)(
-				http.HandlerFunc(ExpressionsHandler),
+				http.HandlerFunc(o.ExpressionsHandler),
 			),
 		),
 	)
+	mux.Handle("/api/v1/expressions/events",
+		TokenFromQuery(authRequired(
+			EnsureMethodsMiddleware(http.MethodGet)(
+				http.HandlerFunc(o.ExpressionEventsHandler),
+			),
+		)),
+	)
+	mux.Handle("/api/v1/expressions/events/ws",
+		TokenFromQuery(authRequired(
+			EnsureMethodsMiddleware(http.MethodGet)(
+				http.HandlerFunc(o.ExpressionEventsWebSocketHandler),
+			),
+		)),
+	)
 	mux.Handle("/api/v1/expressions/{id}",
-		AuthRequired(
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodGet)(
+				http.HandlerFunc(o.ExpressionHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/expressions/{id}/cancel",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodPost)(
+				http.HandlerFunc(o.CancelExpressionHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/expressions/{id}/steps",
+		authRequired(
 			EnsureMethodsMiddleware(http.MethodGet)(
-				http.HandlerFunc(ExpressionHandler),
+				http.HandlerFunc(o.StepsHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/webhooks",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodGet, http.MethodPost)(
+				http.HandlerFunc(o.WebhooksHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/webhooks/{id}",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodDelete)(
+				http.HandlerFunc(o.DeleteWebhookHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/webhooks/{id}/deliveries",
+		authRequired(
+			EnsureMethodsMiddleware(http.MethodGet)(
+				http.HandlerFunc(o.WebhookDeliveriesHandler),
+			),
+		),
+	)
+	mux.Handle("/api/v1/admin/agents",
+		authRequired(
+			adminOnly(
+				EnsureMethodsMiddleware(http.MethodGet)(
+					http.HandlerFunc(o.AgentsHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/v1/admin/agents/{id}/drain",
+		authRequired(
+			adminOnly(
+				EnsureMethodsMiddleware(http.MethodPost)(
+					http.HandlerFunc(o.DrainAgentHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/v1/admin/agents/{id}/restore",
+		authRequired(
+			adminOnly(
+				EnsureMethodsMiddleware(http.MethodPost)(
+					http.HandlerFunc(o.RestoreAgentHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/v1/admin/mismatches",
+		authRequired(
+			adminOnly(
+				EnsureMethodsMiddleware(http.MethodGet)(
+					http.HandlerFunc(o.MismatchesHandler),
+				),
 			),
 		),
 	)
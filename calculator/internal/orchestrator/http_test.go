@@ -0,0 +1,395 @@
+package orchestrator_test
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/security"
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeExpressions хранит выражения в памяти. Методы, которые
+// тестам не нужны, достаются от nil-интерфейса и паникуют.
+type fakeExpressions struct {
+	repo.ExpressionRepository
+	exprs map[uint64]repo.Expression
+	mu    sync.Mutex
+}
+
+func (f *fakeExpressions) Create(
+	expr repo.Expression,
+) (repo.Expression, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expr.ID = uint64(len(f.exprs) + 1)
+	expr.Status = repo.ExpressionNew
+	f.exprs[expr.ID] = expr
+
+	return expr, nil
+}
+
+func (f *fakeExpressions) CreateMany(
+	exprs []repo.Expression,
+) ([]repo.Expression, error) {
+	created := make([]repo.Expression, 0, len(exprs))
+
+	for _, expr := range exprs {
+		saved, err := f.Create(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		created = append(created, saved)
+	}
+
+	return created, nil
+}
+
+func (f *fakeExpressions) Get(id uint64) (repo.Expression, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expr, ok := f.exprs[id]
+	if !ok {
+		return repo.Expression{}, pgx.ErrNoRows
+	}
+
+	return expr, nil
+}
+
+func (f *fakeExpressions) Update(
+	expr repo.Expression,
+	_ repo.WebhookPayloadFunc,
+) (repo.Expression, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored, ok := f.exprs[expr.ID]
+
+	// Как и в базе, завершенное выражение больше не меняется
+	if !ok || stored.Status.IsFinished() {
+		return repo.Expression{}, pgx.ErrNoRows
+	}
+
+	stored.Status = expr.Status
+	stored.Result = expr.Result
+	stored.Error = expr.Error
+	f.exprs[expr.ID] = stored
+
+	return stored, nil
+}
+
+func (f *fakeExpressions) Cancel(
+	id uint64,
+	_ repo.WebhookPayloadFunc,
+) (repo.Expression, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored, ok := f.exprs[id]
+	if !ok || stored.Status.IsFinished() {
+		return repo.Expression{}, pgx.ErrNoRows
+	}
+
+	stored.Status = repo.ExpressionCanceled
+	f.exprs[id] = stored
+
+	return stored, nil
+}
+
+// ListForUser отдает выражения от новых к старым, сортировка
+// запроса не учитывается.
+func (f *fakeExpressions) ListForUser(
+	query repo.ExpressionListQuery,
+) (repo.ExpressionPage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []repo.Expression
+
+	for _, expr := range f.exprs {
+		if expr.UserID != query.UserID ||
+			(query.After != nil && expr.ID >= query.After.ID) {
+			continue
+		}
+
+		if len(query.Filter.Statuses) == 0 ||
+			slices.Contains(query.Filter.Statuses, expr.Status) {
+			matched = append(matched, expr)
+		}
+	}
+
+	slices.SortFunc(matched, func(a, b repo.Expression) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	page := repo.ExpressionPage{Expressions: matched, Total: len(matched)}
+
+	if len(matched) > query.Limit {
+		page.Expressions = matched[:query.Limit]
+		page.Next = &repo.ExpressionCursor{
+			Sort: query.Sort,
+			ID:   matched[query.Limit-1].ID,
+		}
+	}
+
+	return page, nil
+}
+
+type fakeSteps struct {
+	repo.StepRepository
+}
+
+func (fakeSteps) Create(step repo.Step) (repo.Step, error) {
+	return step, nil
+}
+
+// failingSteps не может сохранить ни одного шага.
+type failingSteps struct {
+	repo.StepRepository
+}
+
+func (failingSteps) Create(repo.Step) (repo.Step, error) {
+	return repo.Step{}, errors.New("database is unavailable")
+}
+
+type fakeStates struct {
+	repo.StateRepository
+}
+
+func (fakeStates) Save(
+	state repo.EvaluationState,
+) (repo.EvaluationState, error) {
+	return state, nil
+}
+
+type fakeTasks struct {
+	repo.TaskRepository
+}
+
+// ReserveIDRange отдает всем один диапазон: задачи в памяти
+// одного оркестратора не пересекутся и так.
+func (fakeTasks) ReserveIDRange() (uint64, error) {
+	return 0, nil
+}
+
+func (fakeTasks) Create(tasks []repo.Task) ([]repo.Task, error) {
+	return tasks, nil
+}
+
+// UpdateStatus ведет себя как для задач, вычисленных на месте:
+// в базу они не попадают.
+func (fakeTasks) UpdateStatus(
+	uint64,
+	repo.TaskStatus,
+) (repo.Task, error) {
+	return repo.Task{}, pgx.ErrNoRows
+}
+
+func (fakeTasks) Retry(id uint64, attempts int) (repo.Task, error) {
+	return repo.Task{ID: id, Attempts: attempts}, nil
+}
+
+func newTestOrchestrator(secretKey string) *orchestrator.Orchestrator {
+	return newTestOrchestratorWithRepos(secretKey, fakeRepositories())
+}
+
+func fakeRepositories() orchestrator.Repositories {
+	return orchestrator.Repositories{
+		Expressions: &fakeExpressions{
+			exprs: make(map[uint64]repo.Expression),
+		},
+		Steps:  fakeSteps{},
+		States: fakeStates{},
+		Tasks:  fakeTasks{},
+	}
+}
+
+func newTestOrchestratorWithRepos(
+	secretKey string,
+	repos orchestrator.Repositories,
+) *orchestrator.Orchestrator {
+	return orchestrator.New(
+		&orchestrator.Config{
+			SecretKey:          secretKey,
+			AccessTokenTTL:     time.Hour,
+			AgentIdleTimeout:   time.Minute,
+			OwnershipLeaseTTL:  time.Minute,
+			TaskMaxProcessTime: time.Minute,
+			VerifyReplicas:     1,
+			VerifyQuorum:       1,
+			WebhookMaxAttempts: 1,
+		},
+		orchestrator.Dependencies{Repos: repos},
+	)
+}
+
+func issueToken(t *testing.T, secretKey string, userID uint64) string {
+	t.Helper()
+
+	tokens := security.NewTokenManager(security.Config{
+		SecretKey:      secretKey,
+		AccessTokenTTL: time.Hour,
+	})
+
+	token, err := tokens.IssueAccessToken(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func serve(
+	o *orchestrator.Orchestrator,
+	method, target, token, body string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", orchestrator.TokenPrefix+token)
+
+	rr := httptest.NewRecorder()
+	o.Handler().ServeHTTP(rr, req)
+
+	return rr
+}
+
+// calculate создает выражение и возвращает путь к нему.
+func calculate(
+	t *testing.T,
+	o *orchestrator.Orchestrator,
+	token, expression string,
+) string {
+	t.Helper()
+
+	rr := serve(o, http.MethodPost, "/api/v1/calculate", token,
+		`{"expression": "`+expression+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	created := orchestrator.ExpressionSimpleResponse{}
+
+	err := json.NewDecoder(rr.Body).Decode(&created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "/api/v1/expressions/" + strconv.FormatUint(created.Id, 10)
+}
+
+func getExpression(
+	t *testing.T,
+	o *orchestrator.Orchestrator,
+	token, target string,
+) repo.Expression {
+	t.Helper()
+
+	rr := serve(o, http.MethodGet, target, token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	expr := repo.Expression{}
+
+	err := json.NewDecoder(rr.Body).Decode(&expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return expr
+}
+
+func TestCalculateWithoutAgents(t *testing.T) {
+	t.Parallel()
+
+	o := newTestOrchestrator("secret")
+	token := issueToken(t, "secret", 1)
+
+	// Агентов нет, поэтому оркестратор вычисляет выражение сам
+	target := calculate(t, o, token, "2+3*4")
+
+	expr := getExpression(t, o, token, target)
+	if expr.Status != repo.ExpressionSucceed ||
+		expr.Result == nil || *expr.Result != 14 {
+		t.Errorf("got expression %+v, want 14", expr)
+	}
+
+	// Чужое выражение не видно
+	rr := serve(o, http.MethodGet, target, issueToken(t, "secret", 2), "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCalculateWhenStepsAreNotSaved(t *testing.T) {
+	t.Parallel()
+
+	repos := fakeRepositories()
+	repos.Steps = failingSteps{}
+	o := newTestOrchestratorWithRepos("secret", repos)
+	token := issueToken(t, "secret", 1)
+
+	// Шаги только отражают вычисление и не должны его останавливать
+	target := calculate(t, o, token, "(1+2)*(3+4)")
+
+	expr := getExpression(t, o, token, target)
+	if expr.Status != repo.ExpressionSucceed ||
+		expr.Result == nil || *expr.Result != 21 {
+		t.Errorf("got expression %+v, want 21", expr)
+	}
+}
+
+func TestOrchestratorsDoNotShareTokens(t *testing.T) {
+	t.Parallel()
+
+	first := newTestOrchestrator("first")
+	second := newTestOrchestrator("second")
+	token := issueToken(t, "first", 1)
+
+	rr := serve(second, http.MethodGet, "/api/v1/expressions/1", token, "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = serve(first, http.MethodGet, "/api/v1/expressions/1", token, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestExpressionsHandlerRejectsBadQueries(t *testing.T) {
+	t.Parallel()
+
+	o := newTestOrchestrator("secret")
+	token := issueToken(t, "secret", 1)
+	ascCursor := repo.ExpressionCursor{Sort: repo.SortCreatedAsc}.Encode()
+
+	queries := []string{
+		"limit=0",
+		"sort=expression",
+		"cursor=garbage",
+		"cursor=" + ascCursor + "&sort=-created_at",
+		"status=new,done",
+		"created_after=yesterday",
+	}
+
+	for _, query := range queries {
+		rr := serve(o, http.MethodGet, "/api/v1/expressions?"+query, token, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %v, want %v",
+				query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// hubBuffer — сколько событий может ждать подписчика.
+	hubBuffer = 64
+	// hubHistorySize — сколько последних событий хранится
+	// для переподключившихся клиентов.
+	hubHistorySize = 4096
+)
+
+// ExpressionEvent is a change of an expression seen by its owner.
+type ExpressionEvent struct {
+	Seq    uint64                `json:"-"`
+	UserID uint64                `json:"-"`
+	ID     uint64                `json:"id"`
+	Status repo.ExpressionStatus `json:"status"`
+	// Процент уже вычисленных операций
+	Progress int      `json:"progress"`
+	Result   *float64 `json:"result"`
+	Error    *string  `json:"error"`
+}
+
+func expressionEvent(expr repo.Expression) ExpressionEvent {
+	return ExpressionEvent{
+		UserID: expr.UserID,
+		ID:     expr.ID,
+		Status: expr.Status,
+		Result: expr.Result,
+		Error:  expr.Error,
+	}
+}
+
+// trackedExpression — вычисляемое выражение, о котором рассылаются события.
+type trackedExpression struct {
+	userID   uint64
+	total    int // Число операций в выражении
+	progress int
+}
+
+// expressionHub рассылает изменения выражений тем, кто их ждет,
+// и помнит последние события, чтобы клиент, потерявший соединение,
+// получил пропущенное.
+type expressionHub struct {
+	byExpression map[uint64]map[chan ExpressionEvent]struct{}
+	byUser       map[uint64]map[chan ExpressionEvent]struct{}
+	tracked      map[uint64]*trackedExpression
+	history      []ExpressionEvent // Кольцевой буфер
+	next         int               // Куда запишется следующее событие
+	seq          uint64
+	mu           sync.Mutex
+}
+
+func newExpressionHub(now func() time.Time) *expressionHub {
+	return &expressionHub{
+		byExpression: make(map[uint64]map[chan ExpressionEvent]struct{}),
+		byUser:       make(map[uint64]map[chan ExpressionEvent]struct{}),
+		tracked:      make(map[uint64]*trackedExpression),
+		history:      make([]ExpressionEvent, 0, hubHistorySize),
+		// Номера событий растут и между перезапусками: номер из прошлого
+		// запуска не должен совпасть с новым событием
+		seq: uint64(now().UnixMicro()), //nolint:gosec
+	}
+}
+
+// Track starts reporting the progress of the expression.
+// Done is the number of operations that were computed before.
+func (h *expressionHub) Track(userID, exprID uint64, done, remaining int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tracked[exprID] = &trackedExpression{
+		userID:   userID,
+		total:    done + remaining,
+		progress: percent(done, done+remaining),
+	}
+}
+
+// Subscribe returns a channel with changes of the expression.
+// A subscriber that falls behind loses the oldest changes,
+// but always gets the latest one.
+// The returned function must be called once the changes are not needed.
+func (h *expressionHub) Subscribe(
+	exprID uint64,
+) (<-chan ExpressionEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.subscribe(h.byExpression, exprID)
+}
+
+// Awaited returns the ids of expressions that someone is subscribed to.
+func (h *expressionHub) Awaited() []uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ids := make([]uint64, 0, len(h.byExpression))
+	for id := range h.byExpression {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// UserSubscription delivers changes of all expressions of a user.
+type UserSubscription struct {
+	// Пропущенные события или, если их уже не восстановить,
+	// текущее состояние всех выражений пользователя
+	Missed   []ExpressionEvent
+	Replayed bool
+	Seq      uint64 // Номер последнего события на момент подписки
+	// Канал закрывается, если подписчик не успевает забирать события,
+	// тогда ему нужно подписаться заново
+	Events <-chan ExpressionEvent
+	Close  func()
+}
+
+// SubscribeUser subscribes to changes of all expressions of the user.
+// Changes after the lastSeq event are returned right away if they are
+// still remembered, then Replayed is true. Zero lastSeq means
+// a new subscriber.
+func (h *expressionHub) SubscribeUser(
+	userID uint64,
+	lastSeq uint64,
+) UserSubscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := UserSubscription{
+		Replayed: lastSeq != 0 && lastSeq == h.seq,
+		Seq:      h.seq,
+	}
+
+	if lastSeq != 0 {
+		for _, event := range h.history {
+			if event.Seq == lastSeq+1 {
+				sub.Replayed = true
+			}
+
+			if event.Seq > lastSeq && event.UserID == userID {
+				sub.Missed = append(sub.Missed, event)
+			}
+		}
+
+		// История хранится по кругу, восстанавливаем порядок
+		slices.SortFunc(sub.Missed, func(a, b ExpressionEvent) int {
+			return cmp.Compare(a.Seq, b.Seq)
+		})
+	}
+
+	if !sub.Replayed {
+		sub.Missed = nil
+	}
+
+	sub.Events, sub.Close = h.subscribe(h.byUser, userID)
+
+	return sub
+}
+
+// Snapshot describes the current state of the expression.
+// The event is not numbered and not sent to anyone.
+func (h *expressionHub) Snapshot(expr repo.Expression) ExpressionEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event := expressionEvent(expr)
+	h.fill(&event)
+
+	return event
+}
+
+func (h *expressionHub) subscribe(
+	subscribers map[uint64]map[chan ExpressionEvent]struct{},
+	id uint64,
+) (<-chan ExpressionEvent, func()) {
+	ch := make(chan ExpressionEvent, hubBuffer)
+
+	if subscribers[id] == nil {
+		subscribers[id] = make(map[chan ExpressionEvent]struct{})
+	}
+
+	subscribers[id][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(subscribers[id], ch)
+
+		if len(subscribers[id]) == 0 {
+			delete(subscribers, id)
+		}
+	}
+}
+
+// Publish numbers the event and sends it to the subscribers.
+func (h *expressionHub) Publish(event ExpressionEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.publish(event)
+}
+
+// PublishProgress reports how many operations of the expression
+// are still to be computed.
+func (h *expressionHub) PublishProgress(exprID uint64, remaining int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tracked, ok := h.tracked[exprID]
+	if !ok {
+		return
+	}
+
+	progress := percent(tracked.total-remaining, tracked.total)
+	if progress == tracked.progress {
+		return
+	}
+
+	tracked.progress = progress
+
+	h.publish(ExpressionEvent{
+		UserID: tracked.userID,
+		ID:     exprID,
+		Status: repo.ExpressionProcessing,
+	})
+}
+
+func (h *expressionHub) publish(event ExpressionEvent) {
+	h.fill(&event)
+
+	if event.Status.IsFinished() {
+		delete(h.tracked, event.ID)
+	}
+
+	h.seq++
+	event.Seq = h.seq
+
+	if len(h.history) < hubHistorySize {
+		h.history = append(h.history, event)
+	} else {
+		h.history[h.next] = event
+	}
+
+	h.next = (h.next + 1) % hubHistorySize
+
+	for ch := range h.byExpression[event.ID] {
+		sendLatest(ch, event)
+	}
+
+	for ch := range h.byUser[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			// Пропускать события нельзя: пусть клиент переподключится
+			// и получит их из истории
+			delete(h.byUser[event.UserID], ch)
+			close(ch)
+		}
+	}
+}
+
+// fill дополняет событие тем, что известно о вычислении выражения.
+func (h *expressionHub) fill(event *ExpressionEvent) {
+	if tracked, ok := h.tracked[event.ID]; ok {
+		if event.UserID == 0 {
+			event.UserID = tracked.userID
+		}
+
+		event.Progress = tracked.progress
+	}
+
+	if event.Status == repo.ExpressionSucceed {
+		event.Progress = 100
+	}
+}
+
+// sendLatest отправляет событие, вытесняя самое старое,
+// если подписчик не успевает их забирать.
+func sendLatest(ch chan ExpressionEvent, event ExpressionEvent) {
+	select {
+	case ch <- event:
+		return
+	default:
+	}
+
+	select {
+	case <-ch:
+	default:
+	}
+
+	select {
+	case ch <- event:
+	default:
+	}
+}
+
+func percent(done, total int) int {
+	if total == 0 {
+		return 0
+	}
+
+	return done * 100 / total
+}
+
+// updateExpression сохраняет выражение и оповещает тех, кто его ждет.
+// Вебхуки о завершенном выражении репозиторий ставит в очередь вместе
+// со статусом, остается лишь разбудить рассылку. Если выражение
+// уже завершено или им владеет другой оркестратор, вычисление
+// прекращается, а оповещений не будет.
+func (o *Orchestrator) updateExpression(
+	expr repo.Expression,
+) (repo.Expression, error) {
+	updated, err := o.repos.Expressions.Update(expr, webhookPayload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Expression{}, o.rejectUpdate(expr.ID)
+	}
+
+	if err != nil {
+		return repo.Expression{}, err
+	}
+
+	o.hub.Publish(expressionEvent(updated))
+
+	if updated.Status.IsFinished() {
+		o.webhooks.Wake()
+	}
+
+	return updated, nil
+}
+
+// rejectUpdate прекращает вычисление выражения, которое база отказалась
+// сохранить, и объясняет почему: errExpressionFinished, если выражение
+// успели отменить, и errOwnershipLost, если его забрал другой оркестратор.
+func (o *Orchestrator) rejectUpdate(id uint64) error {
+	current, err := o.repos.Expressions.Get(id)
+	if err == nil && current.Status.IsFinished() {
+		// Отмена пришла, пока мы вычисляли: о ней уже оповестил
+		// тот, кто отменил
+		o.stopExpression(id)
+		return errExpressionFinished
+	}
+
+	slog.Warn("Lost ownership of expression", "expression_id", id)
+	o.forgetExpression(id)
+
+	return errOwnershipLost
+}
+
+// publishProgress сообщает владельцу выражения,
+// какая часть операций уже вычислена.
+func (o *Orchestrator) publishProgress(expr *calc.Expression) {
+	o.hub.PublishProgress(expr.Id, expr.Stats(o.getOperationTime).Nodes)
+}
+
+// userSnapshotSize — сколько последних завершенных выражений попадает
+// в начальное состояние потока. Остальные есть в списке выражений.
+const userSnapshotSize = defaultExpressionsLimit
+
+// SubscribeUserExpressions подписывает пользователя на изменения
+// его выражений. Новому подписчику и тому, чьи пропущенные события
+// уже забыты, сначала отдается текущее состояние его выражений.
+func (o *Orchestrator) SubscribeUserExpressions(
+	userID uint64,
+	lastSeq uint64,
+) (UserSubscription, error) {
+	sub := o.hub.SubscribeUser(userID, lastSeq)
+	if sub.Replayed {
+		return sub, nil
+	}
+
+	exprs, err := o.userSnapshot(userID)
+	if err != nil {
+		sub.Close()
+		return UserSubscription{}, err
+	}
+
+	for _, expr := range exprs {
+		sub.Missed = append(sub.Missed, o.hub.Snapshot(expr))
+	}
+
+	// Номер получает только последнее событие: переподключившись
+	// посреди состояния, клиент получит его целиком заново
+	if len(sub.Missed) > 0 {
+		sub.Missed[len(sub.Missed)-1].Seq = sub.Seq
+	}
+
+	return sub, nil
+}
+
+// userSnapshot возвращает все незавершенные выражения пользователя
+// и последние из завершенных, недавно измененные — первыми.
+func (o *Orchestrator) userSnapshot(userID uint64) ([]repo.Expression, error) {
+	query := repo.ExpressionListQuery{
+		UserID: userID,
+		Filter: repo.ExpressionFilter{
+			Statuses: []repo.ExpressionStatus{
+				repo.ExpressionNew,
+				repo.ExpressionProcessing,
+			},
+		},
+		Sort:  repo.SortUpdatedDesc,
+		Limit: maxExpressionsLimit,
+	}
+
+	var exprs []repo.Expression
+
+	// Незавершенных выражений немного, их отдаем все
+	for {
+		page, err := o.repos.Expressions.ListForUser(query)
+		if err != nil {
+			return nil, err
+		}
+
+		exprs = append(exprs, page.Expressions...)
+
+		if page.Next == nil {
+			break
+		}
+
+		query.After = page.Next
+	}
+
+	query.Filter.Statuses = []repo.ExpressionStatus{
+		repo.ExpressionSucceed,
+		repo.ExpressionAborted,
+		repo.ExpressionFailed,
+		repo.ExpressionCanceled,
+	}
+	query.Limit = userSnapshotSize
+	query.After = nil
+
+	page, err := o.repos.Expressions.ListForUser(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(exprs, page.Expressions...), nil
+}
+
+// WaitForExpression ждет, пока выражение завершится, но не дольше timeout,
+// и возвращает его последнее состояние.
+func (o *Orchestrator) WaitForExpression(
+	ctx context.Context,
+	id uint64,
+	timeout time.Duration,
+) (repo.Expression, error) {
+	events, unsubscribe := o.hub.Subscribe(id)
+	defer unsubscribe()
+
+	// Читаем после подписки, чтобы не пропустить завершение между ними
+	expr, err := o.GetExpression(id)
+	if err != nil || expr.Status.IsFinished() {
+		return expr, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return expr, nil
+		case event := <-events:
+			if event.Status.IsFinished() {
+				return o.GetExpression(id)
+			}
+		}
+	}
+}
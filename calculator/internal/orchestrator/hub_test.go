@@ -0,0 +1,185 @@
+package orchestrator_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+func TestExpressionHubPublish(t *testing.T) {
+	h := orchestrator.NewExpressionHub()
+
+	events, unsubscribe := h.Subscribe(1)
+	defer unsubscribe()
+
+	other, unsubscribeOther := h.Subscribe(2)
+	defer unsubscribeOther()
+
+	h.Publish(orchestrator.ExpressionEvent{
+		ID:     1,
+		Status: repo.ExpressionSucceed,
+	})
+
+	select {
+	case event := <-events:
+		if event.Status != repo.ExpressionSucceed || event.Progress != 100 {
+			t.Errorf("got event %+v, want a finished expression", event)
+		}
+	default:
+		t.Fatal("subscriber got no event")
+	}
+
+	select {
+	case event := <-other:
+		t.Errorf("got event %+v of another expression", event)
+	default:
+	}
+}
+
+func TestExpressionHubKeepsLatestEvent(t *testing.T) {
+	h := orchestrator.NewExpressionHub()
+
+	events, unsubscribe := h.Subscribe(1)
+	defer unsubscribe()
+
+	for range 100 {
+		h.Publish(orchestrator.ExpressionEvent{
+			ID:     1,
+			Status: repo.ExpressionProcessing,
+		})
+	}
+
+	h.Publish(orchestrator.ExpressionEvent{
+		ID:     1,
+		Status: repo.ExpressionFailed,
+	})
+
+	var last orchestrator.ExpressionEvent
+
+	for len(events) > 0 {
+		last = <-events
+	}
+
+	if last.Status != repo.ExpressionFailed {
+		t.Errorf("got last status %q, want %q",
+			last.Status, repo.ExpressionFailed)
+	}
+}
+
+func TestExpressionHubProgress(t *testing.T) {
+	h := orchestrator.NewExpressionHub()
+	h.Track(7, 1, 0, 4)
+
+	sub := h.SubscribeUser(7, 0)
+	defer sub.Close()
+
+	events := sub.Events
+
+	h.PublishProgress(1, 3)
+	h.PublishProgress(1, 3) // Прогресс не изменился
+	h.PublishProgress(1, 1)
+
+	for _, want := range []int{25, 75} {
+		event := <-events
+		if event.Progress != want || event.UserID != 7 {
+			t.Errorf("got event %+v, want progress %d", event, want)
+		}
+	}
+
+	if len(events) != 0 {
+		t.Errorf("got %d extra events", len(events))
+	}
+}
+
+func TestSubscribeUserExpressionsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	exprs := &fakeExpressions{exprs: make(map[uint64]repo.Expression)}
+
+	for id := uint64(1); id <= 150; id++ {
+		status := repo.ExpressionSucceed
+		if id == 3 {
+			status = repo.ExpressionProcessing
+		}
+
+		exprs.exprs[id] = repo.Expression{ID: id, UserID: 1, Status: status}
+	}
+
+	repos := fakeRepositories()
+	repos.Expressions = exprs
+	o := newTestOrchestratorWithRepos("secret", repos)
+
+	sub, err := o.SubscribeUserExpressions(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// Незавершенное выражение и сотня последних завершенных
+	if len(sub.Missed) != 101 {
+		t.Fatalf("got %d expressions in the snapshot, want 101",
+			len(sub.Missed))
+	}
+
+	if sub.Missed[0].ID != 3 || sub.Missed[1].ID != 150 ||
+		sub.Missed[100].ID != 51 {
+		t.Errorf("got snapshot from %d, %d to %d, want 3, 150 to 51",
+			sub.Missed[0].ID, sub.Missed[1].ID, sub.Missed[100].ID)
+	}
+
+	if sub.Missed[100].Seq != sub.Seq {
+		t.Error("the last event of the snapshot has no number")
+	}
+}
+
+func TestExpressionHubReplay(t *testing.T) {
+	h := orchestrator.NewExpressionHub()
+
+	sub := h.SubscribeUser(7, 0)
+
+	if sub.Replayed {
+		t.Error("a new subscriber got replayed events")
+	}
+
+	h.Publish(orchestrator.ExpressionEvent{
+		UserID: 7,
+		ID:     1,
+		Status: repo.ExpressionNew,
+	})
+
+	first := <-sub.Events
+
+	sub.Close()
+
+	h.Publish(orchestrator.ExpressionEvent{
+		UserID: 8,
+		ID:     2,
+		Status: repo.ExpressionNew,
+	})
+	h.Publish(orchestrator.ExpressionEvent{
+		UserID: 7,
+		ID:     1,
+		Status: repo.ExpressionSucceed,
+	})
+
+	sub = h.SubscribeUser(7, first.Seq)
+	defer sub.Close()
+
+	if !sub.Replayed {
+		t.Error("events after a known one were not replayed")
+	}
+
+	if len(sub.Missed) != 1 ||
+		sub.Missed[0].Status != repo.ExpressionSucceed {
+		t.Errorf("got missed events %+v, want only the user's one",
+			sub.Missed)
+	}
+
+	unknown := h.SubscribeUser(7, 42)
+	defer unknown.Close()
+
+	if unknown.Replayed {
+		t.Error("events after an unknown one were reported as replayed")
+	}
+}
@@ -0,0 +1,212 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 256
+
+// responseRecorder запоминает ответ, который уходит клиенту.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the stored response when a request is repeated
+// with the same Idempotency-Key header, so that a retried request
+// does not create anything twice. Keys are kept per user for the ttl.
+// A request that did not respond within the lock duration, e.g. because
+// the process died, is considered abandoned and may be retried.
+// Requests without the header pass through. It expects AuthRequired
+// to run first.
+func Idempotent(
+	keys repo.IdempotencyRepository,
+	ttl time.Duration,
+	lock time.Duration,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				w.WriteHeader(http.StatusBadRequest)
+				WriteError(w, errIdempotencyKeyTooLong)
+
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				WriteError(w, errInvalidRequestBody)
+
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			userID := r.Context().Value(UserIDKey).(uint64)
+			reservation := repo.IdempotencyKey{
+				UserID:      userID,
+				Key:         key,
+				Fingerprint: requestFingerprint(r, body),
+			}
+
+			reserved, err := keys.Reserve(reservation, ttl, lock)
+			if errors.Is(err, pgx.ErrNoRows) {
+				replayResponse(w, keys, reservation)
+				return
+			}
+
+			if err != nil {
+				slog.Error("Failed to reserve idempotency key",
+					slog.String("error", err.Error()),
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				WriteError(w, err)
+
+				return
+			}
+
+			// Упавший обработчик ответа не оставит: освобождаем ключ,
+			// чтобы запрос можно было повторить
+			defer func() {
+				if err := recover(); err != nil {
+					releaseKey(keys, reserved)
+					panic(err)
+				}
+			}()
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			storeResponse(keys, reserved, recorder)
+		})
+	}
+}
+
+// replayResponse отдает ответ на запрос, который уже занял ключ.
+func replayResponse(
+	w http.ResponseWriter,
+	keys repo.IdempotencyRepository,
+	reservation repo.IdempotencyKey,
+) {
+	stored, err := keys.Get(reservation.UserID, reservation.Key)
+	if err != nil {
+		// Ключ могли освободить, пока мы его искали
+		w.WriteHeader(http.StatusConflict)
+		WriteError(w, errIdempotencyKeyInUse)
+
+		return
+	}
+
+	if stored.Fingerprint != reservation.Fingerprint {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		WriteError(w, errIdempotencyKeyReused)
+
+		return
+	}
+
+	if stored.StatusCode == nil {
+		w.WriteHeader(http.StatusConflict)
+		WriteError(w, errIdempotencyKeyInUse)
+
+		return
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*stored.StatusCode)
+
+	_, err = w.Write(stored.Response)
+	if err != nil {
+		slog.Error("Failed to write response",
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// storeResponse сохраняет ответ для повторов. Ответ с ошибкой сервера
+// не сохраняем: такой запрос клиент должен иметь возможность повторить.
+func storeResponse(
+	keys repo.IdempotencyRepository,
+	reservation repo.IdempotencyKey,
+	recorder *responseRecorder,
+) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	if recorder.status >= http.StatusInternalServerError {
+		releaseKey(keys, reservation)
+		return
+	}
+
+	reservation.StatusCode = &recorder.status
+	reservation.Response = recorder.body.Bytes()
+	_, err := keys.Complete(reservation)
+	if err != nil {
+		slog.Error("Failed to store idempotent response",
+			slog.String("key", reservation.Key),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// releaseKey освобождает ключ запроса, ответ на который не сохраняется.
+func releaseKey(
+	keys repo.IdempotencyRepository,
+	reservation repo.IdempotencyKey,
+) {
+	err := keys.Delete(reservation)
+	if err != nil {
+		slog.Error("Failed to release idempotency key",
+			slog.String("key", reservation.Key),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// requestFingerprint отличает повтор запроса от другого запроса
+// с тем же ключом.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,109 @@
+package orchestrator_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+const (
+	idempotencyTTL     = time.Hour
+	idempotencyLockTTL = time.Minute
+)
+
+func TestIdempotentWithoutKey(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rr := httptest.NewRecorder()
+
+	idempotent := orchestrator.Idempotent(nil, idempotencyTTL, idempotencyLockTTL)
+	idempotent(next).ServeHTTP(rr, req)
+
+	if calls != 1 || rr.Code != http.StatusOK {
+		t.Errorf("got %d calls and status %v, want the request to pass",
+			calls, rr.Code)
+	}
+}
+
+func TestIdempotentRejectsLongKey(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request with an invalid key reached the handler")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(
+		orchestrator.IdempotencyKeyHeader,
+		strings.Repeat("k", 257),
+	)
+
+	rr := httptest.NewRecorder()
+
+	idempotent := orchestrator.Idempotent(nil, idempotencyTTL, idempotencyLockTTL)
+	idempotent(next).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// fakeIdempotencyKeys помнит занятые ключи в памяти.
+type fakeIdempotencyKeys struct {
+	repo.IdempotencyRepository
+	reserved map[string]struct{}
+}
+
+func (f *fakeIdempotencyKeys) Reserve(
+	key repo.IdempotencyKey,
+	_ time.Duration,
+	_ time.Duration,
+) (repo.IdempotencyKey, error) {
+	f.reserved[key.Key] = struct{}{}
+	return key, nil
+}
+
+func (f *fakeIdempotencyKeys) Delete(key repo.IdempotencyKey) error {
+	delete(f.reserved, key.Key)
+	return nil
+}
+
+func TestIdempotentReleasesKeyOnPanic(t *testing.T) {
+	keys := &fakeIdempotencyKeys{reserved: make(map[string]struct{})}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(orchestrator.IdempotencyKeyHeader, "key")
+	req = req.WithContext(
+		context.WithValue(req.Context(), orchestrator.UserIDKey, uint64(1)),
+	)
+
+	rr := httptest.NewRecorder()
+	idempotent := orchestrator.Idempotent(
+		keys,
+		idempotencyTTL,
+		idempotencyLockTTL,
+	)
+
+	orchestrator.RecoverMiddleware(idempotent(next)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %v, want %v",
+			rr.Code, http.StatusInternalServerError)
+	}
+
+	if len(keys.reserved) != 0 {
+		t.Error("key of the failed request is still reserved")
+	}
+}
@@ -0,0 +1,71 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// TaskIDAllocator hands out ids of new tasks. Resume calls Reserve
+// before any task is created, so that a broken database is noticed
+// at startup rather than in the middle of an evaluation.
+type TaskIDAllocator interface {
+	calc.IDSource
+	Reserve() error
+}
+
+// rangeAllocator выдает id задач из диапазона, зарезервированного
+// в базе: так они не совпадут с задачами прошлых запусков
+// и других оркестраторов.
+type rangeAllocator struct {
+	tasks repo.TaskRepository
+	last  uint64
+	end   uint64
+	mu    sync.Mutex
+}
+
+func newRangeAllocator(tasks repo.TaskRepository) *rangeAllocator {
+	return &rangeAllocator{tasks: tasks}
+}
+
+// Reserve starts a new range of ids.
+func (a *rangeAllocator) Reserve() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.reserve()
+}
+
+func (a *rangeAllocator) reserve() error {
+	first, err := a.tasks.ReserveIDRange()
+	if err != nil {
+		return err
+	}
+
+	a.last = first
+	a.end = first + 1<<repo.TaskIDRangeBits
+
+	return nil
+}
+
+// NextID returns the next id of the range. A used up range is replaced
+// by a new one. Without it tasks cannot be created at all, so failing
+// to reserve it is fatal, though a range is too large to use up in
+// practice.
+func (a *rangeAllocator) NextID() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.last+1 >= a.end {
+		err := a.reserve()
+		if err != nil {
+			panic(fmt.Sprintf("failed to reserve task ids: %v", err))
+		}
+	}
+
+	a.last++
+
+	return a.last
+}
@@ -0,0 +1,55 @@
+package orchestrator_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+// rangeTasks резервирует диапазоны id подряд, как последовательность
+// в базе.
+type rangeTasks struct {
+	repo.TaskRepository
+	ranges uint64
+	mu     sync.Mutex
+}
+
+func (r *rangeTasks) ReserveIDRange() (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ranges++
+
+	return r.ranges << repo.TaskIDRangeBits, nil
+}
+
+func TestRangeAllocatorsDoNotOverlap(t *testing.T) {
+	tasks := &rangeTasks{}
+	first := orchestrator.NewRangeAllocator(tasks)
+	second := orchestrator.NewRangeAllocator(tasks)
+
+	for _, a := range []orchestrator.TaskIDAllocator{first, second} {
+		err := a.Reserve()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[uint64]bool)
+
+	for range 100 {
+		for _, id := range []uint64{first.NextID(), second.NextID()} {
+			if seen[id] {
+				t.Fatalf("id %d was handed out twice", id)
+			}
+
+			seen[id] = true
+		}
+	}
+
+	if id := first.NextID(); id != 1<<repo.TaskIDRangeBits+101 {
+		t.Errorf("got id %d, want the next one of the first range", id)
+	}
+}
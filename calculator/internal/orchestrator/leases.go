@@ -0,0 +1,143 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// leaseWheel следит за сроками аренды задач агентами.
+// Вместо горутины на каждую задачу используется одно колесо таймеров:
+// аренда попадает в ячейку, соответствующую ее сроку, а колесо
+// раз в такт проверяет очередную ячейку. Аренды длиннее оборота колеса
+// остаются в ячейке до нужного оборота.
+type leaseWheel struct {
+	tick    time.Duration
+	slots   []map[uint64]struct{}
+	leases  map[uint64]lease
+	cursor  int
+	current time.Time // Момент, соответствующий текущей ячейке
+	mu      sync.Mutex
+}
+
+type lease struct {
+	deadline time.Time
+	slot     int
+}
+
+func newLeaseWheel(
+	tick time.Duration,
+	size int,
+	start time.Time,
+) *leaseWheel {
+	slots := make([]map[uint64]struct{}, size)
+	for i := range slots {
+		slots[i] = make(map[uint64]struct{})
+	}
+
+	return &leaseWheel{
+		tick:    tick,
+		slots:   slots,
+		leases:  make(map[uint64]lease),
+		current: start,
+	}
+}
+
+// Grant выдает аренду задачи до deadline, заменяя прежнюю.
+func (w *leaseWheel) Grant(id uint64, deadline time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.put(id, deadline)
+}
+
+// Renew продлевает активную аренду. Истекшую аренду продлить нельзя.
+func (w *leaseWheel) Renew(id uint64, deadline time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.leases[id]; !ok {
+		return false
+	}
+
+	w.put(id, deadline)
+
+	return true
+}
+
+// Release снимает аренду и сообщает, была ли она еще активна.
+func (w *leaseWheel) Release(id uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	l, ok := w.leases[id]
+	if !ok {
+		return false
+	}
+
+	delete(w.slots[l.slot], id)
+	delete(w.leases, id)
+
+	return true
+}
+
+func (w *leaseWheel) put(id uint64, deadline time.Time) {
+	if l, ok := w.leases[id]; ok {
+		delete(w.slots[l.slot], id)
+	}
+
+	ticks := int((deadline.Sub(w.current) + w.tick - 1) / w.tick)
+	ticks = max(ticks, 1)
+	slot := (w.cursor + ticks) % len(w.slots)
+
+	w.slots[slot][id] = struct{}{}
+	w.leases[id] = lease{deadline: deadline, slot: slot}
+}
+
+// Advance поворачивает колесо на один такт и возвращает
+// задачи, аренда которых истекла к моменту now.
+func (w *leaseWheel) Advance(now time.Time) []uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cursor = (w.cursor + 1) % len(w.slots)
+	w.current = now
+
+	var expired []uint64
+
+	for id := range w.slots[w.cursor] {
+		if w.leases[id].deadline.After(now) {
+			continue // Аренда на один из следующих оборотов
+		}
+
+		delete(w.slots[w.cursor], id)
+		delete(w.leases, id)
+
+		expired = append(expired, id)
+	}
+
+	return expired
+}
+
+// Run вращает колесо, пока не отменен контекст,
+// и передает истекшие аренды в onExpire. Тикер только задает такт:
+// сроки аренд сверяются с часами now, по которым они и выданы.
+func (w *leaseWheel) Run(
+	ctx context.Context,
+	now func() time.Time,
+	onExpire func(ids []uint64),
+) {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if expired := w.Advance(now()); len(expired) > 0 {
+				onExpire(expired)
+			}
+		}
+	}
+}
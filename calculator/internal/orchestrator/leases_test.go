@@ -0,0 +1,112 @@
+package orchestrator_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+)
+
+func TestLeaseWheelExpiry(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := orchestrator.NewLeaseWheel(time.Second, 4, start)
+
+	w.Grant(1, start.Add(2*time.Second))
+	// Аренда длиннее оборота колеса
+	w.Grant(2, start.Add(6*time.Second))
+
+	var expired []uint64
+
+	for i := 1; i <= 6; i++ {
+		ids := w.Advance(start.Add(time.Duration(i) * time.Second))
+
+		for _, id := range ids {
+			expired = append(expired, id)
+
+			if id == 1 && i != 2 || id == 2 && i != 6 {
+				t.Errorf("lease %d expired at tick %d", id, i)
+			}
+		}
+	}
+
+	if !slices.Equal(expired, []uint64{1, 2}) {
+		t.Errorf("got expired leases %v, want [1 2]", expired)
+	}
+}
+
+func TestLeaseWheelRenew(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := orchestrator.NewLeaseWheel(time.Second, 8, start)
+
+	w.Grant(1, start.Add(2*time.Second))
+
+	if ids := w.Advance(start.Add(time.Second)); len(ids) != 0 {
+		t.Fatalf("got expired leases %v", ids)
+	}
+
+	if !w.Renew(1, start.Add(4*time.Second)) {
+		t.Fatal("failed to renew an active lease")
+	}
+
+	for i := 2; i <= 3; i++ {
+		ids := w.Advance(start.Add(time.Duration(i) * time.Second))
+		if len(ids) != 0 {
+			t.Fatalf("renewed lease expired at tick %d", i)
+		}
+	}
+
+	ids := w.Advance(start.Add(4 * time.Second))
+	if !slices.Equal(ids, []uint64{1}) {
+		t.Fatalf("got expired leases %v, want [1]", ids)
+	}
+
+	if w.Renew(1, start.Add(10*time.Second)) {
+		t.Error("renewed an expired lease")
+	}
+
+	if w.Release(1) {
+		t.Error("released an expired lease")
+	}
+}
+
+func TestLeaseWheelRelease(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := orchestrator.NewLeaseWheel(time.Second, 8, start)
+
+	w.Grant(1, start.Add(time.Second))
+
+	if !w.Release(1) {
+		t.Fatal("failed to release an active lease")
+	}
+
+	if ids := w.Advance(start.Add(time.Second)); len(ids) != 0 {
+		t.Errorf("released lease expired: %v", ids)
+	}
+}
+
+func TestLeaseWheelRunUsesClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := orchestrator.NewLeaseWheel(time.Millisecond, 4, start)
+
+	// По настенным часам до срока еще далеко, по часам оркестратора
+	// аренда уже истекла
+	w.Grant(1, start.Add(time.Hour))
+
+	expired := make(chan []uint64, 1)
+
+	go w.Run(t.Context(), func() time.Time {
+		return start.Add(2 * time.Hour)
+	}, func(ids []uint64) {
+		expired <- ids
+	})
+
+	select {
+	case ids := <-expired:
+		if !slices.Equal(ids, []uint64{1}) {
+			t.Errorf("got expired leases %v, want [1]", ids)
+		}
+	case <-time.After(time.Second):
+		t.Error("the lease did not expire by the clock of the wheel")
+	}
+}
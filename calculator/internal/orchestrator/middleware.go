@@ -7,8 +7,6 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
-
-	"github.com/dzherb/go_calculator/calculator/pkg/security"
 )
 
 func CommonMiddleware(next http.Handler) http.Handler {
@@ -81,27 +79,76 @@ type ctxKey string
 const UserIDKey ctxKey = "userID"
 const TokenPrefix = "Bearer "
 
-func AuthRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, TokenPrefix) {
-			w.WriteHeader(http.StatusUnauthorized)
-			WriteError(w, fmt.Errorf("token must be provided"))
+// AuthRequired lets through only requests with an access token
+// that validate accepts, putting the id of the user into the context.
+func AuthRequired(
+	validate func(token string) (uint64, error),
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" || !strings.HasPrefix(authHeader, TokenPrefix) {
+				w.WriteHeader(http.StatusUnauthorized)
+				WriteError(w, fmt.Errorf("token must be provided"))
 
-			return
-		}
+				return
+			}
 
-		token := strings.TrimPrefix(authHeader, TokenPrefix)
+			token := strings.TrimPrefix(authHeader, TokenPrefix)
 
-		userID, err := security.ValidateToken(token)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			WriteError(w, err)
+			userID, err := validate(token)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				WriteError(w, err)
 
-			return
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TokenFromQuery lets clients that cannot set headers, e.g. EventSource
+// and WebSocket in browsers, pass the access token in the access_token
+// query parameter. It must run before AuthRequired.
+func TokenFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", TokenPrefix+token)
 		}
 
-		r = r.WithContext(context.WithValue(r.Context(), UserIDKey, userID))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AdminOnly lets through only users for whom isAdmin returns true.
+// It expects AuthRequired to run first.
+func AdminOnly(
+	isAdmin func(userID uint64) (bool, error),
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value(UserIDKey).(uint64)
+
+			ok, err := isAdmin(userID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				WriteError(w, err)
+
+				return
+			}
+
+			if !ok {
+				w.WriteHeader(http.StatusForbidden)
+				WriteError(w, fmt.Errorf("admin rights required"))
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -17,21 +17,19 @@ var handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(strconv.FormatUint(userID, 10))) //nolint:errcheck
 })
 
-func initSecurity() {
-	security.Init(security.Config{
-		SecretKey:      "secret",
-		AccessTokenTTL: time.Hour,
-	})
-}
+var tokens = security.NewTokenManager(security.Config{
+	SecretKey:      "secret",
+	AccessTokenTTL: time.Hour,
+})
 
-func TestAuthMiddlewareSuccess(t *testing.T) {
-	initSecurity()
+var authRequired = orchestrator.AuthRequired(tokens.ValidateToken)
 
+func TestAuthMiddlewareSuccess(t *testing.T) {
 	userID := uint64(25)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	token, err := security.IssueAccessToken(userID)
+	token, err := tokens.IssueAccessToken(userID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,7 +38,7 @@ func TestAuthMiddlewareSuccess(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	orchestrator.AuthRequired(handler).ServeHTTP(rr, req)
+	authRequired(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("got status %v, want %v", status, http.StatusOK)
@@ -56,16 +54,70 @@ func TestAuthMiddlewareSuccess(t *testing.T) {
 }
 
 func TestAuthMiddlewareFail(t *testing.T) {
-	initSecurity()
-
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set("Authorization", "Bearer fake_token")
 
 	rr := httptest.NewRecorder()
 
-	orchestrator.AuthRequired(handler).ServeHTTP(rr, req)
+	authRequired(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusUnauthorized {
 		t.Errorf("got status %v, want %v", status, http.StatusOK)
 	}
 }
+
+func TestAdminOnly(t *testing.T) {
+	isAdmin := func(userID uint64) (bool, error) {
+		return userID == 1, nil
+	}
+
+	cases := []struct {
+		userID uint64
+		want   int
+	}{
+		{1, http.StatusOK},
+		{2, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		token, err := tokens.IssueAccessToken(c.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rr := httptest.NewRecorder()
+
+		authRequired(orchestrator.AdminOnly(isAdmin)(handler)).
+			ServeHTTP(rr, req)
+
+		if rr.Code != c.want {
+			t.Errorf(
+				"user %d: got status %v, want %v",
+				c.userID,
+				rr.Code,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestTokenFromQuery(t *testing.T) {
+	token, err := tokens.IssueAccessToken(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?access_token="+token, nil)
+	rr := httptest.NewRecorder()
+
+	orchestrator.TokenFromQuery(authRequired(handler)).
+		ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "25" {
+		t.Errorf("got status %v and body %q, want the user to pass",
+			rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+// listenExpressionChanges передает в хаб изменения выражений,
+// сделанные другими оркестраторами с той же базой, чтобы их
+// дождались и ожидающие результата, и потоки событий.
+func (o *Orchestrator) listenExpressionChanges(ctx context.Context) {
+	o.repos.Notifier.Listen(
+		ctx,
+		repo.ExpressionChannel,
+		o.catchUpWaiters,
+		o.onExpressionChange,
+	)
+}
+
+func (o *Orchestrator) onExpressionChange(payload string) {
+	change := repo.ExpressionChange{}
+
+	err := json.Unmarshal([]byte(payload), &change)
+	if err != nil {
+		slog.Warn("Received a malformed expression change", "error", err)
+		return
+	}
+
+	// Свои изменения хаб уже получил напрямую
+	if change.Origin == o.instanceID {
+		return
+	}
+
+	// В уведомлении нет владельца выражения, без него событие
+	// не дойдет до потоков пользователя
+	expr, err := o.repos.Expressions.Get(change.ID)
+	if err != nil {
+		slog.Error("Failed to get changed expression",
+			slog.Uint64("expression_id", change.ID),
+			slog.String("error", err.Error()),
+		)
+
+		return
+	}
+
+	o.hub.Publish(expressionEvent(expr))
+
+	// Выражение, которое вычисляем мы, отменили через другой оркестратор
+	if change.Status == repo.ExpressionCanceled {
+		if _, ok := o.exprMemStorage.Get(change.ID); ok {
+			o.stopExpression(change.ID)
+		}
+	}
+}
+
+// catchUpWaiters сообщает ожидающим о выражениях, которые завершились,
+// пока уведомления не доходили, например во время переподключения.
+func (o *Orchestrator) catchUpWaiters() {
+	for _, id := range o.hub.Awaited() {
+		expr, err := o.repos.Expressions.Get(id)
+		if err != nil {
+			slog.Error("Failed to get awaited expression",
+				slog.Uint64("expression_id", id),
+				slog.String("error", err.Error()),
+			)
+
+			continue
+		}
+
+		if expr.Status.IsFinished() {
+			o.hub.Publish(expressionEvent(expr))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package orchestrator_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+func expressionChange(t *testing.T, origin string, id uint64) string {
+	payload, err := json.Marshal(repo.ExpressionChange{
+		Origin: origin,
+		ID:     id,
+		Status: repo.ExpressionSucceed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(payload)
+}
+
+func TestOnExpressionChange(t *testing.T) {
+	h := orchestrator.NewExpressionHub()
+	exprs := &fakeExpressions{exprs: map[uint64]repo.Expression{
+		1: {ID: 1, UserID: 1, Status: repo.ExpressionSucceed},
+	}}
+
+	events, unsubscribe := h.Subscribe(1)
+	defer unsubscribe()
+
+	// Владельца выражения в уведомлении нет, он берется из базы
+	orchestrator.OnExpressionChange(
+		h,
+		exprs,
+		"self",
+		expressionChange(t, "other", 1),
+	)
+
+	select {
+	case event := <-events:
+		if event.Status != repo.ExpressionSucceed || event.UserID != 1 {
+			t.Errorf("got event %+v, want a finished expression", event)
+		}
+	default:
+		t.Fatal("change of another instance was not published")
+	}
+
+	// Свои изменения хаб получает напрямую, повторять их не нужно
+	orchestrator.OnExpressionChange(
+		h,
+		exprs,
+		"self",
+		expressionChange(t, "self", 1),
+	)
+	orchestrator.OnExpressionChange(h, exprs, "self", "not json")
+
+	select {
+	case event := <-events:
+		t.Errorf("got unexpected event %+v", event)
+	default:
+	}
+}
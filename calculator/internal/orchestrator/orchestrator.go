@@ -1,143 +1,491 @@
 package orchestrator
 
 import (
-	"fmt"
+	"errors"
 	"log/slog"
 	"time"
 
+	"github.com/dzherb/go_calculator/calculator/internal/auth"
 	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
 	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
 	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+	"github.com/dzherb/go_calculator/calculator/pkg/security"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Orchestrator struct {
-	app            *App
+	config         *Config
+	instanceID     string
+	now            func() time.Time
+	taskIDs        TaskIDAllocator
+	repos          Repositories
+	tokens         *security.TokenManager
+	auth           auth.Service
 	exprMemStorage Storage[*calc.Expression]
 	taskMemStorage Storage[*calc.Task]
+	registry       *agentRegistry
+	queue          *taskQueue
+	leases         *leaseWheel
+	attempts       *attemptCounter
+	verifier       *verifier
+	cancels        *cancelNotifier
+	hub            *expressionHub
+	webhooks       *webhookDispatcher
 }
 
-var orchestrator = Orchestrator{
-	exprMemStorage: ExpressionStorageInstance,
-	taskMemStorage: TaskStorageInstance,
+// Repositories — хранилища в базе, с которыми работает оркестратор.
+type Repositories struct {
+	Expressions       repo.ExpressionRepository
+	Steps             repo.StepRepository
+	States            repo.StateRepository
+	Tasks             repo.TaskRepository
+	Users             repo.UserRepository
+	Mismatches        repo.MismatchRepository
+	IdempotencyKeys   repo.IdempotencyRepository
+	Webhooks          repo.WebhookRepository
+	WebhookDeliveries repo.WebhookDeliveryRepository
+	Notifier          repo.Notifier
 }
 
-var expressionRepo repo.ExpressionRepository
+// NewRepositories returns the repositories backed by the database pool
+// for the orchestrator with the instance id.
+func NewRepositories(db *pgxpool.Pool, instanceID string) Repositories {
+	return Repositories{
+		Expressions:       repo.NewExpressionRepository(db, instanceID),
+		Steps:             repo.NewStepRepository(db),
+		States:            repo.NewStateRepository(db),
+		Tasks:             repo.NewTaskRepository(db),
+		Users:             repo.NewUserRepository(db),
+		Mismatches:        repo.NewMismatchRepository(db),
+		IdempotencyKeys:   repo.NewIdempotencyRepository(db),
+		Webhooks:          repo.NewWebhookRepository(db),
+		WebhookDeliveries: repo.NewWebhookDeliveryRepository(db),
+		Notifier:          repo.NewNotifier(db),
+	}
+}
+
+// Dependencies is what the orchestrator gets from outside. Repositories
+// are required, the rest defaults to a random instance id, in-memory
+// storages, time.Now, task ids reserved in the task repository, a token
+// manager built from the config and the auth service on top of it.
+// The instance id tells the orchestrator apart from
+// the others sharing the database and must match the one the expression
+// repository signs its notifications with.
+type Dependencies struct {
+	InstanceID        string
+	Repos             Repositories
+	ExpressionStorage Storage[*calc.Expression]
+	TaskStorage       Storage[*calc.Task]
+	Clock             func() time.Time
+	TaskIDs           TaskIDAllocator
+	Tokens            *security.TokenManager
+	Auth              auth.Service
+}
+
+const (
+	leaseWheelTick = 100 * time.Millisecond
+	leaseWheelSize = 512
+)
+
+// New собирает оркестратор. Оркестраторы не делят между собой
+// никакого состояния, кроме базы, поэтому в одном процессе их может
+// быть несколько.
+func New(cfg *Config, deps Dependencies) *Orchestrator {
+	if deps.InstanceID == "" {
+		deps.InstanceID = storage.NewInstanceID()
+	}
+
+	if deps.Clock == nil {
+		deps.Clock = time.Now
+	}
+
+	if deps.TaskIDs == nil {
+		deps.TaskIDs = newRangeAllocator(deps.Repos.Tasks)
+	}
+
+	if deps.ExpressionStorage == nil {
+		deps.ExpressionStorage = NewExpressionStorage()
+	}
+
+	if deps.TaskStorage == nil {
+		deps.TaskStorage = NewTaskStorage()
+	}
+
+	if deps.Tokens == nil {
+		deps.Tokens = security.NewTokenManager(security.Config{
+			SecretKey:      cfg.SecretKey,
+			AccessTokenTTL: cfg.AccessTokenTTL,
+			Now:            deps.Clock,
+		})
+	}
 
-func ExpressionRepo() repo.ExpressionRepository {
-	if expressionRepo == nil {
-		expressionRepo = repo.NewExpressionRepository()
+	if deps.Auth == nil {
+		deps.Auth = auth.NewService(deps.Repos.Users, deps.Tokens)
 	}
 
-	return expressionRepo
+	return &Orchestrator{
+		config:         cfg,
+		instanceID:     deps.InstanceID,
+		now:            deps.Clock,
+		taskIDs:        deps.TaskIDs,
+		repos:          deps.Repos,
+		tokens:         deps.Tokens,
+		auth:           deps.Auth,
+		exprMemStorage: deps.ExpressionStorage,
+		taskMemStorage: deps.TaskStorage,
+		registry:       newAgentRegistry(deps.Clock),
+		queue:          newTaskQueue(),
+		leases: newLeaseWheel(
+			leaseWheelTick,
+			leaseWheelSize,
+			deps.Clock(),
+		),
+		attempts: newAttemptCounter(),
+		verifier: newVerifier(cfg.VerifyReplicas, cfg.VerifyQuorum),
+		cancels:  newCancelNotifier(),
+		hub:      newExpressionHub(deps.Clock),
+		webhooks: newWebhookDispatcher(
+			deps.Repos.WebhookDeliveries,
+			cfg.WebhookTimeout,
+			cfg.WebhookMaxAttempts,
+			cfg.WebhookRetryBackoff,
+			cfg.WebhookRetryMaxBackoff,
+			deps.Clock,
+		),
+	}
 }
 
 func (o *Orchestrator) CreateExpression(
 	expression string,
 	userID uint64,
 ) (uint64, error) {
-	expr, err := calc.NewExpression(expression)
+	expr, err := calc.NewExpression(expression, o.taskIDs)
 	if err != nil {
 		return 0, err
 	}
 
-	er := ExpressionRepo()
-
-	exprFromDB, err := er.Create(repo.Expression{
+	exprFromDB, err := o.repos.Expressions.Create(o.own(repo.Expression{
 		UserID:     userID,
 		Expression: expression,
-	})
+	}))
 	if err != nil {
 		return 0, err
 	}
 
+	o.startEvaluation(expr, exprFromDB)
+
+	return exprFromDB.ID, nil
+}
+
+// startEvaluation начинает вычислять выражение, уже сохраненное в базе.
+func (o *Orchestrator) startEvaluation(
+	expr *calc.Expression,
+	exprFromDB repo.Expression,
+) {
+	o.hub.Publish(expressionEvent(exprFromDB))
+
 	// Handle the case when an expression is trivial,
 	// e.g. one number and no operators.
 	if expr.IsEvaluated() {
 		go func() {
 			exprFromDB.Status = repo.ExpressionSucceed
 
-			_, err := er.Update(exprFromDB)
+			_, err := o.updateExpression(exprFromDB)
 			if err != nil {
 				slog.Error("failed to update expression",
-					"expression", expression,
+					"expression", exprFromDB.Expression,
 					"error", err,
 				)
 			}
 		}()
 
-		return exprFromDB.ID, nil
+		return
 	}
 
 	expr.Id = exprFromDB.ID
+	expr.AnnotateCriticalPath(o.getOperationTime)
 
 	o.exprMemStorage.Put(expr)
+	o.hub.Track(
+		exprFromDB.UserID,
+		expr.Id,
+		0,
+		expr.Stats(o.getOperationTime).Nodes,
+	)
+
+	err := o.saveState(expr)
+	if err != nil {
+		slog.Error("failed to save expression state",
+			"expression_id", expr.Id,
+			"error", err,
+		)
+	}
 
-	return expr.Id, nil
+	err = o.enqueueReady(expr)
+	if err != nil {
+		slog.Error("failed to enqueue expression tasks",
+			"expression_id", expr.Id,
+			"error", err,
+		)
+	}
 }
 
 func (o *Orchestrator) GetExpression(id uint64) (repo.Expression, error) {
-	return ExpressionRepo().Get(id)
+	expr, err := o.repos.Expressions.Get(id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Expression{}, errExpressionNotFound
+	}
+
+	return expr, err
 }
 
-func (o *Orchestrator) GetUserExpressions(
-	userID uint64,
-) ([]repo.Expression, error) {
-	return ExpressionRepo().GetForUser(userID)
+func (o *Orchestrator) GetExpressionSteps(id uint64) ([]repo.Step, error) {
+	return o.repos.Steps.GetForExpression(id)
+}
+
+func (o *Orchestrator) ListUserExpressions(
+	query repo.ExpressionListQuery,
+) (repo.ExpressionPage, error) {
+	return o.repos.Expressions.ListForUser(query)
 }
 
-func (o *Orchestrator) StartProcessingNextTask() (*pb.TaskToProcess, error) {
-	for expr := range o.exprMemStorage.All() {
-		task, ok := expr.GetNextTask()
+// StartProcessingNextTask выдает агенту следующую задачу с оператором,
+// который он поддерживает. Пустой agentID принимает любой оператор.
+func (o *Orchestrator) StartProcessingNextTask(
+	agentID string,
+) (*pb.TaskToProcess, error) {
+	var accept func(operator string) bool
+	if agentID != "" {
+		accept = o.registry.operatorFilter(agentID)
+	}
+
+	if o.verifier.Enabled() {
+		task, ok := o.nextReplica(agentID, accept)
+		if ok {
+			return task, nil
+		}
+	}
+
+	for {
+		task, ok := o.queue.PopFor(accept)
 		if !ok {
+			return nil, errNoTasksToProcess
+		}
+
+		if o.isAbandoned(task) {
 			continue
 		}
 
-		_, err := ExpressionRepo().Update(repo.Expression{
+		expr := task.GetExpression()
+
+		// Выражение могло упасть, пока задача ждала в очереди
+		if expr.HasFailed() {
+			_ = o.cancelTask(task)
+			continue
+		}
+
+		_, err := o.updateExpression(repo.Expression{
 			ID:     expr.Id,
 			Status: repo.ExpressionProcessing,
 		})
+		if errors.Is(err, errOwnershipLost) {
+			continue
+		}
+
+		if errors.Is(err, errExpressionFinished) {
+			_ = o.cancelTask(task)
+			continue
+		}
+
+		if err == nil {
+			err = o.setTaskStatus(task, repo.TaskProcessing)
+		}
+
 		if err != nil {
+			o.queue.Push(task)
 			return nil, err
 		}
 
 		o.taskMemStorage.Put(task)
+		o.registry.Assign(agentID, task.Id)
+		deadline := o.grantLease(task)
 
-		go func() {
-			time.Sleep(o.app.config.TaskMaxProcessTime)
+		if o.verifier.Enabled() {
+			o.verifier.Track(task, agentID)
+			// Задаче нужны еще агенты
+			o.queue.Wake()
+		}
 
-			err := task.Cancel()
-			if err != nil {
-				return
-			}
+		return o.newTaskToProcess(task, deadline)
+	}
+}
 
-			slog.Warn(
-				fmt.Sprintf(
-					"Task %d is canceled due to exceeded time to live",
-					task.Id,
-				),
-			)
-		}()
+// nextReplica выдает агенту задачу, которую уже вычисляют другие агенты,
+// но для проверки нужен еще один.
+func (o *Orchestrator) nextReplica(
+	agentID string,
+	accept func(operator string) bool,
+) (*pb.TaskToProcess, bool) {
+	for {
+		task, ok := o.verifier.NextReplica(agentID, accept)
+		if !ok {
+			return nil, false
+		}
+
+		if o.isAbandoned(task) {
+			continue
+		}
+
+		if task.GetExpression().HasFailed() {
+			_ = o.cancelTask(task)
+			continue
+		}
+
+		o.registry.Assign(agentID, task.Id)
+		// Аренда общая на всех агентов, продлеваем ее для нового
+		deadline := o.grantLease(task)
+
+		res, err := o.newTaskToProcess(task, deadline)
+		if err != nil {
+			return nil, false
+		}
+
+		return res, true
+	}
+}
+
+func (o *Orchestrator) grantLease(task *calc.Task) time.Time {
+	deadline := o.now().Add(o.config.TaskMaxProcessTime)
+	o.leases.Grant(task.Id, deadline)
+
+	return deadline
+}
+
+// RenewLease продлевает аренду задачи, которую агент еще вычисляет.
+func (o *Orchestrator) RenewLease(taskId uint64) (time.Time, error) {
+	deadline := o.now().Add(o.config.TaskMaxProcessTime)
+
+	if _, ok := o.taskMemStorage.Get(taskId); !ok {
+		err := o.forwardToOwner(taskReport{
+			Kind:   taskReportRenewal,
+			TaskID: taskId,
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		// Срок назначит владелец, но он будет не раньше этого
+		return deadline, nil
+	}
 
-		return newTaskToProcess(task)
+	if !o.leases.Renew(taskId, deadline) {
+		return time.Time{}, errLeaseExpired
 	}
 
-	return nil, errNoTasksToProcess
+	return deadline, nil
 }
 
-func (o *Orchestrator) CompleteTask(taskId uint64, result float64) error {
+// reclaimExpired возвращает в очередь задачи с истекшей арендой:
+// агент, скорее всего, упал, не успев их вычислить.
+func (o *Orchestrator) reclaimExpired(ids []uint64) {
+	for _, id := range ids {
+		o.registry.Finish(id, false)
+		o.verifier.Forget(id)
+
+		task, ok := o.taskMemStorage.Get(id)
+		if !ok {
+			continue
+		}
+
+		err := o.retryTask(task, errLeaseExpired.Error())
+		if err != nil {
+			slog.Error("failed to retry task",
+				"task_id", id,
+				"error", err,
+			)
+		}
+	}
+}
+
+func (o *Orchestrator) CompleteTask(
+	agentID string,
+	taskId uint64,
+	result float64,
+) error {
 	task, ok := o.taskMemStorage.Get(taskId)
 	if !ok {
-		return errTaskNotFound
+		return o.forwardToOwner(taskReport{
+			Kind:    taskReportResult,
+			TaskID:  taskId,
+			AgentID: agentID,
+			Result:  result,
+		})
 	}
 
+	// Выражение отменили или оно упало на другой задаче
+	if task.GetExpression().HasFailed() {
+		o.revokeTask(task)
+		return errTaskCanceled
+	}
+
+	if o.verifier.Enabled() {
+		return o.voteOnTask(agentID, task, outcome{Value: result})
+	}
+
+	// Задачу могли выдать заново, пока агент ее вычислял:
+	// тогда аренда принадлежит уже другому агенту
+	if !o.registry.Holds(agentID, taskId) {
+		return errTaskReassigned
+	}
+
+	// Результат принимаем, только пока аренда задачи не истекла
+	if !o.leases.Release(taskId) {
+		return errLeaseExpired
+	}
+
+	o.registry.Finish(taskId, true)
+
+	err := o.completeTask(task, result, repo.StepAgent)
+	if err != nil {
+		return err
+	}
+
+	// Завершение задачи могло сделать готовыми новые узлы
+	return o.enqueueReady(task.GetExpression())
+}
+
+// completeTask записывает результат задачи в выражение. Ошибкой
+// заканчивается только само завершение: после него узлы выражения
+// уже могли стать готовыми, и вызывающий должен поставить их в очередь.
+func (o *Orchestrator) completeTask(
+	task *calc.Task,
+	result float64,
+	executor repo.StepExecutor,
+) error {
+	arg1, arg2 := task.GetArguments()
+
 	err := task.Complete(result)
 	if err != nil {
 		return err
 	}
 
+	o.attempts.Forget(task.Id)
+
 	expr := task.GetExpression()
+	o.recordCompletion(task, repo.Step{
+		ExpressionID: expr.Id,
+		Operator:     task.GetOperator(),
+		Arg1:         arg1,
+		Arg2:         arg2,
+		Result:       result,
+		Executor:     executor,
+	})
 
 	if !expr.IsEvaluated() {
+		o.publishProgress(expr)
 		return nil
 	}
 
@@ -146,32 +494,313 @@ func (o *Orchestrator) CompleteTask(taskId uint64, result float64) error {
 		return err
 	}
 
-	_, err = ExpressionRepo().Update(repo.Expression{
-		ID:     task.GetExpression().Id,
+	_, err = o.updateExpression(repo.Expression{
+		ID:     expr.Id,
 		Status: repo.ExpressionSucceed,
 		Result: &res,
 	})
+	if errors.Is(err, errExpressionFinished) {
+		// Выражение отменили, пока задача вычислялась
+		return errTaskCanceled
+	}
 
 	return err
 }
 
-func (o *Orchestrator) OnCalculationFailure(taskId uint64) error {
+// recordCompletion сохраняет шаг вычисления, состояние выражения
+// и статус задачи. Записи лишь отражают вычисление в памяти, поэтому
+// их ошибки не останавливают его: в худшем случае после перезапуска
+// узел вычислится заново.
+func (o *Orchestrator) recordCompletion(task *calc.Task, step repo.Step) {
+	_, err := o.repos.Steps.Create(step)
+	if err != nil {
+		slog.Error("failed to save evaluation step",
+			"task_id", task.Id,
+			"error", err,
+		)
+	}
+
+	// Сначала сохраняем состояние: если упадем до смены статуса задачи,
+	// после перезапуска она просто не найдет свой узел и будет отменена.
+	// Без состояния задача остается незавершенной и выдастся заново
+	err = o.saveState(task.GetExpression())
+	if err != nil {
+		slog.Error("failed to save expression state",
+			"expression_id", step.ExpressionID,
+			"error", err,
+		)
+
+		return
+	}
+
+	err = o.setTaskStatus(task, repo.TaskCompleted)
+	if err != nil {
+		slog.Error("failed to update task status",
+			"task_id", task.Id,
+			"error", err,
+		)
+	}
+}
+
+// OnCalculationFailure обрабатывает ошибку, которую вернул агент.
+// Математические ошибки повторять бессмысленно, поэтому выражение
+// сразу падает. Остальные задачи повторяются.
+func (o *Orchestrator) OnCalculationFailure(
+	agentID string,
+	taskId uint64,
+	cause string,
+	kind pb.ErrorKind,
+) error {
 	task, ok := o.taskMemStorage.Get(taskId)
 	if !ok {
-		return errTaskNotFound
+		return o.forwardToOwner(taskReport{
+			Kind:      taskReportFailure,
+			TaskID:    taskId,
+			AgentID:   agentID,
+			Error:     cause,
+			ErrorKind: kind,
+		})
+	}
+
+	if task.GetExpression().HasFailed() {
+		o.revokeTask(task)
+		return errTaskCanceled
+	}
+
+	// Математическая ошибка — тоже результат, агент тут не виноват
+	retry := kind == pb.ErrorKind_ERROR_KIND_INFRASTRUCTURE
+
+	if o.verifier.Enabled() && !retry {
+		return o.voteOnTask(agentID, task, outcome{Failed: true, Error: cause})
+	}
+
+	if !o.registry.Holds(agentID, taskId) {
+		return errTaskReassigned
 	}
 
-	task.GetExpression().MarkAsFailed()
+	if !o.leases.Release(taskId) {
+		return errLeaseExpired
+	}
+
+	if o.verifier.Enabled() {
+		// Остальные агенты вычисляют задачу зря: она начнется заново
+		o.registry.FinishFor(agentID, taskId, false)
+		o.registry.Release(taskId)
+	} else {
+		o.registry.Finish(taskId, !retry)
+	}
+
+	if retry {
+		return o.retryTask(task, cause)
+	}
+
+	return o.failTask(task, cause)
+}
 
-	_, err := ExpressionRepo().Update(repo.Expression{
-		ID:     task.GetExpression().Id,
-		Status: repo.ExpressionFailed,
+// cancelTask отменяет задачу и отмечает это в базе.
+func (o *Orchestrator) cancelTask(task *calc.Task) error {
+	err := task.Cancel()
+	if err != nil {
+		return err
+	}
+
+	o.attempts.Forget(task.Id)
+	o.verifier.Forget(task.Id)
+
+	return o.setTaskStatus(task, repo.TaskCanceled)
+}
+
+func (o *Orchestrator) setTaskStatus(
+	task *calc.Task,
+	status repo.TaskStatus,
+) error {
+	_, err := o.repos.Tasks.UpdateStatus(task.Id, status)
+
+	// Задачи, вычисленные на месте сразу после создания,
+	// в базу не попадают: их узлы восстановятся из состояния
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
+
+func (o *Orchestrator) saveState(expr *calc.Expression) error {
+	_, err := o.repos.States.Save(repo.EvaluationState{
+		ExpressionID: expr.Id,
+		State:        expr.Snapshot(),
 	})
+
+	return err
+}
+
+// enqueueReady ставит в очередь готовые узлы выражения.
+// Узлы, которые невыгодно отправлять агентам, вычисляются на месте.
+func (o *Orchestrator) enqueueReady(expr *calc.Expression) error {
+	err := o.foldLocally(expr)
 	if err != nil {
 		return err
 	}
 
-	return task.Cancel()
+	return o.dispatchReady(expr)
+}
+
+// dispatchReady сохраняет готовые задачи выражения и ставит их в очередь.
+func (o *Orchestrator) dispatchReady(expr *calc.Expression) error {
+	tasks := expr.ReadyTasks(nil)
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	records := make([]repo.Task, 0, len(tasks))
+
+	for _, task := range tasks {
+		arg1, arg2 := task.GetArguments()
+
+		records = append(records, repo.Task{
+			ID:           task.Id,
+			ExpressionID: expr.Id,
+			NodeID:       task.NodeID(),
+			Operator:     task.GetOperator(),
+			Arg1:         arg1,
+			Arg2:         arg2,
+			Status:       repo.TaskQueued,
+		})
+	}
+
+	// Задачи попадут в очередь, даже если сохранить их не удалось:
+	// без записи в базе теряется только возможность их возобновить
+	o.queue.Push(tasks...)
+
+	_, err := o.repos.Tasks.Create(records)
+
+	return err
+}
+
+// foldLocally вычисляет на месте готовые узлы выражения,
+// которые невыгодно отправлять агентам: слишком дешевые
+// или все равно некому.
+func (o *Orchestrator) foldLocally(expr *calc.Expression) error {
+	for {
+		task, ok := expr.GetNextTaskFor(o.shouldFoldLocally)
+		if !ok {
+			return nil
+		}
+
+		err := o.computeLocally(task)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// foldQueued вычисляет на месте задачи, оставшиеся в очереди,
+// если забирать их уже некому.
+func (o *Orchestrator) foldQueued() error {
+	for o.aliveAgents() == 0 {
+		task, ok := o.queue.Pop()
+		if !ok {
+			return nil
+		}
+
+		if o.isAbandoned(task) {
+			continue
+		}
+
+		expr := task.GetExpression()
+
+		if expr.HasFailed() {
+			_ = o.cancelTask(task)
+			continue
+		}
+
+		err := o.computeLocally(task)
+		if err != nil {
+			return err
+		}
+
+		err = o.enqueueReady(expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Orchestrator) computeLocally(task *calc.Task) error {
+	res, err := task.Compute()
+	if err != nil {
+		slog.Warn(
+			"Local calculation failed",
+			"expression_id", task.GetExpression().Id,
+			"error", err,
+		)
+
+		return o.failTask(task, err.Error())
+	}
+
+	return o.completeTask(task, res, repo.StepLocal)
+}
+
+// aliveAgents возвращает число агентов, которые выходили на связь
+// недавно и готовы брать задачи.
+func (o *Orchestrator) aliveAgents() int {
+	return o.registry.Alive(o.now().Add(-o.config.AgentIdleTimeout))
+}
+
+// expireSilentAgents забывает агентов, которые давно не выходили на связь,
+// и возвращает в очередь задачи, которые они не успели вычислить.
+func (o *Orchestrator) expireSilentAgents() {
+	deadline := o.now().Add(-o.config.AgentIdleTimeout)
+
+	for _, agent := range o.registry.Expire(deadline) {
+		slog.Warn("Agent stopped responding",
+			"agent_id", agent.ID,
+			"last_seen", agent.LastSeen,
+			"in_flight", len(agent.InFlight),
+		)
+
+		for _, id := range agent.InFlight {
+			// Место агента займет другой, остальные продолжают вычислять
+			if o.verifier.Drop(id, agent.ID) {
+				o.queue.Wake()
+				continue
+			}
+
+			// Результат мог прийти, пока агента забывали
+			if !o.leases.Release(id) {
+				continue
+			}
+
+			task, ok := o.taskMemStorage.Get(id)
+			if !ok {
+				continue
+			}
+
+			err := o.retryTask(task, errAgentSilent.Error())
+			if err != nil {
+				slog.Error("failed to retry task",
+					"task_id", id,
+					"error", err,
+				)
+			}
+		}
+	}
+}
+
+func (o *Orchestrator) shouldFoldLocally(operator string) bool {
+	if o.aliveAgents() == 0 {
+		return true
+	}
+
+	return !o.isWorthDispatching(operator)
+}
+
+func (o *Orchestrator) isWorthDispatching(operator string) bool {
+	cfg := o.config
+
+	return cfg.OperatorCosts.Cost(operator) >= cfg.LocalFoldThreshold
 }
 
 type ExpressionResponse struct {
@@ -180,8 +809,9 @@ type ExpressionResponse struct {
 	Result *float64              `json:"result"`
 }
 
-func newTaskToProcess(
+func (o *Orchestrator) newTaskToProcess(
 	task *calc.Task,
+	leaseDeadline time.Time,
 ) (*pb.TaskToProcess, error) { //nolint:unparam
 	arg1, arg2 := task.GetArguments()
 	operator := task.GetOperator()
@@ -192,21 +822,22 @@ func newTaskToProcess(
 		Arg2:      arg2,
 		Operation: operator,
 		OperationTime: uint32( //nolint:gosec
-			orchestrator.getOperationTime(operator),
+			o.getOperationTime(operator),
 		),
+		LeaseDeadline: timestamppb.New(leaseDeadline),
 	}, nil
 }
 
 func (o *Orchestrator) getOperationTime(operator string) time.Duration {
 	switch operator {
 	case "+":
-		return o.app.config.AdditionTime
+		return o.config.AdditionTime
 	case "-":
-		return o.app.config.DivisionTime
+		return o.config.SubtractionTime
 	case "*":
-		return o.app.config.MultiplicationTime
+		return o.config.MultiplicationTime
 	case "/":
-		return o.app.config.DivisionTime
+		return o.config.DivisionTime
 	}
 
 	return 0
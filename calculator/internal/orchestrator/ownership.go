@@ -0,0 +1,309 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	pb "github.com/dzherb/go_calculator/calculator/internal/gen"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+	"github.com/jackc/pgx/v5"
+)
+
+// claimBatchSize — сколько брошенных выражений забирается за раз.
+const claimBatchSize = 100
+
+// own отмечает новое выражение как вычисляемое этим оркестратором.
+func (o *Orchestrator) own(expr repo.Expression) repo.Expression {
+	owner := o.instanceID
+	leaseUntil := o.ownershipLease()
+
+	expr.Owner = &owner
+	expr.OwnerLeaseUntil = &leaseUntil
+
+	return expr
+}
+
+func (o *Orchestrator) ownershipLease() time.Time {
+	return o.now().Add(o.config.OwnershipLeaseTTL)
+}
+
+// maintainOwnership продлевает владение вычисляемыми выражениями
+// и забирает выражения упавших оркестраторов, пока не отменен контекст.
+func (o *Orchestrator) maintainOwnership(ctx context.Context) {
+	// Успеваем продлить владение несколько раз до его истечения
+	ticker := time.NewTicker(o.config.OwnershipLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		o.renewOwnership()
+
+		_, err := o.claimOrphaned()
+		if err != nil {
+			slog.Error("Failed to claim orphaned expressions", "error", err)
+		}
+	}
+}
+
+// renewOwnership продлевает владение выражениями. Выражения, которые
+// за это время забрал другой оркестратор, больше не вычисляются.
+func (o *Orchestrator) renewOwnership() {
+	ids, err := o.repos.Expressions.RenewOwnership(
+		o.instanceID,
+		o.ownershipLease(),
+	)
+	if err != nil {
+		slog.Error("Failed to renew expression ownership", "error", err)
+		return
+	}
+
+	renewed := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		renewed[id] = struct{}{}
+	}
+
+	var missing []uint64
+
+	for expr := range o.exprMemStorage.All() {
+		if expr.IsEvaluated() || expr.HasFailed() {
+			continue
+		}
+
+		if _, ok := renewed[expr.Id]; !ok {
+			missing = append(missing, expr.Id)
+		}
+	}
+
+	for _, id := range missing {
+		// Выражение могло появиться уже после продления
+		expr, err := o.repos.Expressions.Get(id)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+
+		if err == nil && expr.Owner != nil &&
+			*expr.Owner == o.instanceID && !expr.Status.IsFinished() {
+			continue
+		}
+
+		slog.Warn("Lost ownership of expression", "expression_id", id)
+		o.forgetExpression(id)
+	}
+}
+
+// forgetExpression перестает вычислять выражение, которое забрал
+// другой оркестратор. В базе ничего не меняется: задачи и состояние
+// выражения теперь принадлежат новому владельцу.
+func (o *Orchestrator) forgetExpression(id uint64) {
+	o.exprMemStorage.Delete(id)
+
+	var tasks []*calc.Task
+
+	for task := range o.taskMemStorage.All() {
+		if task.GetExpression().Id == id {
+			tasks = append(tasks, task)
+		}
+	}
+
+	// Агенты досчитывают задачи: их результаты уйдут новому владельцу
+	for _, task := range tasks {
+		o.leases.Release(task.Id)
+		o.registry.Release(task.Id)
+		o.verifier.Forget(task.Id)
+		o.attempts.Forget(task.Id)
+		o.taskMemStorage.Delete(task.Id)
+	}
+}
+
+// isAbandoned сообщает, что выражение задачи вычисляет уже
+// другой оркестратор. Такие задачи остаются в очереди, пока до них
+// не дойдет очередь, и молча выбрасываются.
+func (o *Orchestrator) isAbandoned(task *calc.Task) bool {
+	expr := task.GetExpression()
+	if expr.HasFailed() {
+		return false
+	}
+
+	_, owned := o.exprMemStorage.Get(expr.Id)
+
+	return !owned
+}
+
+// claimOrphaned забирает выражения, которые никто не вычисляет,
+// продолжает их вычисление и возвращает, сколько выражений забрано.
+func (o *Orchestrator) claimOrphaned() (int, error) {
+	expressions, err := o.repos.Expressions.Claim(
+		o.instanceID,
+		o.ownershipLease(),
+		claimBatchSize,
+	)
+	if err != nil || len(expressions) == 0 {
+		return 0, err
+	}
+
+	ids := make([]uint64, 0, len(expressions))
+
+	for _, expr := range expressions {
+		err = o.resumeExpression(expr)
+		if err != nil {
+			slog.Warn("Failed to resume expression, aborting it",
+				"expression_id", expr.ID,
+				"error", err,
+			)
+
+			o.abortExpression(expr.ID)
+
+			continue
+		}
+
+		ids = append(ids, expr.ID)
+	}
+
+	tasks, err := o.repos.Tasks.Outstanding(ids)
+	if err != nil {
+		return len(expressions), err
+	}
+
+	for _, task := range tasks {
+		o.resumeTask(task)
+	}
+
+	// Узлы без сохраненных задач отдаем заново. Вычислять их на месте
+	// не спешим: агенты еще не успели переподключиться
+	for _, id := range ids {
+		expr, ok := o.exprMemStorage.Get(id)
+		if !ok {
+			continue
+		}
+
+		err = o.dispatchReady(expr)
+		if err != nil {
+			slog.Error("failed to enqueue expression tasks",
+				"expression_id", expr.Id,
+				"error", err,
+			)
+		}
+	}
+
+	return len(expressions), nil
+}
+
+// releaseOwnership отдает вычисляемые выражения другим оркестраторам,
+// не дожидаясь истечения владения, например при остановке.
+func (o *Orchestrator) releaseOwnership() {
+	err := o.repos.Expressions.ReleaseOwnership(o.instanceID)
+	if err != nil {
+		slog.Error("Failed to release expression ownership", "error", err)
+	}
+}
+
+type taskReportKind string
+
+const (
+	taskReportResult  taskReportKind = "result"
+	taskReportFailure taskReportKind = "failure"
+	taskReportRenewal taskReportKind = "renewal"
+)
+
+// taskReport — сообщение агента о задаче, которую выдал
+// другой оркестратор.
+type taskReport struct {
+	Kind      taskReportKind `json:"kind"`
+	TaskID    uint64         `json:"task_id"`
+	AgentID   string         `json:"agent_id,omitempty"`
+	Result    float64        `json:"result,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	ErrorKind pb.ErrorKind   `json:"error_kind,omitempty"`
+}
+
+// ownerChannel — канал уведомлений, который слушает оркестратор.
+func ownerChannel(owner string) string {
+	return "orchestrator_" + owner
+}
+
+// forwardToOwner передает сообщение агента оркестратору, который
+// вычисляет выражение задачи: агент мог обратиться к любому из них.
+func (o *Orchestrator) forwardToOwner(report taskReport) error {
+	task, err := o.repos.Tasks.Get(report.TaskID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errTaskNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if task.Status != repo.TaskQueued && task.Status != repo.TaskProcessing {
+		return errTaskNotFound
+	}
+
+	expr, err := o.repos.Expressions.Get(task.ExpressionID)
+	if err != nil {
+		return err
+	}
+
+	// Владелец пропал или это мы сами: задачу выдадут заново
+	if expr.Owner == nil || *expr.Owner == o.instanceID ||
+		expr.OwnerLeaseUntil.Before(o.now()) {
+		return errTaskNotFound
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	return o.repos.Notifier.Notify(ownerChannel(*expr.Owner), string(payload))
+}
+
+// listenTaskReports принимает сообщения агентов,
+// пересланные другими оркестраторами.
+func (o *Orchestrator) listenTaskReports(ctx context.Context) {
+	o.repos.Notifier.Listen(
+		ctx,
+		ownerChannel(o.instanceID),
+		func() {},
+		o.onTaskReport,
+	)
+}
+
+func (o *Orchestrator) onTaskReport(payload string) {
+	report := taskReport{}
+
+	err := json.Unmarshal([]byte(payload), &report)
+	if err != nil {
+		slog.Warn("Received a malformed task report", "error", err)
+		return
+	}
+
+	switch report.Kind {
+	case taskReportResult:
+		err = o.CompleteTask(report.AgentID, report.TaskID, report.Result)
+	case taskReportFailure:
+		err = o.OnCalculationFailure(
+			report.AgentID,
+			report.TaskID,
+			report.Error,
+			report.ErrorKind,
+		)
+	case taskReportRenewal:
+		_, err = o.RenewLease(report.TaskID)
+	}
+
+	if err != nil {
+		slog.Warn("Rejected a forwarded task report",
+			"task_id", report.TaskID,
+			"kind", report.Kind,
+			"error", err,
+		)
+	}
+}
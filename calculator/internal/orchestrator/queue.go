@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// taskQueue — очередь готовых к отправке задач.
+// Первой выдается задача с самым длинным оставшимся критическим путем,
+// при равенстве — поставленная в очередь раньше.
+// Для каждого оператора ведется своя куча, чтобы агент мог быстро
+// получить лучшую задачу среди тех, что он умеет вычислять.
+type taskQueue struct {
+	heaps   map[string]*taskHeap
+	counter uint64
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{
+		heaps:   make(map[string]*taskHeap),
+		changed: make(chan struct{}),
+	}
+}
+
+func (q *taskQueue) Push(tasks ...*calc.Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, task := range tasks {
+		h, ok := q.heaps[task.GetOperator()]
+		if !ok {
+			h = &taskHeap{}
+			q.heaps[task.GetOperator()] = h
+		}
+
+		q.counter++
+		heap.Push(h, queuedTask{task: task, seq: q.counter})
+	}
+
+	if len(tasks) > 0 {
+		q.wake()
+	}
+}
+
+// Wake notifies those waiting for tasks that appeared outside the queue,
+// e.g. tasks that need one more agent for verification.
+func (q *taskQueue) Wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.wake()
+}
+
+func (q *taskQueue) wake() {
+	// Будим всех, кто ждет новых задач
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed when tasks are pushed.
+// Get it before trying to Pop, so that no push goes unnoticed.
+func (q *taskQueue) Changed() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.changed
+}
+
+func (q *taskQueue) Pop() (*calc.Task, bool) {
+	return q.PopFor(nil)
+}
+
+// PopFor returns the first task whose operator is accepted.
+// A nil accept function accepts any operator.
+func (q *taskQueue) PopFor(
+	accept func(operator string) bool,
+) (*calc.Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var best *taskHeap
+
+	for operator, h := range q.heaps {
+		if h.Len() == 0 || accept != nil && !accept(operator) {
+			continue
+		}
+
+		if best == nil || (*h)[0].before((*best)[0]) {
+			best = h
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+
+	return heap.Pop(best).(queuedTask).task, true
+}
+
+func (q *taskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	total := 0
+
+	for _, h := range q.heaps {
+		total += h.Len()
+	}
+
+	return total
+}
+
+type queuedTask struct {
+	task *calc.Task
+	seq  uint64
+}
+
+func (t queuedTask) before(other queuedTask) bool {
+	if t.task.Priority() != other.task.Priority() {
+		return t.task.Priority() > other.task.Priority()
+	}
+
+	return t.seq < other.seq
+}
+
+type taskHeap []queuedTask
+
+func (h taskHeap) Len() int {
+	return len(h)
+}
+
+func (h taskHeap) Less(i, j int) bool {
+	return h[i].before(h[j])
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *taskHeap) Push(x any) {
+	*h = append(*h, x.(queuedTask))
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
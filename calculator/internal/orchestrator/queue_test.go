@@ -0,0 +1,116 @@
+package orchestrator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// testTaskIDs выдает id задачам всех выражений в тестах,
+// как оркестратор выдает их задачам своих выражений.
+var testTaskIDs = calc.NewIDSeries(0)
+
+func readyTasks(t *testing.T, expression string) []*calc.Task {
+	t.Helper()
+
+	expr, err := calc.NewExpression(expression, testTaskIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expr.AnnotateCriticalPath(func(string) time.Duration {
+		return time.Second
+	})
+
+	return expr.ReadyTasks(nil)
+}
+
+func TestTaskQueueOrder(t *testing.T) {
+	shallow := readyTasks(t, "1+2")
+	deep := readyTasks(t, "(3*4)*5*6")
+	tie := readyTasks(t, "7-8")
+
+	q := orchestrator.NewTaskQueue()
+	q.Push(shallow...)
+	q.Push(deep...)
+	q.Push(tie...)
+
+	if q.Len() != 3 {
+		t.Fatalf("got len %d, want 3", q.Len())
+	}
+
+	expected := []*calc.Task{deep[0], shallow[0], tie[0]}
+
+	for i, want := range expected {
+		got, ok := q.Pop()
+		if !ok {
+			t.Fatalf("queue is unexpectedly empty at %d", i)
+		}
+
+		if got != want {
+			t.Errorf(
+				"pop %d: got task with priority %v, want %v",
+				i,
+				got.Priority(),
+				want.Priority(),
+			)
+		}
+	}
+
+	if _, ok := q.Pop(); ok {
+		t.Error("expected the queue to be empty")
+	}
+}
+
+func TestTaskQueuePopFor(t *testing.T) {
+	sum := readyTasks(t, "1+2")
+	product := readyTasks(t, "(3*4)*5")
+
+	q := orchestrator.NewTaskQueue()
+	q.Push(sum...)
+	q.Push(product...)
+
+	onlySum := func(operator string) bool {
+		return operator == "+"
+	}
+
+	got, ok := q.PopFor(onlySum)
+	if !ok || got != sum[0] {
+		t.Fatalf("got task %v, want %v", got, sum[0])
+	}
+
+	if _, ok = q.PopFor(onlySum); ok {
+		t.Error("got a task with an operator that is not accepted")
+	}
+
+	if q.Len() != 1 {
+		t.Errorf("got len %d, want 1", q.Len())
+	}
+}
+
+func TestTaskQueueChanged(t *testing.T) {
+	q := orchestrator.NewTaskQueue()
+	changed := q.Changed()
+
+	select {
+	case <-changed:
+		t.Fatal("empty queue reported a change")
+	default:
+	}
+
+	q.Push(readyTasks(t, "1+2")...)
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("push was not reported")
+	}
+
+	select {
+	case <-q.Changed():
+		t.Fatal("change was reported twice")
+	default:
+	}
+}
@@ -0,0 +1,374 @@
+package orchestrator
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AgentInfo описывает агента, который представился оркестратору.
+type AgentInfo struct {
+	ID           string    `json:"id"`
+	Hostname     string    `json:"hostname"`
+	Version      string    `json:"version"`
+	Workers      int       `json:"workers"`
+	Operators    []string  `json:"operators"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Supports reports whether the agent can compute the operator.
+// An agent that listed no operators supports all of them.
+func (a AgentInfo) Supports(operator string) bool {
+	return len(a.Operators) == 0 || slices.Contains(a.Operators, operator)
+}
+
+// AgentStatus describes what an agent is doing right now.
+type AgentStatus struct {
+	AgentInfo
+	LastSeen  time.Time `json:"last_seen"`
+	InFlight  []uint64  `json:"in_flight"`
+	Succeeded uint64    `json:"succeeded"`
+	Failed    uint64    `json:"failed"`
+	Draining  bool      `json:"draining"`
+	// Агент вернул результат, с которым не согласился кворум
+	Quarantined bool `json:"quarantined"`
+}
+
+type agentState struct {
+	info        AgentInfo
+	lastSeen    time.Time
+	inFlight    map[uint64]struct{}
+	succeeded   uint64
+	failed      uint64
+	draining    bool // Новые задачи агенту не выдаются
+	quarantined bool // Результат агента не совпал с кворумом
+}
+
+func (s *agentState) status() AgentStatus {
+	inFlight := slices.Sorted(maps.Keys(s.inFlight))
+
+	return AgentStatus{
+		AgentInfo:   s.info,
+		LastSeen:    s.lastSeen,
+		InFlight:    inFlight,
+		Succeeded:   s.succeeded,
+		Failed:      s.failed,
+		Draining:    s.draining,
+		Quarantined: s.quarantined,
+	}
+}
+
+func (s *agentState) accepting() bool {
+	return !s.draining && !s.quarantined
+}
+
+// Агенты, которые не регистрировались, различаются по адресу.
+const anonymousAgentPrefix = "peer:"
+
+// agentRegistry отслеживает агентов: кто они, когда обращались
+// последний раз и какие задачи сейчас вычисляют.
+type agentRegistry struct {
+	agents map[string]*agentState
+	owners map[uint64]map[string]struct{} // Задача -> кто ее вычисляет
+	// Агенты в карантине, которых реестр уже забыл. Карантин
+	// вернется к ним, когда они зарегистрируются снова
+	quarantined map[string]struct{}
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+func newAgentRegistry(now func() time.Time) *agentRegistry {
+	return &agentRegistry{
+		agents:      make(map[string]*agentState),
+		owners:      make(map[uint64]map[string]struct{}),
+		quarantined: make(map[string]struct{}),
+		now:         now,
+	}
+}
+
+// Register adds the agent or updates its registration.
+// Counters and tasks of a known agent are kept. An agent that was
+// quarantined before it expired stays in quarantine.
+func (r *agentRegistry) Register(info AgentInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[info.ID]
+	if !ok {
+		state = &agentState{inFlight: make(map[uint64]struct{})}
+		_, state.quarantined = r.quarantined[info.ID]
+		delete(r.quarantined, info.ID)
+		r.agents[info.ID] = state
+	}
+
+	state.info = info
+	state.lastSeen = r.now()
+}
+
+// Touch marks the agent as alive. It returns false for unknown agents.
+func (r *agentRegistry) Touch(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[id]
+	if ok {
+		state.lastSeen = r.now()
+	}
+
+	return ok
+}
+
+// TouchAnonymous marks an agent that did not register as alive,
+// registering it on the first call.
+func (r *agentRegistry) TouchAnonymous(addr string) string {
+	id := anonymousAgentPrefix + addr
+
+	if !r.Touch(id) {
+		r.Register(AgentInfo{ID: id, RegisteredAt: r.now()})
+	}
+
+	return id
+}
+
+// TouchByTask marks the agents computing the task as alive.
+func (r *agentRegistry) TouchByTask(taskID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.owners[taskID] {
+		if state, ok := r.agents[id]; ok {
+			state.lastSeen = r.now()
+		}
+	}
+}
+
+// Assign records that the agent took the task.
+func (r *agentRegistry) Assign(id string, taskID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[id]
+	if !ok {
+		return
+	}
+
+	state.inFlight[taskID] = struct{}{}
+
+	if r.owners[taskID] == nil {
+		r.owners[taskID] = make(map[string]struct{})
+	}
+
+	r.owners[taskID][id] = struct{}{}
+}
+
+// Holds reports whether the agent may report the task. Tasks nobody
+// was recorded for, e.g. resumed after a restart, are held by anyone.
+func (r *agentRegistry) Holds(id string, taskID uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners, ok := r.owners[taskID]
+	if !ok {
+		return true
+	}
+
+	_, ok = owners[id]
+
+	return ok
+}
+
+// Finish records the outcome of the task for the agents computing it.
+func (r *agentRegistry) Finish(taskID uint64, succeeded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.owners[taskID] {
+		r.finish(id, taskID, succeeded)
+	}
+}
+
+// FinishFor records the outcome of the task for one of its agents.
+func (r *agentRegistry) FinishFor(id string, taskID uint64, succeeded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.owners[taskID][id]; ok {
+		r.finish(id, taskID, succeeded)
+	}
+}
+
+func (r *agentRegistry) finish(id string, taskID uint64, succeeded bool) {
+	r.disown(id, taskID)
+
+	state, ok := r.agents[id]
+	if !ok {
+		return
+	}
+
+	delete(state.inFlight, taskID)
+
+	if succeeded {
+		state.succeeded++
+	} else {
+		state.failed++
+	}
+}
+
+// Release forgets who is computing the task without counting
+// the outcome, e.g. when their results are no longer needed.
+// It returns the agents that were computing the task.
+func (r *agentRegistry) Release(taskID uint64) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners := make([]string, 0, len(r.owners[taskID]))
+
+	for id := range r.owners[taskID] {
+		if state, ok := r.agents[id]; ok {
+			delete(state.inFlight, taskID)
+		}
+
+		owners = append(owners, id)
+	}
+
+	delete(r.owners, taskID)
+
+	return owners
+}
+
+func (r *agentRegistry) disown(id string, taskID uint64) {
+	delete(r.owners[taskID], id)
+
+	if len(r.owners[taskID]) == 0 {
+		delete(r.owners, taskID)
+	}
+}
+
+// Drain stops handing out new tasks to the agent.
+// Tasks it already took are still accepted.
+func (r *agentRegistry) Drain(id string) (AgentStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[id]
+	if !ok {
+		return AgentStatus{}, false
+	}
+
+	state.draining = true
+
+	return state.status(), true
+}
+
+// Quarantine stops handing out tasks to an agent that returned
+// a wrong result.
+func (r *agentRegistry) Quarantine(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state, ok := r.agents[id]; ok {
+		state.quarantined = true
+	} else {
+		// Агент мог замолчать, пока решалась судьба его результата
+		r.quarantined[id] = struct{}{}
+	}
+}
+
+// Restore lets a drained or quarantined agent get tasks again.
+func (r *agentRegistry) Restore(id string) (AgentStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[id]
+	if !ok {
+		return AgentStatus{}, false
+	}
+
+	state.draining = false
+	state.quarantined = false
+
+	return state.status(), true
+}
+
+// Expire forgets agents that were last seen before the deadline.
+// The returned statuses list the tasks they did not finish.
+func (r *agentRegistry) Expire(deadline time.Time) []AgentStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired []AgentStatus
+
+	for id, state := range r.agents {
+		if !state.lastSeen.Before(deadline) {
+			continue
+		}
+
+		for taskID := range state.inFlight {
+			r.disown(id, taskID)
+		}
+
+		if state.quarantined {
+			r.quarantined[id] = struct{}{}
+		}
+
+		delete(r.agents, id)
+
+		expired = append(expired, state.status())
+	}
+
+	return expired
+}
+
+// Alive returns the number of agents seen after the deadline
+// that accept new tasks.
+func (r *agentRegistry) Alive(deadline time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alive := 0
+
+	for _, state := range r.agents {
+		if state.accepting() && !state.lastSeen.Before(deadline) {
+			alive++
+		}
+	}
+
+	return alive
+}
+
+// All returns the agents ordered by id.
+func (r *agentRegistry) All() []AgentStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agents := make([]AgentStatus, 0, len(r.agents))
+	for _, state := range r.agents {
+		agents = append(agents, state.status())
+	}
+
+	slices.SortFunc(agents, func(a, b AgentStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return agents
+}
+
+// Accepts reports whether the agent should get a task with the operator.
+func (r *agentRegistry) Accepts(id string, operator string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.agents[id]
+
+	return ok && state.accepting() && state.info.Supports(operator)
+}
+
+// operatorFilter возвращает функцию отбора задач для агента.
+// Она учитывает и последующие изменения, например вывод агента из работы.
+func (r *agentRegistry) operatorFilter(id string) func(operator string) bool {
+	return func(operator string) bool {
+		return r.Accepts(id, operator)
+	}
+}
@@ -0,0 +1,186 @@
+package orchestrator_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+)
+
+func TestAgentRegistryCounters(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "a"})
+
+	r.Assign("a", 1)
+	r.Assign("a", 2)
+	r.Assign("a", 3)
+	r.Finish(1, true)
+	r.Finish(2, false)
+
+	agents := r.All()
+	if len(agents) != 1 {
+		t.Fatalf("got %d agents, want 1", len(agents))
+	}
+
+	agent := agents[0]
+
+	if agent.Succeeded != 1 || agent.Failed != 1 {
+		t.Errorf(
+			"got %d succeeded and %d failed, want 1 and 1",
+			agent.Succeeded,
+			agent.Failed,
+		)
+	}
+
+	if !slices.Equal(agent.InFlight, []uint64{3}) {
+		t.Errorf("got in-flight tasks %v, want [3]", agent.InFlight)
+	}
+}
+
+func TestAgentRegistryExpire(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "silent"})
+	r.Assign("silent", 7)
+
+	if n := r.Alive(time.Now().Add(-time.Minute)); n != 1 {
+		t.Errorf("got %d alive agents, want 1", n)
+	}
+
+	if expired := r.Expire(time.Now().Add(-time.Minute)); len(expired) != 0 {
+		t.Errorf("expired an agent that was just seen: %v", expired)
+	}
+
+	expired := r.Expire(time.Now().Add(time.Minute))
+	if len(expired) != 1 || !slices.Equal(expired[0].InFlight, []uint64{7}) {
+		t.Fatalf("got expired agents %v, want silent with task 7", expired)
+	}
+
+	if r.Touch("silent") {
+		t.Error("expired agent is still registered")
+	}
+
+	// Поздний результат не должен ломать учет
+	r.Finish(7, true)
+}
+
+func TestAgentRegistryDrain(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "a", Operators: []string{"+"}})
+
+	if !r.Accepts("a", "+") || r.Accepts("a", "*") {
+		t.Fatal("agent must accept only the operators it supports")
+	}
+
+	if _, ok := r.Drain("unknown"); ok {
+		t.Error("drained an unknown agent")
+	}
+
+	agent, ok := r.Drain("a")
+	if !ok || !agent.Draining {
+		t.Fatal("failed to drain the agent")
+	}
+
+	if r.Accepts("a", "+") {
+		t.Error("draining agent still gets tasks")
+	}
+
+	if n := r.Alive(time.Now().Add(-time.Minute)); n != 0 {
+		t.Errorf("got %d alive agents, want 0", n)
+	}
+}
+
+func TestAgentRegistryAnonymous(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+
+	id := r.TouchAnonymous("10.0.0.1:5000")
+	if r.TouchAnonymous("10.0.0.1:5000") != id {
+		t.Error("same address got different ids")
+	}
+
+	if !r.Accepts(id, "/") {
+		t.Error("anonymous agent must accept every operator")
+	}
+}
+
+func TestAgentRegistryQuarantine(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "a"})
+	r.Register(orchestrator.AgentInfo{ID: "b"})
+
+	// Одну задачу вычисляют оба агента
+	r.Assign("a", 1)
+	r.Assign("b", 1)
+	r.FinishFor("a", 1, true)
+	r.FinishFor("b", 1, false)
+	r.Quarantine("b")
+
+	if r.Accepts("b", "+") {
+		t.Error("quarantined agent still gets tasks")
+	}
+
+	agents := r.All()
+	if agents[0].Succeeded != 1 || agents[1].Failed != 1 {
+		t.Errorf("got agents %+v", agents)
+	}
+
+	if !agents[1].Quarantined {
+		t.Error("agent is not marked as quarantined")
+	}
+
+	if _, ok := r.Restore("b"); !ok || !r.Accepts("b", "+") {
+		t.Error("failed to restore the agent")
+	}
+}
+
+func TestAgentRegistryQuarantineSurvivesExpiry(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "a"})
+	r.Quarantine("a")
+
+	if expired := r.Expire(time.Now().Add(time.Minute)); len(expired) != 1 {
+		t.Fatalf("got expired agents %v, want a", expired)
+	}
+
+	// Агент переждал таймаут и представился заново
+	r.Register(orchestrator.AgentInfo{ID: "a"})
+
+	q := orchestrator.NewTaskQueue()
+	q.Push(readyTasks(t, "1+2")...)
+
+	if task, ok := q.PopFor(r.OperatorFilter("a")); ok {
+		t.Errorf("quarantined agent got task %v after expiring", task)
+	}
+
+	if agents := r.All(); len(agents) != 1 || !agents[0].Quarantined {
+		t.Errorf("got agents %+v, want a quarantined one", agents)
+	}
+
+	if _, ok := r.Restore("a"); !ok {
+		t.Fatal("failed to restore the agent")
+	}
+
+	if _, ok := q.PopFor(r.OperatorFilter("a")); !ok {
+		t.Error("restored agent does not get tasks")
+	}
+}
+
+func TestAgentRegistryReleaseReturnsOwners(t *testing.T) {
+	r := orchestrator.NewAgentRegistry()
+	r.Register(orchestrator.AgentInfo{ID: "a"})
+	r.Register(orchestrator.AgentInfo{ID: "b"})
+
+	r.Assign("a", 1)
+	r.Assign("b", 1)
+
+	owners := r.Release(1)
+	slices.Sort(owners)
+
+	if !slices.Equal(owners, []string{"a", "b"}) {
+		t.Errorf("got owners %v, want [a b]", owners)
+	}
+
+	if owners = r.Release(1); len(owners) != 0 {
+		t.Errorf("got owners %v of a released task", owners)
+	}
+}
@@ -0,0 +1,144 @@
+package orchestrator
+
+import (
+	"log/slog"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// Resume picks up expressions that nobody evaluates, e.g. those
+// left by a previous run, and puts their outstanding tasks back to work.
+// Expressions that cannot be restored are aborted.
+func (o *Orchestrator) Resume() error {
+	// Новые задачи не должны совпасть с задачами прошлых запусков
+	// и других оркестраторов
+	err := o.taskIDs.Reserve()
+	if err != nil {
+		return err
+	}
+
+	resumed := 0
+
+	for {
+		claimed, err := o.claimOrphaned()
+		if err != nil {
+			return err
+		}
+
+		resumed += claimed
+
+		if claimed < claimBatchSize {
+			break
+		}
+	}
+
+	slog.Info("resumed unprocessed expressions", "count", resumed)
+
+	return nil
+}
+
+func (o *Orchestrator) resumeExpression(expression repo.Expression) error {
+	state, err := o.repos.States.Get(expression.ID)
+	if err != nil {
+		return err
+	}
+
+	expr, err := calc.RestoreExpression(
+		expression.ID,
+		state.State,
+		o.taskIDs,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Упали между сохранением состояния и результата
+	if expr.IsEvaluated() {
+		res, err := expr.GetResult()
+		if err != nil {
+			return err
+		}
+
+		_, err = o.updateExpression(repo.Expression{
+			ID:     expr.Id,
+			Status: repo.ExpressionSucceed,
+			Result: &res,
+		})
+
+		return err
+	}
+
+	expr.AnnotateCriticalPath(o.getOperationTime)
+	o.exprMemStorage.Put(expr)
+
+	// Каждый вычисленный шаг — одна операция
+	steps, err := o.repos.Steps.GetForExpression(expr.Id)
+	if err != nil {
+		return err
+	}
+
+	o.hub.Track(
+		expression.UserID,
+		expr.Id,
+		len(steps),
+		expr.Stats(o.getOperationTime).Nodes,
+	)
+
+	return nil
+}
+
+// resumeTask возвращает в работу задачу, сохраненную до перезапуска.
+// Задача сохраняет свой id, поэтому агент, который успел ее забрать,
+// сможет прислать результат.
+func (o *Orchestrator) resumeTask(record repo.Task) {
+	var (
+		task *calc.Task
+		ok   bool
+	)
+
+	expr, exprOK := o.exprMemStorage.Get(record.ExpressionID)
+	if exprOK {
+		task, ok = expr.ResumeTask(record.ID, record.NodeID)
+	}
+
+	if !ok {
+		// Выражение не восстановлено или узел уже вычислен
+		_, err := o.repos.Tasks.UpdateStatus(record.ID, repo.TaskCanceled)
+		if err != nil {
+			slog.Error("failed to update task status",
+				"task_id", record.ID,
+				"error", err,
+			)
+		}
+
+		return
+	}
+
+	o.attempts.Set(task.Id, record.Attempts)
+
+	// Кто вычислял задачу при проверке, неизвестно, поэтому
+	// такие задачи раздаются агентам заново
+	switch {
+	case record.Status == repo.TaskProcessing && !o.verifier.Enabled():
+		o.taskMemStorage.Put(task)
+		o.grantLease(task)
+	default:
+		o.queue.Push(task)
+	}
+}
+
+func (o *Orchestrator) abortExpression(id uint64) {
+	_, err := o.updateExpression(repo.Expression{
+		ID:     id,
+		Status: repo.ExpressionAborted,
+	})
+	if err != nil {
+		slog.Error("Failed to update expression status",
+			"expression_id", id,
+			"error", err,
+		)
+	}
+
+	o.exprMemStorage.Delete(id)
+}
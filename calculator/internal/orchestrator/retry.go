@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// attemptCounter считает неудачные попытки вычисления задач.
+type attemptCounter struct {
+	attempts map[uint64]int
+	mu       sync.Mutex
+}
+
+func newAttemptCounter() *attemptCounter {
+	return &attemptCounter{attempts: make(map[uint64]int)}
+}
+
+// Add отмечает еще одну неудачную попытку и возвращает их число.
+func (c *attemptCounter) Add(id uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempts[id]++
+
+	return c.attempts[id]
+}
+
+func (c *attemptCounter) Set(id uint64, attempts int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempts[id] = attempts
+}
+
+func (c *attemptCounter) Forget(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.attempts, id)
+}
+
+// retryBackoff возвращает паузу перед следующей попыткой:
+// base, 2*base, 4*base и так далее, но не больше maxDelay.
+func retryBackoff(attempts int, base, maxDelay time.Duration) time.Duration {
+	delay := base
+
+	for range attempts - 1 {
+		if delay >= maxDelay/2 {
+			return maxDelay
+		}
+
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// retryTask возвращает задачу в очередь после паузы, которая растет
+// с каждой неудачной попыткой. Задача, которая падает слишком часто,
+// считается ядовитой и роняет все выражение.
+func (o *Orchestrator) retryTask(task *calc.Task, cause string) error {
+	cfg := o.config
+	attempts := o.attempts.Add(task.Id)
+
+	o.verifier.Forget(task.Id)
+
+	if attempts >= cfg.TaskMaxAttempts {
+		arg1, arg2 := task.GetArguments()
+
+		return o.failTask(task, fmt.Sprintf(
+			"task %v %s %v failed %d times, last error: %s",
+			arg1,
+			task.GetOperator(),
+			arg2,
+			attempts,
+			cause,
+		))
+	}
+
+	delay := retryBackoff(
+		attempts,
+		cfg.TaskRetryBackoff,
+		cfg.TaskRetryMaxBackoff,
+	)
+
+	slog.Warn("Task failed, retrying",
+		"task_id", task.Id,
+		"attempts", attempts,
+		"delay", delay,
+		"error", cause,
+	)
+
+	time.AfterFunc(delay, func() {
+		// Выражение могло упасть, пока задача ждала повтора
+		if task.GetExpression().HasFailed() {
+			_ = o.cancelTask(task)
+			return
+		}
+
+		o.queue.Push(task)
+	})
+
+	_, err := o.repos.Tasks.Retry(task.Id, attempts)
+
+	return err
+}
+
+func (o *Orchestrator) failTask(task *calc.Task, reason string) error {
+	task.GetExpression().MarkAsFailed()
+
+	_, err := o.updateExpression(repo.Expression{
+		ID:     task.GetExpression().Id,
+		Status: repo.ExpressionFailed,
+		Error:  &reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.cancelTask(task)
+}
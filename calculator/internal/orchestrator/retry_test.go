@@ -0,0 +1,98 @@
+package orchestrator_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	base := 500 * time.Millisecond
+	maxDelay := 3 * time.Second
+
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 3 * time.Second},
+		{60, 3 * time.Second},
+	}
+
+	for _, c := range cases {
+		got := orchestrator.RetryBackoff(c.attempts, base, maxDelay)
+		if got != c.want {
+			t.Errorf(
+				"RetryBackoff(%d) = %v, want %v",
+				c.attempts,
+				got,
+				c.want,
+			)
+		}
+	}
+}
+
+func TestStaleResultAfterRedispatch(t *testing.T) {
+	t.Parallel()
+
+	o := orchestrator.New(
+		&orchestrator.Config{
+			SecretKey:          "secret",
+			AccessTokenTTL:     time.Hour,
+			AgentIdleTimeout:   time.Minute,
+			OwnershipLeaseTTL:  time.Minute,
+			TaskMaxProcessTime: time.Minute,
+			TaskMaxAttempts:    3,
+			VerifyReplicas:     1,
+			VerifyQuorum:       1,
+		},
+		orchestrator.Dependencies{Repos: fakeRepositories()},
+	)
+	token := issueToken(t, "secret", 1)
+
+	o.RegisterAgent("first")
+	o.RegisterAgent("second")
+	target := calculate(t, o, token, "2+3")
+
+	task, err := o.StartProcessingNextTask("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Первый агент не успел, и задачу выдали второму
+	o.ReclaimExpired(task.Id)
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		_, err = o.StartProcessingNextTask("second")
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the task was not handed out again")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	err = o.CompleteTask("first", task.Id, 6)
+	if !errors.Is(err, orchestrator.ErrTaskReassigned) {
+		t.Errorf("got error %v, want %v", err, orchestrator.ErrTaskReassigned)
+	}
+
+	err = o.CompleteTask("second", task.Id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expr := getExpression(t, o, token, target)
+	if expr.Result == nil || *expr.Result != 5 {
+		t.Errorf("got expression %+v, want result 5", expr)
+	}
+}
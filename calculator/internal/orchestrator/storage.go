@@ -55,8 +55,10 @@ func (s *exprStorage) Delete(id uint64) {
 	delete(s.expressions, id)
 }
 
-var ExpressionStorageInstance = &exprStorage{
-	expressions: make(map[uint64]*calc.Expression),
+func NewExpressionStorage() Storage[*calc.Expression] {
+	return &exprStorage{
+		expressions: make(map[uint64]*calc.Expression),
+	}
 }
 
 type taskStorage struct {
@@ -100,6 +102,8 @@ func (s *taskStorage) Delete(id uint64) {
 	delete(s.tasks, id)
 }
 
-var TaskStorageInstance = &taskStorage{
-	tasks: make(map[uint64]*calc.Task),
+func NewTaskStorage() Storage[*calc.Task] {
+	return &taskStorage{
+		tasks: make(map[uint64]*calc.Task),
+	}
 }
@@ -0,0 +1,34 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/pkg"
+)
+
+// certReloadPeriod — как часто проверяем, не обновились ли файлы
+// сертификата.
+const certReloadPeriod = 30 * time.Second
+
+// serverTLSConfig загружает сертификат и следит за его файлами,
+// пока не отменен контекст.
+func serverTLSConfig(
+	ctx context.Context,
+	files common.TLSFiles,
+) (*tls.Config, error) {
+	reloader, err := common.NewCertReloader(files)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := reloader.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	go reloader.Watch(ctx, certReloadPeriod)
+
+	return cfg, nil
+}
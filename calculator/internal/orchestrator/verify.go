@@ -0,0 +1,342 @@
+package orchestrator
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+// outcome — ответ агента на задачу: значение или математическая ошибка.
+type outcome struct {
+	Value  float64
+	Failed bool
+	Error  string
+}
+
+// Ошибки сравниваем без текста: разные версии агентов
+// могут описывать одну и ту же ошибку по-разному.
+func (o outcome) agrees(other outcome) bool {
+	if o.Failed || other.Failed {
+		return o.Failed == other.Failed
+	}
+
+	return o.Value == other.Value
+}
+
+type vote struct {
+	agentID string
+	answer  outcome
+}
+
+type verdictKind int
+
+const (
+	verdictPending  verdictKind = iota // Ждем ответов остальных агентов
+	verdictAccepted                    // Кворум согласился
+	verdictDisputed                    // Кворум уже не собрать
+)
+
+type verdict struct {
+	kind     verdictKind
+	accepted outcome
+	votes    []vote
+}
+
+// Dissenters returns the votes that disagree with the accepted answer.
+func (v verdict) Dissenters() []vote {
+	var dissenters []vote
+
+	for _, vote := range v.votes {
+		if v.kind == verdictDisputed || !vote.answer.agrees(v.accepted) {
+			dissenters = append(dissenters, vote)
+		}
+	}
+
+	return dissenters
+}
+
+// replicaSet — задача, которую вычисляют несколько агентов.
+type replicaSet struct {
+	task    *calc.Task
+	holders map[string]struct{} // Получили задачу и еще не ответили
+	votes   []vote
+}
+
+func (s *replicaSet) involves(agentID string) bool {
+	if _, ok := s.holders[agentID]; ok {
+		return true
+	}
+
+	for _, vote := range s.votes {
+		if vote.agentID == agentID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *replicaSet) needsReplicas(replicas int) bool {
+	return len(s.holders)+len(s.votes) < replicas
+}
+
+// verifier раздает каждую задачу нескольким разным агентам
+// и принимает результат, только когда с ним согласен кворум.
+type verifier struct {
+	replicas int
+	quorum   int
+	sets     map[uint64]*replicaSet
+	mu       sync.Mutex
+}
+
+func newVerifier(replicas int, quorum int) *verifier {
+	return &verifier{
+		replicas: replicas,
+		quorum:   quorum,
+		sets:     make(map[uint64]*replicaSet),
+	}
+}
+
+func (v *verifier) Enabled() bool {
+	return v.replicas > 1
+}
+
+// Track starts verifying a task that was just handed to the agent.
+func (v *verifier) Track(task *calc.Task, agentID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.sets[task.Id] = &replicaSet{
+		task:    task,
+		holders: map[string]struct{}{agentID: {}},
+	}
+}
+
+// NextReplica returns a task that still needs agents and was not
+// handed to this one yet. Tasks with a longer critical path go first.
+func (v *verifier) NextReplica(
+	agentID string,
+	accept func(operator string) bool,
+) (*calc.Task, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var best *replicaSet
+
+	for _, set := range v.sets {
+		if !set.needsReplicas(v.replicas) || set.involves(agentID) {
+			continue
+		}
+
+		if accept != nil && !accept(set.task.GetOperator()) {
+			continue
+		}
+
+		if best == nil || set.task.Priority() > best.task.Priority() {
+			best = set
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+
+	best.holders[agentID] = struct{}{}
+
+	return best.task, true
+}
+
+// Vote records the answer of the agent. Once the verdict is known,
+// the task is no longer tracked.
+func (v *verifier) Vote(
+	taskID uint64,
+	agentID string,
+	answer outcome,
+) (verdict, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	set, ok := v.sets[taskID]
+	if !ok {
+		return verdict{}, errReplicaNotAssigned
+	}
+
+	if _, ok = set.holders[agentID]; !ok {
+		return verdict{}, errReplicaNotAssigned
+	}
+
+	delete(set.holders, agentID)
+	set.votes = append(set.votes, vote{agentID: agentID, answer: answer})
+
+	result := verdict{kind: verdictPending, votes: set.votes}
+	bestCount := 0
+
+	for _, candidate := range set.votes {
+		count := 0
+
+		for _, other := range set.votes {
+			if other.answer.agrees(candidate.answer) {
+				count++
+			}
+		}
+
+		if count > bestCount {
+			bestCount = count
+			result.accepted = candidate.answer
+		}
+	}
+
+	unanswered := v.replicas - len(set.votes)
+
+	switch {
+	case bestCount >= v.quorum:
+		result.kind = verdictAccepted
+	case bestCount+unanswered < v.quorum:
+		result.kind = verdictDisputed
+	default:
+		return result, nil
+	}
+
+	delete(v.sets, taskID)
+
+	return result, nil
+}
+
+// Drop frees the place of an agent that will not answer,
+// so that another agent can take it. It reports whether the agent
+// was computing the task.
+func (v *verifier) Drop(taskID uint64, agentID string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	set, ok := v.sets[taskID]
+	if !ok {
+		return false
+	}
+
+	if _, ok = set.holders[agentID]; !ok {
+		return false
+	}
+
+	delete(set.holders, agentID)
+
+	return true
+}
+
+// Forget stops verifying the task, e.g. when it is retried from scratch.
+func (v *verifier) Forget(taskID uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.sets, taskID)
+}
+
+// voteOnTask учитывает ответ агента на задачу, которая проверяется
+// несколькими агентами. Пока кворум не собран, задача остается в работе.
+func (o *Orchestrator) voteOnTask(
+	agentID string,
+	task *calc.Task,
+	answer outcome,
+) error {
+	result, err := o.verifier.Vote(task.Id, agentID, answer)
+	if err != nil || result.kind == verdictPending {
+		return err
+	}
+
+	// Аренда могла истечь одновременно с последним ответом,
+	// тогда задачу уже вернули в очередь
+	if !o.leases.Release(task.Id) {
+		return errLeaseExpired
+	}
+
+	o.judgeAgents(task, result)
+
+	if result.kind == verdictDisputed {
+		return o.retryTask(task, errReplicasDisagree.Error())
+	}
+
+	if result.accepted.Failed {
+		return o.failTask(task, result.accepted.Error)
+	}
+
+	err = o.completeTask(task, result.accepted.Value, repo.StepAgent)
+	if err != nil {
+		return err
+	}
+
+	return o.enqueueReady(task.GetExpression())
+}
+
+// judgeAgents обновляет счетчики агентов по вердикту, отправляет
+// в карантин тех, кто не согласился с кворумом, и сохраняет
+// их ответы для разбора.
+func (o *Orchestrator) judgeAgents(task *calc.Task, result verdict) {
+	dissenters := result.Dissenters()
+
+	for _, vote := range result.votes {
+		agreed := result.kind == verdictAccepted &&
+			vote.answer.agrees(result.accepted)
+		o.registry.FinishFor(vote.agentID, task.Id, agreed)
+	}
+
+	// Ответы остальных агентов больше не нужны
+	o.registry.Release(task.Id)
+
+	for _, vote := range dissenters {
+		if result.kind == verdictAccepted {
+			slog.Warn("Agent returned a wrong result, quarantining it",
+				"agent_id", vote.agentID,
+				"task_id", task.Id,
+			)
+
+			o.registry.Quarantine(vote.agentID)
+		}
+
+		err := o.recordMismatch(task, vote, result)
+		if err != nil {
+			slog.Error("failed to record result mismatch",
+				"task_id", task.Id,
+				"agent_id", vote.agentID,
+				"error", err,
+			)
+		}
+	}
+}
+
+func (o *Orchestrator) recordMismatch(
+	task *calc.Task,
+	vote vote,
+	result verdict,
+) error {
+	arg1, arg2 := task.GetArguments()
+
+	mismatch := repo.Mismatch{
+		TaskID:       task.Id,
+		ExpressionID: task.GetExpression().Id,
+		AgentID:      vote.agentID,
+		Operator:     task.GetOperator(),
+		Arg1:         arg1,
+		Arg2:         arg2,
+	}
+
+	mismatch.Result, mismatch.Error = vote.answer.columns()
+
+	if result.kind == verdictAccepted {
+		mismatch.AcceptedResult, mismatch.AcceptedError =
+			result.accepted.columns()
+	}
+
+	_, err := o.repos.Mismatches.Create(mismatch)
+
+	return err
+}
+
+func (o outcome) columns() (*float64, *string) {
+	if o.Failed {
+		return nil, &o.Error
+	}
+
+	return &o.Value, nil
+}
@@ -0,0 +1,143 @@
+package orchestrator_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func newTask(t *testing.T, expression string) *calc.Task {
+	t.Helper()
+
+	expr, err := calc.NewExpression(expression, testTaskIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, ok := expr.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	return task
+}
+
+func TestVerifierReplicas(t *testing.T) {
+	v := orchestrator.NewVerifier(3, 2)
+	task := newTask(t, "2*3")
+
+	v.Track(task, "a")
+
+	if _, ok := v.NextReplica("a", nil); ok {
+		t.Error("agent got the same task twice")
+	}
+
+	if _, ok := v.NextReplica("b", func(string) bool { return false }); ok {
+		t.Error("agent got a task with an operator it does not support")
+	}
+
+	for _, agent := range []string{"b", "c"} {
+		replica, ok := v.NextReplica(agent, nil)
+		if !ok || replica != task {
+			t.Fatalf("agent %s did not get the task", agent)
+		}
+	}
+
+	if _, ok := v.NextReplica("d", nil); ok {
+		t.Error("task was handed to more agents than needed")
+	}
+
+	// Выбывший агент освобождает место
+	if !v.Drop(task.Id, "c") {
+		t.Fatal("failed to drop the agent")
+	}
+
+	if _, ok := v.NextReplica("d", nil); !ok {
+		t.Error("freed place was not handed out")
+	}
+}
+
+func TestVerifierQuorum(t *testing.T) {
+	v := orchestrator.NewVerifier(3, 2)
+	task := newTask(t, "2*3")
+
+	v.Track(task, "a")
+	v.NextReplica("b", nil)
+	v.NextReplica("c", nil)
+
+	res, err := v.Vote(task.Id, "a", orchestrator.Answer(6))
+	if err != nil || res.Kind() != orchestrator.VerdictPending {
+		t.Fatalf("got verdict %v, error %v, want pending", res.Kind(), err)
+	}
+
+	res, err = v.Vote(task.Id, "b", orchestrator.Answer(7))
+	if err != nil || res.Kind() != orchestrator.VerdictPending {
+		t.Fatalf("got verdict %v, error %v, want pending", res.Kind(), err)
+	}
+
+	res, err = v.Vote(task.Id, "c", orchestrator.Answer(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Kind() != orchestrator.VerdictAccepted {
+		t.Fatalf("got verdict %v, want accepted", res.Kind())
+	}
+
+	if res.Accepted() != orchestrator.Answer(6) {
+		t.Errorf("got accepted %+v, want 6", res.Accepted())
+	}
+
+	if !slices.Equal(res.DissenterIDs(), []string{"b"}) {
+		t.Errorf("got dissenters %v, want [b]", res.DissenterIDs())
+	}
+
+	_, err = v.Vote(task.Id, "c", orchestrator.Answer(6))
+	if !errors.Is(err, orchestrator.ErrReplicaNotAssigned) {
+		t.Errorf("got error %v for a decided task", err)
+	}
+}
+
+func TestVerifierDispute(t *testing.T) {
+	v := orchestrator.NewVerifier(2, 2)
+	task := newTask(t, "1/0")
+
+	v.Track(task, "a")
+	v.NextReplica("b", nil)
+
+	if _, err := v.Vote(task.Id, "c", orchestrator.Answer(1)); err == nil {
+		t.Error("accepted a vote from an agent without the task")
+	}
+
+	res, _ := v.Vote(task.Id, "a", orchestrator.MathError("division by zero"))
+	if res.Kind() != orchestrator.VerdictPending {
+		t.Fatalf("got verdict %v, want pending", res.Kind())
+	}
+
+	res, _ = v.Vote(task.Id, "b", orchestrator.Answer(1))
+	if res.Kind() != orchestrator.VerdictDisputed {
+		t.Fatalf("got verdict %v, want disputed", res.Kind())
+	}
+
+	if len(res.DissenterIDs()) != 2 {
+		t.Errorf("got dissenters %v, want both agents", res.DissenterIDs())
+	}
+}
+
+func TestVerifierMathErrorsAgree(t *testing.T) {
+	v := orchestrator.NewVerifier(2, 2)
+	task := newTask(t, "1/0")
+
+	v.Track(task, "a")
+	v.NextReplica("b", nil)
+
+	_, _ = v.Vote(task.Id, "a", orchestrator.MathError("division by zero"))
+
+	res, _ := v.Vote(task.Id, "b", orchestrator.MathError("divide by 0"))
+	if res.Kind() != orchestrator.VerdictAccepted || !res.Accepted().Failed {
+		t.Errorf("got verdict %v, want an accepted math error", res.Kind())
+	}
+}
@@ -0,0 +1,580 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const WebhookExpressionFinished = "expression.finished"
+
+const (
+	// webhookPollPeriod — как часто проверять очередь доставок,
+	// если о новых доставках никто не сообщил.
+	webhookPollPeriod = 5 * time.Second
+	// webhookBatchSize — сколько доставок отправляется одновременно.
+	webhookBatchSize = 32
+	// Ответ получателя дальше этого размера не читаем
+	webhookResponseLimit = 64 << 10
+)
+
+// WebhookPayload is the JSON body POSTed to the webhooks of the user.
+type WebhookPayload struct {
+	Event      string          `json:"event"`
+	Expression repo.Expression `json:"expression"`
+}
+
+// webhookDispatcher доставляет уведомления из очереди в базе.
+// Неудачная доставка повторяется с растущей паузой.
+type webhookDispatcher struct {
+	deliveries  repo.WebhookDeliveryRepository
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+	wake        chan struct{}
+}
+
+func newWebhookDispatcher(
+	deliveries repo.WebhookDeliveryRepository,
+	timeout time.Duration,
+	maxAttempts int,
+	backoff time.Duration,
+	maxBackoff time.Duration,
+	now func() time.Time,
+) *webhookDispatcher {
+	return &webhookDispatcher{
+		deliveries: deliveries,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: newWebhookTransport(),
+		},
+		maxAttempts: max(maxAttempts, 1),
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		now:         now,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// newWebhookTransport не дает соединиться с внутренними адресами, даже
+// если хост вебхука после регистрации стал указывать на них.
+// Прокси не используется: иначе проверялся бы адрес прокси.
+func newWebhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   webhookDialControl,
+	}).DialContext
+
+	return transport
+}
+
+// webhookDialControl проверяет адрес, с которым уже решено соединиться.
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errForbiddenWebhookAddress
+	}
+
+	return nil
+}
+
+// blockedWebhookNets — внутренние сети, которые не распознает net.IP:
+// общее адресное пространство провайдеров (CGNAT) и префикс NAT64,
+// через который IPv6-адрес ведет на любой IPv4, в том числе внутренний.
+var blockedWebhookNets = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return ipNet
+}
+
+// isPublicIP сообщает, можно ли слать вебхуки на адрес. Адреса самого
+// оркестратора и внутренних сетей пользователям недоступны.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, ipNet := range blockedWebhookNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Wake tells the dispatcher that there are new deliveries.
+func (d *webhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until the context is canceled.
+func (d *webhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		// Полная пачка значит, что в очереди могут быть еще доставки
+		sent := webhookBatchSize
+		for sent == webhookBatchSize && ctx.Err() == nil {
+			sent = d.dispatch(ctx)
+		}
+	}
+}
+
+// dispatch отправляет подошедшие доставки и возвращает их число.
+func (d *webhookDispatcher) dispatch(ctx context.Context) int {
+	// Пока доставка отправляется, ее не возьмет другой обработчик.
+	// Если мы упадем, ее отправят заново по истечении этого срока
+	claimedUntil := d.now().Add(2 * d.client.Timeout)
+
+	due, err := d.deliveries.Claim(webhookBatchSize, claimedUntil)
+	if err != nil {
+		slog.Error("Failed to claim webhook deliveries", "error", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+
+	for _, delivery := range due {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := d.deliveries.Update(d.deliver(ctx, delivery))
+			if err != nil {
+				slog.Error("Failed to record webhook delivery",
+					slog.Uint64("delivery_id", delivery.ID),
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return len(due)
+}
+
+// deliver делает одну попытку доставки и возвращает ее итог.
+func (d *webhookDispatcher) deliver(
+	ctx context.Context,
+	due repo.DueDelivery,
+) repo.WebhookDelivery {
+	delivery := due.WebhookDelivery
+	delivery.Attempts++
+	delivery.LastStatusCode = nil
+	delivery.LastError = nil
+
+	code, err := d.send(ctx, due)
+	if code != 0 {
+		delivery.LastStatusCode = &code
+	}
+
+	if err == nil {
+		delivery.Status = repo.DeliveryDelivered
+		return delivery
+	}
+
+	cause := err.Error()
+	delivery.LastError = &cause
+
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = repo.DeliveryFailed
+
+		slog.Warn("Webhook delivery failed",
+			slog.Uint64("delivery_id", delivery.ID),
+			slog.String("url", due.URL),
+			slog.String("error", cause),
+		)
+
+		return delivery
+	}
+
+	delivery.Status = repo.DeliveryPending
+	delivery.NextAttemptAt = d.now().Add(
+		retryBackoff(delivery.Attempts, d.backoff, d.maxBackoff),
+	)
+
+	return delivery
+}
+
+func (d *webhookDispatcher) send(
+	ctx context.Context,
+	due repo.DueDelivery,
+) (int, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		due.URL,
+		bytes.NewReader(due.Payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := d.now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(due.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(
+		WebhookSignatureHeader,
+		signWebhook(due.Secret, timestamp, due.Payload),
+	)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Дочитываем ответ, чтобы соединение можно было переиспользовать
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf(
+			"webhook responded with status %d", resp.StatusCode,
+		)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// signWebhook подписывает тело уведомления вместе со временем отправки,
+// чтобы перехваченное уведомление нельзя было повторить позже.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// ipResolver находит адреса хоста, его реализует *net.Resolver.
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// validateWebhookURL проверяет url вебхука и то, что все адреса его
+// хоста публичные. Адреса проверяются еще раз при каждой доставке.
+func validateWebhookURL(
+	ctx context.Context,
+	resolver ipResolver,
+	raw string,
+) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" ||
+		(u.Scheme != "http" && u.Scheme != "https") {
+		return errInvalidWebhookURL
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errUnresolvedWebhookHost
+	}
+
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return errForbiddenWebhookAddress
+		}
+	}
+
+	return nil
+}
+
+// webhookPayload строит тело вебхуков о завершенном выражении.
+// Репозиторий ставит доставки в очередь в той же транзакции,
+// в которой сохраняет статус выражения.
+func webhookPayload(expr repo.Expression) (json.RawMessage, error) {
+	return json.Marshal(WebhookPayload{
+		Event:      WebhookExpressionFinished,
+		Expression: expr,
+	})
+}
+
+// CreateWebhook registers the URL and generates its signing secret.
+// URLs of loopback, private and link-local addresses are rejected.
+func (o *Orchestrator) CreateWebhook(
+	ctx context.Context,
+	userID uint64,
+	rawURL string,
+) (repo.Webhook, error) {
+	err := validateWebhookURL(ctx, net.DefaultResolver, rawURL)
+	if err != nil {
+		return repo.Webhook{}, err
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return repo.Webhook{}, err
+	}
+
+	return o.repos.Webhooks.Create(repo.Webhook{
+		UserID: userID,
+		URL:    rawURL,
+		Secret: secret,
+	})
+}
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []repo.Webhook `json:"webhooks"`
+}
+
+// WebhooksHandler lists the webhooks of the user on GET
+// and registers a new one on POST.
+func (o *Orchestrator) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(UserIDKey).(uint64)
+
+	if r.Method == http.MethodPost {
+		o.createWebhook(w, r, userID)
+		return
+	}
+
+	webhooks, err := o.repos.Webhooks.GetForUser(userID)
+	if err != nil {
+		slog.Error("Failed to get webhooks", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	if webhooks == nil {
+		webhooks = []repo.Webhook{}
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	err = json.NewEncoder(w).Encode(WebhooksResponse{Webhooks: webhooks})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+func (o *Orchestrator) createWebhook(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID uint64,
+) {
+	req := WebhookRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, errInvalidRequestBody)
+
+		return
+	}
+
+	webhook, err := o.CreateWebhook(r.Context(), userID, req.URL)
+	if err != nil {
+		if errors.Is(err, errInvalidWebhookURL) ||
+			errors.Is(err, errForbiddenWebhookAddress) ||
+			errors.Is(err, errUnresolvedWebhookHost) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			slog.Error("Failed to create webhook",
+				slog.String("error", err.Error()),
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		WriteError(w, err)
+
+		return
+	}
+
+	// Секрет отдается только здесь, потом его не узнать
+	err = json.NewEncoder(w).Encode(webhook)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// DeleteWebhookHandler removes the webhook of the user.
+// Its pending deliveries are dropped.
+func (o *Orchestrator) DeleteWebhookHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	webhook, ok := o.userWebhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := o.repos.Webhooks.Delete(webhook.ID)
+	if err != nil {
+		slog.Error("Failed to delete webhook", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	defaultDeliveriesLimit = 100
+	maxDeliveriesLimit     = 1000
+)
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []repo.WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookDeliveriesHandler returns the latest deliveries to the webhook
+// with the outcome of their last attempt.
+func (o *Orchestrator) WebhookDeliveriesHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	webhook, ok := o.userWebhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := limitFromQuery(r, defaultDeliveriesLimit, maxDeliveriesLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, err)
+
+		return
+	}
+
+	deliveries, err := o.repos.WebhookDeliveries.ForWebhook(
+		webhook.ID,
+		limit,
+	)
+	if err != nil {
+		slog.Error("Failed to get webhook deliveries",
+			slog.String("error", err.Error()),
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []repo.WebhookDelivery{}
+	}
+
+	err = json.NewEncoder(w).Encode(
+		WebhookDeliveriesResponse{Deliveries: deliveries},
+	)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+	}
+}
+
+// userWebhookFromPath достает вебхук по id из url
+// и проверяет, что он принадлежит текущему пользователю.
+// В случае ошибки пишет ответ сам и возвращает false.
+func (o *Orchestrator) userWebhookFromPath(
+	w http.ResponseWriter,
+	r *http.Request,
+) (repo.Webhook, bool) {
+	webhookID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, errInvalidIdInUrl)
+
+		return repo.Webhook{}, false
+	}
+
+	webhook, err := o.repos.Webhooks.Get(webhookID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("Failed to get webhook", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, err)
+
+		return repo.Webhook{}, false
+	}
+
+	userID := r.Context().Value(UserIDKey).(uint64)
+	if err != nil || webhook.UserID != userID {
+		w.WriteHeader(http.StatusNotFound)
+		WriteError(w, errWebhookNotFound)
+
+		return repo.Webhook{}, false
+	}
+
+	return webhook, true
+}
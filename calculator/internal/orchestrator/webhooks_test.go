@@ -0,0 +1,269 @@
+package orchestrator_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/orchestrator"
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+)
+
+const testWebhookSecret = "test-secret"
+
+// webhookReceiver — локальный получатель вебхуков. Проверяет подпись
+// так, как это сделал бы сервис пользователя, и отвечает status.
+type webhookReceiver struct {
+	*httptest.Server
+	status   int
+	received chan []byte
+	t        *testing.T
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	receiver := &webhookReceiver{
+		status:   status,
+		received: make(chan []byte, 1),
+		t:        t,
+	}
+	receiver.Server = httptest.NewServer(receiver)
+	t.Cleanup(receiver.Close)
+
+	return receiver
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Error(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(r.Header.Get(orchestrator.WebhookTimestampHeader) + "."))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	got := r.Header.Get(orchestrator.WebhookSignatureHeader)
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		rc.t.Errorf("got signature %q, want %q", got, want)
+	}
+
+	if r.Header.Get(orchestrator.WebhookDeliveryHeader) != "7" {
+		rc.t.Errorf("got delivery id %q, want 7",
+			r.Header.Get(orchestrator.WebhookDeliveryHeader))
+	}
+
+	rc.received <- body
+
+	w.WriteHeader(rc.status)
+}
+
+func dueDelivery(url string, attempts int) repo.DueDelivery {
+	payload, _ := json.Marshal(orchestrator.WebhookPayload{
+		Event:      orchestrator.WebhookExpressionFinished,
+		Expression: repo.Expression{ID: 1, Status: repo.ExpressionSucceed},
+	})
+
+	return repo.DueDelivery{
+		WebhookDelivery: repo.WebhookDelivery{
+			ID:       7,
+			Payload:  payload,
+			Status:   repo.DeliveryPending,
+			Attempts: attempts,
+		},
+		URL:    url,
+		Secret: testWebhookSecret,
+	}
+}
+
+func newTestDispatcher() *orchestrator.WebhookDispatcher {
+	d := orchestrator.NewWebhookDispatcher(
+		nil,
+		time.Second,
+		3,
+		time.Second,
+		time.Minute,
+		time.Now,
+	)
+	d.AllowPrivateAddresses()
+
+	return d
+}
+
+func TestWebhookDispatcher_Delivered(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusNoContent)
+	d := newTestDispatcher()
+
+	due := dueDelivery(receiver.URL, 0)
+	delivery := d.Deliver(context.Background(), due)
+
+	if delivery.Status != repo.DeliveryDelivered || delivery.Attempts != 1 {
+		t.Errorf("got delivery %+v, want a delivered one", delivery)
+	}
+
+	if delivery.LastStatusCode == nil ||
+		*delivery.LastStatusCode != http.StatusNoContent {
+		t.Errorf("got status code %v, want %d",
+			delivery.LastStatusCode, http.StatusNoContent)
+	}
+
+	if body := <-receiver.received; string(body) != string(due.Payload) {
+		t.Errorf("got body %s, want %s", body, due.Payload)
+	}
+}
+
+func TestWebhookDispatcher_Retry(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	d := newTestDispatcher()
+
+	before := time.Now()
+	delivery := d.Deliver(context.Background(), dueDelivery(receiver.URL, 1))
+	<-receiver.received
+
+	if delivery.Status != repo.DeliveryPending || delivery.Attempts != 2 {
+		t.Fatalf("got delivery %+v, want a pending one", delivery)
+	}
+
+	// После второй неудачи пауза удваивается
+	if delivery.NextAttemptAt.Before(before.Add(2 * time.Second)) {
+		t.Errorf("next attempt at %s is too early", delivery.NextAttemptAt)
+	}
+
+	if delivery.LastError == nil ||
+		!strings.Contains(*delivery.LastError, "500") {
+		t.Errorf("got error %v, want the status code", delivery.LastError)
+	}
+
+	delivery = d.Deliver(context.Background(), dueDelivery(receiver.URL, 2))
+	<-receiver.received
+
+	if delivery.Status != repo.DeliveryFailed {
+		t.Errorf("got status %s after the last attempt, want %s",
+			delivery.Status, repo.DeliveryFailed)
+	}
+}
+
+func TestWebhookDispatcher_Unreachable(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	receiver.Close()
+
+	d := newTestDispatcher()
+	delivery := d.Deliver(context.Background(), dueDelivery(receiver.URL, 0))
+
+	if delivery.Status != repo.DeliveryPending ||
+		delivery.LastStatusCode != nil || delivery.LastError == nil {
+		t.Errorf("got delivery %+v, want a pending one with an error", delivery)
+	}
+}
+
+func TestWebhookDispatcher_PrivateAddress(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+
+	// Хост мог начать указывать на внутренний адрес после регистрации
+	d := orchestrator.NewWebhookDispatcher(
+		nil,
+		time.Second,
+		3,
+		time.Second,
+		time.Minute,
+		time.Now,
+	)
+	delivery := d.Deliver(context.Background(), dueDelivery(receiver.URL, 0))
+
+	if delivery.Status != repo.DeliveryPending || delivery.LastError == nil ||
+		!strings.Contains(*delivery.LastError, "public address") {
+		t.Errorf("got delivery %+v, want a rejected one", delivery)
+	}
+
+	select {
+	case <-receiver.received:
+		t.Error("webhook was delivered to a loopback address")
+	default:
+	}
+}
+
+// fakeResolver отвечает заранее известными адресами.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(
+	_ context.Context,
+	host string,
+) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+
+	for _, ip := range r[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+
+	if addrs == nil {
+		return nil, errors.New("no such host")
+	}
+
+	return addrs, nil
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	resolver := fakeResolver{
+		"example.com":      {"93.184.215.14"},
+		"localhost":        {"127.0.0.1", "::1"},
+		"internal":         {"10.0.0.5"},
+		"rebinding.com":    {"93.184.215.14", "192.168.1.1"},
+		"93.184.215.14":    {"93.184.215.14"},
+		"169.254.169.254":  {"169.254.169.254"},
+		"0.0.0.0":          {"0.0.0.0"},
+		"::ffff:127.0.0.1": {"::ffff:127.0.0.1"},
+		"carrier":          {"100.64.0.1"},
+		"nat64":            {"64:ff9b::a00:5"},
+	}
+
+	cases := map[string]bool{
+		"https://example.com/hook":       true,
+		"http://93.184.215.14:8080/hook": true,
+		"http://localhost:9000":          false,
+		"http://internal/hook":           false,
+		"https://rebinding.com":          false,
+		"http://169.254.169.254/latest":  false,
+		"http://0.0.0.0:8000":            false,
+		"http://[::ffff:127.0.0.1]:8000": false,
+		"http://carrier/hook":            false,
+		"http://nat64/hook":              false,
+		"https://unknown.example":        false,
+		"ftp://example.com":              false,
+		"/relative/path":                 false,
+		"https://":                       false,
+	}
+
+	for url, valid := range cases {
+		err := orchestrator.ValidateWebhookURL(
+			context.Background(),
+			resolver,
+			url,
+		)
+		if (err == nil) != valid {
+			t.Errorf("ValidateWebhookURL(%q) = %v, want valid %v", url, err, valid)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	a := orchestrator.SignWebhook("secret", 1, []byte(`{}`))
+	b := orchestrator.SignWebhook("secret", 2, []byte(`{}`))
+
+	if a == b {
+		t.Error("signature does not depend on the timestamp")
+	}
+
+	if !strings.HasPrefix(a, "sha256=") {
+		t.Errorf("got signature %q without the algorithm", a)
+	}
+}
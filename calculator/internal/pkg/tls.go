@@ -0,0 +1,201 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoCertificates = errors.New("no certificates found in the file")
+
+// TLSFiles describes where a server takes its certificate from.
+// An empty CertFile means TLS is disabled.
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // Clients must present a certificate signed by it
+	MinVersion   uint16
+}
+
+func (f TLSFiles) Enabled() bool {
+	return f.CertFile != ""
+}
+
+// ParseTLSVersion accepts "1.2" and "1.3".
+func ParseTLSVersion(version string) (uint16, bool) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, true
+	case "1.3":
+		return tls.VersionTLS13, true
+	}
+
+	return 0, false
+}
+
+// CertReloader serves the certificate from the files and picks up
+// a new one when the files change, so certificates can be rotated
+// without a restart.
+type CertReloader struct {
+	files   TLSFiles
+	cert    atomic.Pointer[tls.Certificate]
+	modTime time.Time
+}
+
+func NewCertReloader(files TLSFiles) (*CertReloader, error) {
+	r := &CertReloader{files: files}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TLSConfig returns a server config that always uses the latest certificate.
+func (r *CertReloader) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: max(r.files.MinVersion, tls.VersionTLS12),
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+
+	if r.files.ClientCAFile != "" {
+		pool, err := loadCertPool(r.files.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// Watch checks the files every period until the context is canceled.
+// If the new files are broken, the previous certificate stays in use.
+func (r *CertReloader) Watch(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err == nil && changed {
+				err = r.reload()
+			}
+
+			if err != nil {
+				slog.Error("failed to reload the certificate",
+					"cert_file", r.files.CertFile,
+					"error", err,
+				)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) changed() (bool, error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+
+	return !modTime.Equal(r.modTime), nil
+}
+
+func (r *CertReloader) reload() error {
+	// Время берем до чтения, чтобы не пропустить запись во время загрузки
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert.Store(&cert)
+	r.modTime = modTime
+
+	slog.Info("loaded the certificate", "cert_file", r.files.CertFile)
+
+	return nil
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{r.files.CertFile, r.files.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// ClientTLSConfig verifies the server against caFile or, if it is empty,
+// against the system roots. The client certificate is optional.
+// An empty serverName means the host the client dials.
+func ClientTLSConfig(
+	caFile string,
+	certFile string,
+	keyFile string,
+	serverName string,
+) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrNoCertificates
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,132 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/pkg"
+)
+
+// writeCert сохраняет самоподписанный сертификат и возвращает его DER.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader,
+		template,
+		template,
+		&key.PublicKey,
+		key,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err = os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	files := common.TLSFiles{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+
+	first := writeCert(t, files.CertFile, files.KeyFile, 1)
+
+	reloader, err := common.NewCertReloader(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := reloader.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := func() []byte {
+		cert, err := cfg.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cert.Certificate[0]
+	}
+
+	if !bytes.Equal(current(), first) {
+		t.Fatal("got a different certificate")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	second := writeCert(t, files.CertFile, files.KeyFile, 2)
+
+	// Время изменения могло совпасть с прежним
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{files.CertFile, files.KeyFile} {
+		if err = os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(current(), second) {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	if _, ok := common.ParseTLSVersion("1.3"); !ok {
+		t.Error("failed to parse 1.3")
+	}
+
+	if _, ok := common.ParseTLSVersion("1.0"); ok {
+		t.Error("TLS 1.0 must not be accepted")
+	}
+}
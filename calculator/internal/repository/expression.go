@@ -2,6 +2,10 @@ package repo
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dzherb/go_calculator/calculator/internal/storage"
@@ -17,39 +21,94 @@ const (
 	ExpressionSucceed    ExpressionStatus = "succeed"
 	ExpressionAborted    ExpressionStatus = "aborted"
 	ExpressionFailed     ExpressionStatus = "failed"
+	ExpressionCanceled   ExpressionStatus = "canceled"
 )
 
+// IsFinished reports whether the expression status will not change anymore.
+func (s ExpressionStatus) IsFinished() bool {
+	switch s {
+	case ExpressionSucceed, ExpressionAborted, ExpressionFailed,
+		ExpressionCanceled:
+		return true
+	}
+
+	return false
+}
+
+// IsValid reports whether the status is one of the known ones.
+func (s ExpressionStatus) IsValid() bool {
+	switch s {
+	case ExpressionNew, ExpressionProcessing, ExpressionSucceed,
+		ExpressionAborted, ExpressionFailed, ExpressionCanceled:
+		return true
+	}
+
+	return false
+}
+
+// ExpressionChannel is the notification channel
+// that carries ExpressionChange of every saved expression.
+const ExpressionChannel = "expression_changes"
+
+// ExpressionChange is a notification about a saved expression.
+// Notification payloads are limited to 8000 bytes, so it carries
+// neither the expression text nor the other unbounded fields:
+// listeners that need them get the expression by id.
+type ExpressionChange struct {
+	Origin string           `json:"origin"` // Экземпляр, сохранивший выражение
+	ID     uint64           `json:"id"`
+	Status ExpressionStatus `json:"status"`
+	Result *float64         `json:"result"`
+	Error  *string          `json:"error"`
+}
+
 type Expression struct {
 	ID         uint64           `json:"id"`
 	UserID     uint64           `json:"user_id"`
 	Status     ExpressionStatus `json:"status"`
 	Expression string           `json:"expression"`
 	Result     *float64         `json:"result"`
+	Error      *string          `json:"error"` // Причина неудачи вычисления
 	CreatedAt  time.Time        `json:"created_at"`
 	UpdatedAt  time.Time        `json:"updated_at"`
+	// Оркестратор, который вычисляет выражение, и до какого момента
+	Owner           *string    `json:"-"`
+	OwnerLeaseUntil *time.Time `json:"-"`
 }
 
+// WebhookPayloadFunc builds the body of the webhooks
+// about the finished expression.
+type WebhookPayloadFunc func(expr Expression) (json.RawMessage, error)
+
 type ExpressionRepository interface {
 	Get(id uint64) (Expression, error)
 	Create(expression Expression) (Expression, error)
-	Update(expression Expression) (Expression, error)
-	GetForUser(userID uint64) ([]Expression, error)
-	Unprocessed() ([]Expression, error)
+	CreateMany(expressions []Expression) ([]Expression, error)
+	Update(
+		expression Expression,
+		webhook WebhookPayloadFunc,
+	) (Expression, error)
+	Cancel(id uint64, webhook WebhookPayloadFunc) (Expression, error)
+	ListForUser(query ExpressionListQuery) (ExpressionPage, error)
+	Claim(owner string, leaseUntil time.Time, limit int) ([]Expression, error)
+	RenewOwnership(owner string, leaseUntil time.Time) ([]uint64, error)
+	ReleaseOwnership(owner string) error
 }
 
 type ExpressionRepositoryImpl struct {
-	db storage.Connection
+	db     storage.Connection
+	origin string // Подписывает уведомления об изменениях
 }
 
-func NewExpressionRepository() ExpressionRepository {
+// NewExpressionRepository returns the repository that announces
+// the changes it saves on behalf of the origin instance.
+func NewExpressionRepository(
+	db storage.Connection,
+	origin string,
+) ExpressionRepository {
 	return &ExpressionRepositoryImpl{
-		db: storage.Conn(),
-	}
-}
-
-func NewExpressionRepositoryFromTx(tx pgx.Tx) ExpressionRepository {
-	return &ExpressionRepositoryImpl{
-		db: tx,
+		db:     db,
+		origin: origin,
 	}
 }
 
@@ -59,7 +118,8 @@ func (er *ExpressionRepositoryImpl) Get(id uint64) (Expression, error) {
 		context.Background(),
 		er.db,
 		&expr,
-		`SELECT id, user_id, status, expression, result, created_at, updated_at
+		`SELECT id, user_id, status, expression, result, error, created_at,
+		updated_at, owner, owner_lease_until
 		FROM expressions
 		WHERE id = $1;`,
 		id,
@@ -75,60 +135,336 @@ func (er *ExpressionRepositoryImpl) Get(id uint64) (Expression, error) {
 func (er *ExpressionRepositoryImpl) Create(
 	expr Expression,
 ) (Expression, error) {
-	err := pgxscan.Get(
-		context.Background(),
-		er.db,
-		&expr,
-		`INSERT INTO expressions (user_id, status, expression)
-		VALUES ($1, 'new', $2)
-		RETURNING id, user_id, status, expression, result, created_at, updated_at;`,
+	// Новое выражение еще не завершено, вебхукам сообщать нечего
+	return er.saveAndNotify(
+		nil,
+		`INSERT INTO expressions
+		(user_id, status, expression, owner, owner_lease_until)
+		VALUES ($1, 'new', $2, $3, $4)
+		RETURNING id, user_id, status, expression, result, error, created_at,
+		updated_at, owner, owner_lease_until;`,
 		expr.UserID,
 		expr.Expression,
+		expr.Owner,
+		expr.OwnerLeaseUntil,
 	)
+}
+
+// CreateMany creates the expressions in one transaction,
+// so either all of them are saved or none.
+func (er *ExpressionRepositoryImpl) CreateMany(
+	exprs []Expression,
+) ([]Expression, error) {
+	created := make([]Expression, 0, len(exprs))
+
+	err := storage.WithTransaction(
+		context.Background(),
+		er.db,
+		func(tx pgx.Tx) error {
+			txRepo := NewExpressionRepository(tx, er.origin)
+
+			for _, expr := range exprs {
+				saved, err := txRepo.Create(expr)
+				if err != nil {
+					return err
+				}
 
+				created = append(created, saved)
+			}
+
+			return nil
+		},
+	)
 	if err != nil {
-		return Expression{}, err
+		return nil, err
 	}
 
-	return expr, nil
+	return created, nil
 }
 
+// Update saves the outcome of the evaluation. Only the origin instance
+// may save it while it owns the expression and the expression is not
+// finished yet. Otherwise pgx.ErrNoRows is returned: the expression was
+// claimed by another instance or canceled.
+// If the update finishes the expression, deliveries to the webhooks
+// of the user are enqueued in the same transaction, see saveAndNotify.
 func (er *ExpressionRepositoryImpl) Update(
 	expr Expression,
+	webhook WebhookPayloadFunc,
 ) (Expression, error) {
-	err := pgxscan.Get(
-		context.Background(),
-		er.db,
-		&expr,
+	return er.saveAndNotify(
+		webhook,
 		`UPDATE expressions
-		SET status = $2, result = $3
-		WHERE id = $1
-		RETURNING id, user_id, status, expression, result, created_at, updated_at;`,
+		SET status = $2, result = $3, error = $4
+		WHERE id = $1 AND owner = $5 AND status IN ('new', 'processing')
+		RETURNING id, user_id, status, expression, result, error, created_at,
+		updated_at, owner, owner_lease_until;`,
 		expr.ID,
 		expr.Status,
 		expr.Result,
+		expr.Error,
+		er.origin,
 	)
+}
+
+// Cancel marks the expression as canceled unless it is already finished.
+// Finished expressions are left intact and pgx.ErrNoRows is returned.
+// Webhook deliveries are enqueued as in Update.
+func (er *ExpressionRepositoryImpl) Cancel(
+	id uint64,
+	webhook WebhookPayloadFunc,
+) (Expression, error) {
+	return er.saveAndNotify(
+		webhook,
+		`UPDATE expressions
+		SET status = 'canceled'
+		WHERE id = $1 AND status IN ('new', 'processing')
+		RETURNING id, user_id, status, expression, result, error, created_at,
+		updated_at, owner, owner_lease_until;`,
+		id,
+	)
+}
+
+// saveAndNotify runs the query that returns the saved expression
+// and announces the change on ExpressionChannel in the same transaction,
+// so that other orchestrators only hear about committed changes.
+// If the expression is finished and webhook is not nil, its deliveries
+// are enqueued in that transaction too: a crash right after the commit
+// cannot lose them.
+func (er *ExpressionRepositoryImpl) saveAndNotify(
+	webhook WebhookPayloadFunc,
+	query string,
+	args ...any,
+) (Expression, error) {
+	ctx := context.Background()
+	saved := Expression{}
+
+	err := storage.WithTransaction(ctx, er.db, func(tx pgx.Tx) error {
+		err := pgxscan.Get(ctx, tx, &saved, query, args...)
+		if err != nil {
+			return err
+		}
+
+		if webhook != nil && saved.Status.IsFinished() {
+			payload, err := webhook(saved)
+			if err != nil {
+				return err
+			}
+
+			_, err = NewWebhookDeliveryRepository(tx).Enqueue(
+				saved.UserID,
+				saved.ID,
+				payload,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		payload, err := json.Marshal(ExpressionChange{
+			Origin: er.origin,
+			ID:     saved.ID,
+			Status: saved.Status,
+			Result: saved.Result,
+			Error:  saved.Error,
+		})
+		if err != nil {
+			return err
+		}
+
+		return storage.Notify(ctx, tx, ExpressionChannel, string(payload))
+	})
 
 	if err != nil {
 		return Expression{}, err
 	}
 
-	return expr, nil
+	return saved, nil
+}
+
+// ExpressionSort is the order of expressions in a list. Ties are broken
+// by id, so that the order is stable and can be paginated.
+type ExpressionSort string
+
+const (
+	SortCreatedDesc ExpressionSort = "-created_at"
+	SortCreatedAsc  ExpressionSort = "created_at"
+	SortUpdatedDesc ExpressionSort = "-updated_at"
+	SortUpdatedAsc  ExpressionSort = "updated_at"
+)
+
+func (s ExpressionSort) IsValid() bool {
+	switch s {
+	case SortCreatedDesc, SortCreatedAsc, SortUpdatedDesc, SortUpdatedAsc:
+		return true
+	}
+
+	return false
+}
+
+func (s ExpressionSort) column() string {
+	return strings.TrimPrefix(string(s), "-")
+}
+
+func (s ExpressionSort) descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// cursorAt возвращает курсор, указывающий на выражение.
+func (s ExpressionSort) cursorAt(expr Expression) ExpressionCursor {
+	cursor := ExpressionCursor{Sort: s, At: expr.CreatedAt, ID: expr.ID}
+	if s.column() == "updated_at" {
+		cursor.At = expr.UpdatedAt
+	}
+
+	return cursor
+}
+
+// ExpressionFilter narrows down a list of expressions.
+// Zero fields do not filter anything.
+type ExpressionFilter struct {
+	Statuses      []ExpressionStatus
+	CreatedAfter  *time.Time // Включительно
+	CreatedBefore *time.Time // Не включительно
+	// Подстрока текста выражения, без учета регистра
+	Contains string
+}
+
+// ExpressionCursor points at the last expression of a page,
+// the next page starts right after it.
+type ExpressionCursor struct {
+	Sort ExpressionSort `json:"s"`
+	At   time.Time      `json:"t"` // Значение поля сортировки
+	ID   uint64         `json:"id"`
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c ExpressionCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeExpressionCursor parses a cursor returned by Encode.
+func DecodeExpressionCursor(encoded string) (ExpressionCursor, error) {
+	cursor := ExpressionCursor{}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil {
+		return cursor, err
+	}
+
+	if !cursor.Sort.IsValid() {
+		return cursor, fmt.Errorf("unknown sort %q", cursor.Sort)
+	}
+
+	return cursor, nil
+}
+
+type ExpressionListQuery struct {
+	UserID uint64
+	Filter ExpressionFilter
+	Sort   ExpressionSort
+	Limit  int
+	// Курсор предыдущей страницы, nil для первой
+	After *ExpressionCursor
+}
+
+type ExpressionPage struct {
+	Expressions []Expression
+	// Сколько выражений подходит под фильтр на всех страницах
+	Total int
+	// nil на последней странице
+	Next *ExpressionCursor
+}
+
+// ListForUser returns a page of the user's expressions that match
+// the filter. The cursor must come from a list with the same sort.
+func (er *ExpressionRepositoryImpl) ListForUser(
+	query ExpressionListQuery,
+) (ExpressionPage, error) {
+	if query.Sort == "" {
+		query.Sort = SortCreatedDesc
+	}
+
+	// Поле сортировки попадает прямо в запрос
+	if !query.Sort.IsValid() {
+		return ExpressionPage{}, fmt.Errorf("unknown sort %q", query.Sort)
+	}
+
+	where, args := query.Filter.where(query.UserID)
+
+	page := ExpressionPage{}
+
+	err := pgxscan.Get(
+		context.Background(),
+		er.db,
+		&page.Total,
+		`SELECT count(*) FROM expressions WHERE `+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return ExpressionPage{}, err
+	}
+
+	page.Expressions, err = er.selectPage(query, where, args)
+	if err != nil {
+		return ExpressionPage{}, err
+	}
+
+	// Лишнее выражение показывает, что есть следующая страница
+	if len(page.Expressions) > query.Limit {
+		page.Expressions = page.Expressions[:query.Limit]
+		next := query.Sort.cursorAt(page.Expressions[query.Limit-1])
+		page.Next = &next
+	}
+
+	return page, nil
 }
 
-func (er *ExpressionRepositoryImpl) GetForUser(
-	userID uint64,
+// selectPage выбирает на одно выражение больше, чем помещается
+// на страницу, начиная сразу после курсора.
+func (er *ExpressionRepositoryImpl) selectPage(
+	query ExpressionListQuery,
+	where string,
+	args []any,
 ) ([]Expression, error) {
+	column := query.Sort.column()
+	order, after := "ASC", ">"
+
+	if query.Sort.descending() {
+		order, after = "DESC", "<"
+	}
+
+	if query.After != nil {
+		args = append(args, query.After.At, query.After.ID)
+		where += fmt.Sprintf(
+			" AND (%s, id) %s ($%d, $%d)",
+			column, after, len(args)-1, len(args),
+		)
+	}
+
+	args = append(args, query.Limit+1)
+
 	var exprs []Expression
 	err := pgxscan.Select(
 		context.Background(),
 		er.db,
 		&exprs,
-		`SELECT id, user_id, status, expression, result, created_at, updated_at
-		FROM expressions
-		WHERE user_id = $1
-		ORDER BY created_at DESC;`,
-		userID,
+		fmt.Sprintf(
+			`SELECT id, user_id, status, expression, result, error,
+			created_at, updated_at, owner, owner_lease_until
+			FROM expressions
+			WHERE %s
+			ORDER BY %s %s, id %s
+			LIMIT $%d;`,
+			where, column, order, order, len(args),
+		),
+		args...,
 	)
 
 	if err != nil {
@@ -138,15 +474,74 @@ func (er *ExpressionRepositoryImpl) GetForUser(
 	return exprs, nil
 }
 
-func (er *ExpressionRepositoryImpl) Unprocessed() ([]Expression, error) {
+// where собирает условие фильтра с нумерованными параметрами.
+func (f ExpressionFilter) where(userID uint64) (string, []any) {
+	conds := []string{"user_id = $1"}
+	args := []any{userID}
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, 0, len(f.Statuses))
+		for _, status := range f.Statuses {
+			statuses = append(statuses, string(status))
+		}
+
+		add("status = ANY($%d::expression_status[])", statuses)
+	}
+
+	if f.CreatedAfter != nil {
+		add("created_at >= $%d", *f.CreatedAfter)
+	}
+
+	if f.CreatedBefore != nil {
+		add("created_at < $%d", *f.CreatedBefore)
+	}
+
+	if f.Contains != "" {
+		add(`expression ILIKE '%%' || $%d || '%%'`, likeEscaper.Replace(f.Contains))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// likeEscaper экранирует спецсимволы шаблона LIKE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Claim takes up to limit unfinished expressions that nobody evaluates:
+// their owner is gone or has not renewed its lease in time. Expressions
+// being claimed by others right now are skipped.
+func (er *ExpressionRepositoryImpl) Claim(
+	owner string,
+	leaseUntil time.Time,
+	limit int,
+) ([]Expression, error) {
 	var exprs []Expression
 	err := pgxscan.Select(
 		context.Background(),
 		er.db,
 		&exprs,
-		`SELECT id, user_id, status, expression, result, created_at, updated_at
-		FROM expressions
-		WHERE status IN ('new', 'processing');`,
+		`WITH orphaned AS (
+			SELECT id
+			FROM expressions
+			WHERE status IN ('new', 'processing')
+			AND (owner IS NULL OR owner_lease_until < now())
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE expressions e
+		SET owner = $1, owner_lease_until = $2
+		FROM orphaned
+		WHERE e.id = orphaned.id
+		RETURNING e.id, e.user_id, e.status, e.expression, e.result, e.error,
+		e.created_at, e.updated_at, e.owner, e.owner_lease_until;`,
+		owner,
+		leaseUntil,
+		limit,
 	)
 
 	if err != nil {
@@ -155,3 +550,44 @@ func (er *ExpressionRepositoryImpl) Unprocessed() ([]Expression, error) {
 
 	return exprs, nil
 }
+
+// RenewOwnership extends the lease on the unfinished expressions
+// of the owner and returns their ids. An expression missing from
+// the result was claimed by someone else after the lease expired.
+func (er *ExpressionRepositoryImpl) RenewOwnership(
+	owner string,
+	leaseUntil time.Time,
+) ([]uint64, error) {
+	var ids []uint64
+	err := pgxscan.Select(
+		context.Background(),
+		er.db,
+		&ids,
+		`UPDATE expressions
+		SET owner_lease_until = $2
+		WHERE owner = $1 AND status IN ('new', 'processing')
+		RETURNING id;`,
+		owner,
+		leaseUntil,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ReleaseOwnership gives up the unfinished expressions of the owner,
+// so that others can claim them without waiting for the lease to expire.
+func (er *ExpressionRepositoryImpl) ReleaseOwnership(owner string) error {
+	_, err := er.db.Exec(
+		context.Background(),
+		`UPDATE expressions
+		SET owner = NULL, owner_lease_until = NULL
+		WHERE owner = $1 AND status IN ('new', 'processing');`,
+		owner,
+	)
+
+	return err
+}
@@ -1,6 +1,9 @@
 package repo_test
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,8 +12,8 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
-func createTestUser() (repo.User, error) {
-	user, err := repo.NewUserRepository().Create(testUser())
+func createTestUser(db storage.Connection) (repo.User, error) {
+	user, err := repo.NewUserRepository(db).Create(testUser())
 	if err != nil {
 		return repo.User{}, err
 	}
@@ -19,9 +22,9 @@ func createTestUser() (repo.User, error) {
 }
 
 func TestExpressionRepository_Create(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	user, err := createTestUser()
+	user, err := createTestUser(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,7 +35,7 @@ func TestExpressionRepository_Create(t *testing.T) {
 	}
 
 	now := time.Now().Add(-time.Second * 10)
-	er := repo.NewExpressionRepository()
+	er := repo.NewExpressionRepository(db, testOrigin)
 
 	createdExpr, err := er.Create(expr)
 	if err != nil {
@@ -73,14 +76,109 @@ func TestExpressionRepository_Create(t *testing.T) {
 	}
 }
 
+func TestExpressionRepository_LongExpression(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Уведомление об изменении не должно упереться в предел pg_notify
+	long := strings.Repeat("1+", 5000) + "1"
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	created, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: long,
+		Owner:      stringPtr(testOrigin),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created.Status = repo.ExpressionSucceed
+	created.Result = float64Ptr(5001)
+
+	updated, err := er.Update(created, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Expression != long {
+		t.Error("long expression was not saved as is")
+	}
+}
+
+func TestExpressionRepository_CreateMany(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	created, err := er.CreateMany([]repo.Expression{
+		{UserID: user.ID, Expression: "1+1"},
+		{UserID: user.ID, Expression: "2*2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != 2 ||
+		created[0].Expression != "1+1" || created[1].Expression != "2*2" {
+		t.Fatalf("got expressions %+v, want them in order", created)
+	}
+
+	for _, expr := range created {
+		stored, err := er.Get(expr.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stored.Status != repo.ExpressionNew {
+			t.Errorf("got status %v, want %v", stored.Status, repo.ExpressionNew)
+		}
+	}
+
+	// Одно неудачное выражение отменяет весь пакет
+	_, err = er.CreateMany([]repo.Expression{
+		{UserID: user.ID, Expression: "3+3"},
+		{UserID: 0, Expression: "4+4"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an unknown user")
+	}
+
+	page, err := er.ListForUser(repo.ExpressionListQuery{
+		UserID: user.ID,
+		Sort:   repo.SortCreatedDesc,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 2 {
+		t.Errorf("got %d expressions, want 2", page.Total)
+	}
+}
+
 func float64Ptr(v float64) *float64 {
 	return &v
 }
 
+func stringPtr(v string) *string {
+	return &v
+}
+
 func TestExpressionRepository_Update(t *testing.T) { //nolint:gocognit
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	user, err := createTestUser()
+	user, err := createTestUser(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -88,13 +186,7 @@ func TestExpressionRepository_Update(t *testing.T) { //nolint:gocognit
 	expr := repo.Expression{
 		UserID:     user.ID,
 		Expression: "2+4/2",
-	}
-
-	er := repo.NewExpressionRepository()
-
-	expr, err = er.Create(expr)
-	if err != nil {
-		t.Fatal()
+		Owner:      stringPtr(testOrigin),
 	}
 
 	cases := []struct {
@@ -102,52 +194,64 @@ func TestExpressionRepository_Update(t *testing.T) { //nolint:gocognit
 	}{
 		{
 			repo.Expression{
-				ID:     expr.ID,
 				Status: repo.ExpressionProcessing,
 			},
 		},
 		{
 			repo.Expression{
-				ID:     expr.ID,
 				UserID: 123,
 				Status: repo.ExpressionAborted,
 			},
 		},
 		{
 			repo.Expression{
-				ID:     expr.ID,
 				Status: repo.ExpressionSucceed,
 				Result: float64Ptr(4),
 			},
 		},
+		{
+			repo.Expression{
+				Status: repo.ExpressionFailed,
+				Error:  stringPtr("division by zero"),
+			},
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(string(c.expr.Status), func(t *testing.T) {
 			err = storage.WithTransaction(
 				t.Context(),
-				storage.Conn(),
+				db,
 				func(tx pgx.Tx) error {
-					er = repo.NewExpressionRepositoryFromTx(tx)
+					er := repo.NewExpressionRepository(tx, testOrigin)
 
-					updated, err := er.Update(c.expr)
+					// Завершенное выражение больше не обновляется,
+					// поэтому каждому случаю нужно свое
+					created, err := er.Create(expr)
 					if err != nil {
 						return err
 					}
 
-					if updated.ID != expr.ID {
+					c.expr.ID = created.ID
+
+					updated, err := er.Update(c.expr, nil)
+					if err != nil {
+						return err
+					}
+
+					if updated.ID != created.ID {
 						t.Errorf(
 							"updated.ID = %v, want %v",
 							updated.ID,
-							expr.ID,
+							created.ID,
 						)
 					}
 
 					if updated.UserID != expr.UserID {
 						t.Errorf(
 							"updated.UserID = %v, want %v",
-							updated.ID,
-							expr.ID,
+							updated.UserID,
+							expr.UserID,
 						)
 					}
 
@@ -167,6 +271,14 @@ func TestExpressionRepository_Update(t *testing.T) { //nolint:gocognit
 						)
 					}
 
+					if !reflect.DeepEqual(updated.Error, c.expr.Error) {
+						t.Errorf(
+							"updated.Error = %v, want %v",
+							updated.Error,
+							c.expr.Error,
+						)
+					}
+
 					return nil
 				},
 			)
@@ -178,10 +290,54 @@ func TestExpressionRepository_Update(t *testing.T) { //nolint:gocognit
 	}
 }
 
+func TestExpressionRepository_UpdateCanceled(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "2+2",
+		Owner:      stringPtr(testOrigin),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = er.Cancel(expr.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Вычисление закончилось уже после отмены
+	_, err = er.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionSucceed,
+		Result: float64Ptr(4),
+	}, nil)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v, want %v", err, pgx.ErrNoRows)
+	}
+
+	got, err := er.Get(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != repo.ExpressionCanceled || got.Result != nil {
+		t.Errorf("got expression %+v, want a canceled one", got)
+	}
+}
+
 func TestExpressionRepository_Get(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	user, err := createTestUser()
+	user, err := createTestUser(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -190,7 +346,7 @@ func TestExpressionRepository_Get(t *testing.T) {
 		UserID:     user.ID,
 		Expression: "2+4/2",
 	}
-	er := repo.NewExpressionRepository()
+	er := repo.NewExpressionRepository(db, testOrigin)
 
 	expr, err = er.Create(expr)
 	if err != nil {
@@ -208,10 +364,10 @@ func TestExpressionRepository_Get(t *testing.T) {
 	}
 }
 
-func TestExpressionRepository_GetForUser(t *testing.T) {
-	storage.TestWithTransaction(t)
+func TestExpressionRepository_ListForUserOwnOnly(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	u1, err := ur.Create(testUser())
 	if err != nil {
@@ -226,7 +382,7 @@ func TestExpressionRepository_GetForUser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	er := repo.NewExpressionRepository()
+	er := repo.NewExpressionRepository(db, testOrigin)
 
 	expr1, err := er.Create(repo.Expression{
 		UserID:     u1.ID,
@@ -244,12 +400,17 @@ func TestExpressionRepository_GetForUser(t *testing.T) {
 		t.Fatal()
 	}
 
-	res, err := er.GetForUser(u1.ID)
+	page, err := er.ListForUser(repo.ExpressionListQuery{
+		UserID: u1.ID,
+		Sort:   repo.SortCreatedDesc,
+		Limit:  10,
+	})
 	if err != nil {
 		t.Errorf("error while getting expressions for user: %v", err)
 		return
 	}
 
+	res := page.Expressions
 	if len(res) != 1 {
 		t.Errorf("len(res) = %v, want %v", len(res), 1)
 	}
@@ -258,3 +419,271 @@ func TestExpressionRepository_GetForUser(t *testing.T) {
 		t.Errorf("res = %v, want %v", res, expr1)
 	}
 }
+
+func TestExpressionRepository_Cancel(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "2+4/2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, err := er.Cancel(expr.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if canceled.Status != repo.ExpressionCanceled {
+		t.Errorf("got status %q, want %q",
+			canceled.Status, repo.ExpressionCanceled)
+	}
+
+	_, err = er.Cancel(expr.ID, nil)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v for a finished expression, want %v",
+			err, pgx.ErrNoRows)
+	}
+}
+
+func TestExpressionRepository_Ownership(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+	owner := "first"
+	alive := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+
+	create := func(owner *string, leaseUntil *time.Time) repo.Expression {
+		expr, err := er.Create(repo.Expression{
+			UserID:          user.ID,
+			Expression:      "2+2",
+			Owner:           owner,
+			OwnerLeaseUntil: leaseUntil,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return expr
+	}
+
+	owned := create(&owner, &alive)
+	orphaned := create(nil, nil)
+	abandoned := create(&owner, &expired)
+
+	claimed, err := er.Claim("second", alive, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[uint64]bool)
+	for _, expr := range claimed {
+		got[expr.ID] = true
+	}
+
+	if got[owned.ID] || !got[orphaned.ID] || !got[abandoned.ID] {
+		t.Errorf("got claimed %v, want %d and %d",
+			got, orphaned.ID, abandoned.ID)
+	}
+
+	renewed, err := er.RenewOwnership(owner, alive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Просроченное выражение уже забрал другой оркестратор
+	if !reflect.DeepEqual(renewed, []uint64{owned.ID}) {
+		t.Errorf("renewed %v, want only %d", renewed, owned.ID)
+	}
+
+	err = er.ReleaseOwnership("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := er.Get(orphaned.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if released.Owner != nil {
+		t.Errorf("got owner %q of a released expression", *released.Owner)
+	}
+}
+
+func TestExpressionRepository_UpdateByFormerOwner(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	former := repo.NewExpressionRepository(db, "former")
+	current := repo.NewExpressionRepository(db, testOrigin)
+
+	// Владение прежнего оркестратора истекло
+	expired := time.Now().Add(-time.Hour)
+
+	expr, err := former.Create(repo.Expression{
+		UserID:          user.ID,
+		Expression:      "2+2",
+		Owner:           stringPtr("former"),
+		OwnerLeaseUntil: &expired,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := current.Claim(testOrigin, time.Now().Add(time.Hour), 100)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("got claimed %v and error %v, want the expression",
+			claimed, err)
+	}
+
+	// Прежний владелец не должен затереть результат нового
+	_, err = former.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionSucceed,
+		Result: float64Ptr(5),
+	}, nil)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v, want %v", err, pgx.ErrNoRows)
+	}
+
+	_, err = current.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionSucceed,
+		Result: float64Ptr(4),
+	}, nil)
+	if err != nil {
+		t.Errorf("owner failed to update the expression: %v", err)
+	}
+}
+
+func TestExpressionRepository_ListForUser(t *testing.T) { //nolint:gocognit
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	var created []repo.Expression
+
+	for _, text := range []string{"1+1", "2+2", "3*3", "4+4", "5%_"} {
+		expr, err := er.Create(repo.Expression{
+			UserID:     user.ID,
+			Expression: text,
+			Owner:      stringPtr(testOrigin),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		created = append(created, expr)
+	}
+
+	// В транзакции у всех выражений одно время создания,
+	// порядок между ними держится на id
+	var ids []uint64
+
+	query := repo.ExpressionListQuery{
+		UserID: user.ID,
+		Sort:   repo.SortCreatedDesc,
+		Limit:  2,
+	}
+
+	for {
+		page, err := er.ListForUser(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != len(created) {
+			t.Errorf("got total %d, want %d", page.Total, len(created))
+		}
+
+		for _, expr := range page.Expressions {
+			ids = append(ids, expr.ID)
+		}
+
+		if page.Next == nil {
+			break
+		}
+
+		cursor, err := repo.DecodeExpressionCursor(page.Next.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query.After = &cursor
+	}
+
+	want := make([]uint64, 0, len(created))
+	for i := len(created) - 1; i >= 0; i-- {
+		want = append(want, created[i].ID)
+	}
+
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("got ids %v, want %v", ids, want)
+	}
+
+	_, err = er.Update(repo.Expression{
+		ID:     created[2].ID,
+		Status: repo.ExpressionSucceed,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+
+	filters := []struct {
+		name   string
+		filter repo.ExpressionFilter
+		want   int
+	}{
+		{"contains", repo.ExpressionFilter{Contains: "+"}, 3},
+		{"like wildcard", repo.ExpressionFilter{Contains: "%"}, 1},
+		{"status", repo.ExpressionFilter{
+			Statuses: []repo.ExpressionStatus{repo.ExpressionSucceed},
+		}, 1},
+		{"created after", repo.ExpressionFilter{CreatedAfter: &hourAgo}, 5},
+		{"created before", repo.ExpressionFilter{CreatedBefore: &hourAgo}, 0},
+	}
+
+	for _, f := range filters {
+		page, err := er.ListForUser(repo.ExpressionListQuery{
+			UserID: user.ID,
+			Filter: f.filter,
+			Sort:   repo.SortUpdatedAsc,
+			Limit:  10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != f.want || len(page.Expressions) != f.want {
+			t.Errorf("%s: got %d of total %d, want %d",
+				f.name, len(page.Expressions), page.Total, f.want)
+		}
+	}
+}
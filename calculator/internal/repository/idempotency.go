@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// IdempotencyKey remembers the response to a request, so that a retry
+// with the same key gets it again instead of repeating the request.
+// StatusCode and Response are empty while the request is in progress,
+// and LockedUntil is empty once it is done.
+type IdempotencyKey struct {
+	UserID      uint64     `json:"user_id"`
+	Key         string     `json:"key"`
+	Fingerprint string     `json:"fingerprint"` // Хеш запроса
+	StatusCode  *int       `json:"status_code"`
+	Response    []byte     `json:"response"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+type IdempotencyRepository interface {
+	Reserve(
+		key IdempotencyKey,
+		ttl time.Duration,
+		lock time.Duration,
+	) (IdempotencyKey, error)
+	Get(userID uint64, key string) (IdempotencyKey, error)
+	Complete(key IdempotencyKey) (IdempotencyKey, error)
+	Delete(key IdempotencyKey) error
+	DeleteExpired() (int64, error)
+}
+
+type IdempotencyRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewIdempotencyRepository(db storage.Connection) IdempotencyRepository {
+	return &IdempotencyRepositoryImpl{
+		db: db,
+	}
+}
+
+// Reserve claims the key for a new request for the ttl. The request
+// has the lock duration to store its response: after that the key
+// is considered abandoned, e.g. by a crashed process. Expired and
+// abandoned keys are claimed anew. If the key is still in use,
+// pgx.ErrNoRows is returned. The key expires by the database clock,
+// the same one that decides whether it has expired.
+func (ir *IdempotencyRepositoryImpl) Reserve(
+	key IdempotencyKey,
+	ttl time.Duration,
+	lock time.Duration,
+) (IdempotencyKey, error) {
+	reserved := IdempotencyKey{}
+	err := pgxscan.Get(
+		context.Background(),
+		ir.db,
+		&reserved,
+		`INSERT INTO idempotency_keys
+		(user_id, key, fingerprint, expires_at, locked_until)
+		VALUES (
+			$1, $2, $3,
+			now() + make_interval(secs => $4),
+			now() + make_interval(secs => $5)
+		)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			response = NULL,
+			created_at = now(),
+			expires_at = EXCLUDED.expires_at,
+			locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at <= now()
+			OR (idempotency_keys.status_code IS NULL
+				AND idempotency_keys.locked_until <= now())
+		RETURNING user_id, key, fingerprint, status_code, response,
+		created_at, expires_at, locked_until;`,
+		key.UserID,
+		key.Key,
+		key.Fingerprint,
+		ttl.Seconds(),
+		lock.Seconds(),
+	)
+
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+
+	return reserved, nil
+}
+
+func (ir *IdempotencyRepositoryImpl) Get(
+	userID uint64,
+	key string,
+) (IdempotencyKey, error) {
+	found := IdempotencyKey{}
+	err := pgxscan.Get(
+		context.Background(),
+		ir.db,
+		&found,
+		`SELECT user_id, key, fingerprint, status_code, response,
+		created_at, expires_at, locked_until
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2;`,
+		userID,
+		key,
+	)
+
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+
+	return found, nil
+}
+
+// Complete stores the response to the request that reserved the key.
+// If the key was claimed by another request since, pgx.ErrNoRows
+// is returned.
+func (ir *IdempotencyRepositoryImpl) Complete(
+	key IdempotencyKey,
+) (IdempotencyKey, error) {
+	completed := IdempotencyKey{}
+	err := pgxscan.Get(
+		context.Background(),
+		ir.db,
+		&completed,
+		`UPDATE idempotency_keys
+		SET status_code = $3, response = $4, locked_until = NULL
+		WHERE user_id = $1 AND key = $2 AND locked_until = $5
+		RETURNING user_id, key, fingerprint, status_code, response,
+		created_at, expires_at, locked_until;`,
+		key.UserID,
+		key.Key,
+		key.StatusCode,
+		key.Response,
+		key.LockedUntil,
+	)
+
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+
+	return completed, nil
+}
+
+// Delete frees the key, e.g. when the request failed and may be retried.
+// A key claimed by another request since is left alone.
+func (ir *IdempotencyRepositoryImpl) Delete(key IdempotencyKey) error {
+	_, err := ir.db.Exec(
+		context.Background(),
+		`DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND locked_until = $3;`,
+		key.UserID,
+		key.Key,
+		key.LockedUntil,
+	)
+
+	return err
+}
+
+// DeleteExpired removes expired keys and returns how many were removed.
+func (ir *IdempotencyRepositoryImpl) DeleteExpired() (int64, error) {
+	tag, err := ir.db.Exec(
+		context.Background(),
+		`DELETE FROM idempotency_keys WHERE expires_at <= now();`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,153 @@
+package repo_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestIdempotencyRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ir := repo.NewIdempotencyRepository(db)
+
+	key := repo.IdempotencyKey{
+		UserID:      user.ID,
+		Key:         "retry-me",
+		Fingerprint: "fingerprint",
+	}
+
+	reserved, err := ir.Reserve(key, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reserved.StatusCode != nil || reserved.LockedUntil == nil {
+		t.Errorf("got a response for a new key: %+v", reserved)
+	}
+
+	_, err = ir.Reserve(key, time.Hour, time.Minute)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v for a key in use, want %v",
+			err, pgx.ErrNoRows)
+	}
+
+	code := 200
+	reserved.StatusCode = &code
+	reserved.Response = []byte(`{"id":1}`)
+
+	_, err = ir.Complete(reserved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ir.Get(user.ID, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *got.StatusCode != code || string(got.Response) != `{"id":1}` ||
+		got.LockedUntil != nil {
+		t.Errorf("got stored response %+v", got)
+	}
+
+	// Ключ с ответом не освобождается, даже когда его запрос
+	// давно бы считался брошенным
+	_, err = ir.Reserve(key, time.Hour, -time.Hour)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v for a completed key, want %v",
+			err, pgx.ErrNoRows)
+	}
+
+	// Ключ запроса, ответ на который не сохраняется, освобождается
+	key.Key = "release-me"
+
+	inFlight, err := ir.Reserve(key, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ir.Delete(inFlight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ir.Reserve(key, -time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to reserve a released key: %v", err)
+	}
+
+	// Просроченный ключ можно занять заново
+	_, err = ir.Reserve(key, time.Hour, time.Minute)
+	if err != nil {
+		t.Errorf("failed to reserve an expired key: %v", err)
+	}
+
+	_, err = ir.Complete(repo.IdempotencyKey{
+		UserID:     user.ID,
+		Key:        "stale",
+		StatusCode: &code,
+	})
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v for an unknown key", err)
+	}
+}
+
+func TestIdempotencyRepositoryTakesOverAbandonedKey(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ir := repo.NewIdempotencyRepository(db)
+
+	key := repo.IdempotencyKey{
+		UserID:      user.ID,
+		Key:         "crashed",
+		Fingerprint: "fingerprint",
+	}
+
+	// Процесс занял ключ и упал, не успев ответить
+	abandoned, err := ir.Reserve(key, time.Hour, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retried, err := ir.Reserve(key, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to take over an abandoned key: %v", err)
+	}
+
+	// Брошенный запрос не затрет ответ на повтор и не освободит ключ
+	code := 201
+	abandoned.StatusCode = &code
+
+	_, err = ir.Complete(abandoned)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("got error %v completing a taken over key, want %v",
+			err, pgx.ErrNoRows)
+	}
+
+	err = ir.Delete(abandoned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retried.StatusCode = &code
+
+	_, err = ir.Complete(retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"testing"
 
 	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// testOrigin подписывает уведомления об изменениях выражений в тестах.
+const testOrigin = "test"
+
+// testDB — база, которую тестам пакета поднимает TestMain.
+var testDB *pgxpool.Pool
+
 func TestMain(m *testing.M) {
 	code := storage.RunTestsWithTempDB(
-		func() int {
-			return storage.RunTestsWithMigratedDB(m.Run)
+		func(db *pgxpool.Pool) int {
+			testDB = db
+			return storage.RunTestsWithMigratedDB(db, m.Run)
 		},
 	)
 
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// Mismatch is an agent answer that the other agents did not agree with.
+// Accepted fields are empty if the agents failed to reach a quorum.
+type Mismatch struct {
+	ID             uint64    `json:"id"`
+	TaskID         uint64    `json:"task_id"`
+	ExpressionID   uint64    `json:"expression_id"`
+	AgentID        string    `json:"agent_id"`
+	Operator       string    `json:"operator"`
+	Arg1           float64   `json:"arg1"`
+	Arg2           float64   `json:"arg2"`
+	Result         *float64  `json:"result"`
+	Error          *string   `json:"error"`
+	AcceptedResult *float64  `json:"accepted_result"`
+	AcceptedError  *string   `json:"accepted_error"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type MismatchRepository interface {
+	Create(mismatch Mismatch) (Mismatch, error)
+	Recent(limit int) ([]Mismatch, error)
+}
+
+type MismatchRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewMismatchRepository(db storage.Connection) MismatchRepository {
+	return &MismatchRepositoryImpl{
+		db: db,
+	}
+}
+
+func (mr *MismatchRepositoryImpl) Create(
+	mismatch Mismatch,
+) (Mismatch, error) {
+	created := Mismatch{}
+	err := pgxscan.Get(
+		context.Background(),
+		mr.db,
+		&created,
+		`INSERT INTO result_mismatches
+		(task_id, expression_id, agent_id, operator, arg1, arg2,
+		result, error, accepted_result, accepted_error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, task_id, expression_id, agent_id, operator, arg1, arg2,
+		result, error, accepted_result, accepted_error, created_at;`,
+		mismatch.TaskID,
+		mismatch.ExpressionID,
+		mismatch.AgentID,
+		mismatch.Operator,
+		mismatch.Arg1,
+		mismatch.Arg2,
+		mismatch.Result,
+		mismatch.Error,
+		mismatch.AcceptedResult,
+		mismatch.AcceptedError,
+	)
+
+	if err != nil {
+		return Mismatch{}, err
+	}
+
+	return created, nil
+}
+
+// Recent returns the latest mismatches, newest first.
+func (mr *MismatchRepositoryImpl) Recent(limit int) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	err := pgxscan.Select(
+		context.Background(),
+		mr.db,
+		&mismatches,
+		`SELECT id, task_id, expression_id, agent_id, operator, arg1, arg2,
+		result, error, accepted_result, accepted_error, created_at
+		FROM result_mismatches
+		ORDER BY id DESC
+		LIMIT $1;`,
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mismatches, nil
+}
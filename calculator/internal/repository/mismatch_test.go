@@ -0,0 +1,75 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+)
+
+func TestMismatchRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "6/3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr := repo.NewMismatchRepository(db)
+
+	wrong, accepted := 3.0, 2.0
+
+	first, err := mr.Create(repo.Mismatch{
+		TaskID:         1,
+		ExpressionID:   expr.ID,
+		AgentID:        "liar",
+		Operator:       "/",
+		Arg1:           6,
+		Arg2:           3,
+		Result:         &wrong,
+		AcceptedResult: &accepted,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *first.Result != wrong || *first.AcceptedResult != accepted {
+		t.Errorf("got mismatch %+v", first)
+	}
+
+	second, err := mr.Create(repo.Mismatch{
+		TaskID:       1,
+		ExpressionID: expr.ID,
+		AgentID:      "other",
+		Operator:     "/",
+		Arg1:         6,
+		Arg2:         3,
+		Error:        stringPtr("division by zero"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent, err := mr.Recent(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recent) != 2 || recent[0].ID != second.ID {
+		t.Errorf("got mismatches %+v, want the newest first", recent)
+	}
+
+	if recent[0].AcceptedResult != nil || recent[0].AcceptedError != nil {
+		t.Errorf("got accepted answer for a dispute: %+v", recent[0])
+	}
+}
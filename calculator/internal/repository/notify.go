@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Notifier sends notifications to the instances sharing the database
+// and listens to theirs.
+type Notifier interface {
+	Notify(channel, payload string) error
+	// Listen blocks until the context is canceled, see storage.Listen.
+	Listen(
+		ctx context.Context,
+		channel string,
+		onListen func(),
+		handle func(payload string),
+	)
+}
+
+type NotifierImpl struct {
+	pool *pgxpool.Pool // Слушать можно только на своем соединении пула
+}
+
+func NewNotifier(pool *pgxpool.Pool) Notifier {
+	return &NotifierImpl{
+		pool: pool,
+	}
+}
+
+func (n *NotifierImpl) Notify(channel, payload string) error {
+	return storage.Notify(context.Background(), n.pool, channel, payload)
+}
+
+func (n *NotifierImpl) Listen(
+	ctx context.Context,
+	channel string,
+	onListen func(),
+	handle func(payload string),
+) {
+	storage.Listen(ctx, n.pool, channel, onListen, handle)
+}
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// EvaluationState is a persisted snapshot of an expression evaluation,
+// used to resume it after a restart.
+type EvaluationState struct {
+	ExpressionID uint64     `json:"expression_id"`
+	State        calc.State `json:"state"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type StateRepository interface {
+	Save(state EvaluationState) (EvaluationState, error)
+	Get(expressionID uint64) (EvaluationState, error)
+}
+
+type StateRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewStateRepository(db storage.Connection) StateRepository {
+	return &StateRepositoryImpl{
+		db: db,
+	}
+}
+
+// Save creates the snapshot or replaces the existing one.
+func (sr *StateRepositoryImpl) Save(
+	state EvaluationState,
+) (EvaluationState, error) {
+	err := pgxscan.Get(
+		context.Background(),
+		sr.db,
+		&state,
+		`INSERT INTO evaluation_states (expression_id, state)
+		VALUES ($1, $2)
+		ON CONFLICT (expression_id) DO UPDATE SET state = excluded.state
+		RETURNING expression_id, state, updated_at;`,
+		state.ExpressionID,
+		state.State,
+	)
+
+	if err != nil {
+		return EvaluationState{}, err
+	}
+
+	return state, nil
+}
+
+func (sr *StateRepositoryImpl) Get(
+	expressionID uint64,
+) (EvaluationState, error) {
+	state := EvaluationState{}
+	err := pgxscan.Get(
+		context.Background(),
+		sr.db,
+		&state,
+		`SELECT expression_id, state, updated_at
+		FROM evaluation_states
+		WHERE expression_id = $1;`,
+		expressionID,
+	)
+
+	if err != nil {
+		return EvaluationState{}, err
+	}
+
+	return state, nil
+}
@@ -0,0 +1,73 @@
+package repo_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func TestStateRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "(1+2)*3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calcExpr, err := calc.NewExpression(expr.Expression, calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := repo.NewStateRepository(db)
+
+	saved, err := sr.Save(repo.EvaluationState{
+		ExpressionID: expr.ID,
+		State:        calcExpr.Snapshot(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sr.Get(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got.State, saved.State) {
+		t.Errorf("got state %+v, want %+v", got.State, saved.State)
+	}
+
+	// Повторное сохранение заменяет снимок
+	result := 9.0
+
+	_, err = sr.Save(repo.EvaluationState{
+		ExpressionID: expr.ID,
+		State:        calc.State{Result: &result},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = sr.Get(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.State.Result == nil || *got.State.Result != result {
+		t.Errorf("got result %v, want %v", got.State.Result, result)
+	}
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// StepExecutor tells who evaluated a step of an expression.
+type StepExecutor string
+
+const (
+	StepAgent StepExecutor = "agent"
+	StepLocal StepExecutor = "local"
+)
+
+type Step struct {
+	ID           uint64       `json:"id"`
+	ExpressionID uint64       `json:"expression_id"`
+	Operator     string       `json:"operator"`
+	Arg1         float64      `json:"arg1"`
+	Arg2         float64      `json:"arg2"`
+	Result       float64      `json:"result"`
+	Executor     StepExecutor `json:"executor"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+type StepRepository interface {
+	Create(step Step) (Step, error)
+	GetForExpression(expressionID uint64) ([]Step, error)
+}
+
+type StepRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewStepRepository(db storage.Connection) StepRepository {
+	return &StepRepositoryImpl{
+		db: db,
+	}
+}
+
+func (sr *StepRepositoryImpl) Create(step Step) (Step, error) {
+	err := pgxscan.Get(
+		context.Background(),
+		sr.db,
+		&step,
+		`INSERT INTO expression_steps
+		(expression_id, operator, arg1, arg2, result, executor)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, expression_id, operator, arg1, arg2, result, executor,
+		created_at;`,
+		step.ExpressionID,
+		step.Operator,
+		step.Arg1,
+		step.Arg2,
+		step.Result,
+		step.Executor,
+	)
+
+	if err != nil {
+		return Step{}, err
+	}
+
+	return step, nil
+}
+
+func (sr *StepRepositoryImpl) GetForExpression(
+	expressionID uint64,
+) ([]Step, error) {
+	var steps []Step
+	err := pgxscan.Select(
+		context.Background(),
+		sr.db,
+		&steps,
+		`SELECT id, expression_id, operator, arg1, arg2, result, executor,
+		created_at
+		FROM expression_steps
+		WHERE expression_id = $1
+		ORDER BY id;`,
+		expressionID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
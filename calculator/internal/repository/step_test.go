@@ -0,0 +1,76 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+)
+
+func TestStepRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "1+2*3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := repo.NewStepRepository(db)
+
+	steps := []repo.Step{
+		{
+			ExpressionID: expr.ID,
+			Operator:     "*",
+			Arg1:         2,
+			Arg2:         3,
+			Result:       6,
+			Executor:     repo.StepAgent,
+		},
+		{
+			ExpressionID: expr.ID,
+			Operator:     "+",
+			Arg1:         1,
+			Arg2:         6,
+			Result:       7,
+			Executor:     repo.StepLocal,
+		},
+	}
+
+	for i, step := range steps {
+		created, err := sr.Create(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created.ID == 0 {
+			t.Error("step ID is zero")
+		}
+
+		steps[i] = created
+	}
+
+	got, err := sr.GetForExpression(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(steps) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(steps))
+	}
+
+	for i := range got {
+		if got[i] != steps[i] {
+			t.Errorf("got step %+v, want %+v", got[i], steps[i])
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+type TaskStatus string
+
+const (
+	TaskQueued     TaskStatus = "queued"
+	TaskProcessing TaskStatus = "processing"
+	TaskCompleted  TaskStatus = "completed"
+	TaskCanceled   TaskStatus = "canceled"
+)
+
+// Task is a persisted task of an expression evaluation.
+// Queued and processing tasks are resumed after a restart.
+type Task struct {
+	ID           uint64     `json:"id"`
+	ExpressionID uint64     `json:"expression_id"`
+	NodeID       int        `json:"node_id"`
+	Operator     string     `json:"operator"`
+	Arg1         float64    `json:"arg1"`
+	Arg2         float64    `json:"arg2"`
+	Status       TaskStatus `json:"status"`
+	Attempts     int        `json:"attempts"` // Число неудачных попыток
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type TaskRepository interface {
+	Create(tasks []Task) ([]Task, error)
+	UpdateStatus(id uint64, status TaskStatus) (Task, error)
+	Retry(id uint64, attempts int) (Task, error)
+	CancelForExpression(expressionID uint64) ([]Task, error)
+	Get(id uint64) (Task, error)
+	Outstanding(expressionIDs []uint64) ([]Task, error)
+	ReserveIDRange() (uint64, error)
+}
+
+type TaskRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewTaskRepository(db storage.Connection) TaskRepository {
+	return &TaskRepositoryImpl{
+		db: db,
+	}
+}
+
+// Create inserts all tasks with a single statement.
+func (tr *TaskRepositoryImpl) Create(tasks []Task) ([]Task, error) {
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	var (
+		ids           = make([]uint64, 0, len(tasks))
+		expressionIDs = make([]uint64, 0, len(tasks))
+		nodeIDs       = make([]int, 0, len(tasks))
+		operators     = make([]string, 0, len(tasks))
+		args1         = make([]float64, 0, len(tasks))
+		args2         = make([]float64, 0, len(tasks))
+		statuses      = make([]string, 0, len(tasks))
+	)
+
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+		expressionIDs = append(expressionIDs, task.ExpressionID)
+		nodeIDs = append(nodeIDs, task.NodeID)
+		operators = append(operators, task.Operator)
+		args1 = append(args1, task.Arg1)
+		args2 = append(args2, task.Arg2)
+		statuses = append(statuses, string(task.Status))
+	}
+
+	var created []Task
+	err := pgxscan.Select(
+		context.Background(),
+		tr.db,
+		&created,
+		`INSERT INTO tasks
+		(id, expression_id, node_id, operator, arg1, arg2, status)
+		SELECT id, expression_id, node_id, operator, arg1, arg2,
+		status::task_status
+		FROM unnest(
+			$1::BIGINT[], $2::INTEGER[], $3::INTEGER[], $4::TEXT[],
+			$5::DOUBLE PRECISION[], $6::DOUBLE PRECISION[], $7::TEXT[]
+		) AS t (id, expression_id, node_id, operator, arg1, arg2, status)
+		RETURNING id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at;`,
+		ids,
+		expressionIDs,
+		nodeIDs,
+		operators,
+		args1,
+		args2,
+		statuses,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (tr *TaskRepositoryImpl) UpdateStatus(
+	id uint64,
+	status TaskStatus,
+) (Task, error) {
+	task := Task{}
+	err := pgxscan.Get(
+		context.Background(),
+		tr.db,
+		&task,
+		`UPDATE tasks
+		SET status = $2
+		WHERE id = $1
+		RETURNING id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at;`,
+		id,
+		status,
+	)
+
+	if err != nil {
+		return Task{}, err
+	}
+
+	return task, nil
+}
+
+// Retry puts the task back to the queue and records
+// how many attempts have failed so far.
+func (tr *TaskRepositoryImpl) Retry(id uint64, attempts int) (Task, error) {
+	task := Task{}
+	err := pgxscan.Get(
+		context.Background(),
+		tr.db,
+		&task,
+		`UPDATE tasks
+		SET status = 'queued', attempts = $2
+		WHERE id = $1
+		RETURNING id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at;`,
+		id,
+		attempts,
+	)
+
+	if err != nil {
+		return Task{}, err
+	}
+
+	return task, nil
+}
+
+// CancelForExpression cancels queued and processing tasks
+// of the expression and returns them.
+func (tr *TaskRepositoryImpl) CancelForExpression(
+	expressionID uint64,
+) ([]Task, error) {
+	var tasks []Task
+	err := pgxscan.Select(
+		context.Background(),
+		tr.db,
+		&tasks,
+		`UPDATE tasks
+		SET status = 'canceled'
+		WHERE expression_id = $1 AND status IN ('queued', 'processing')
+		RETURNING id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at;`,
+		expressionID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// Get returns the task by id.
+func (tr *TaskRepositoryImpl) Get(id uint64) (Task, error) {
+	task := Task{}
+	err := pgxscan.Get(
+		context.Background(),
+		tr.db,
+		&task,
+		`SELECT id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at
+		FROM tasks
+		WHERE id = $1;`,
+		id,
+	)
+
+	if err != nil {
+		return Task{}, err
+	}
+
+	return task, nil
+}
+
+// Outstanding returns tasks of the expressions
+// that are queued or being processed.
+func (tr *TaskRepositoryImpl) Outstanding(
+	expressionIDs []uint64,
+) ([]Task, error) {
+	var tasks []Task
+	err := pgxscan.Select(
+		context.Background(),
+		tr.db,
+		&tasks,
+		`SELECT id, expression_id, node_id, operator, arg1, arg2, status,
+		attempts, created_at, updated_at
+		FROM tasks
+		WHERE expression_id = ANY($1::INTEGER[])
+		AND status IN ('queued', 'processing')
+		ORDER BY id;`,
+		expressionIDs,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// TaskIDRangeBits is how many low bits of a task id
+// are left to a single range.
+const TaskIDRangeBits = 39
+
+// ReserveIDRange returns the first id of a range of task ids
+// that nobody else uses, so that orchestrators sharing the database
+// can issue ids without asking it every time.
+func (tr *TaskRepositoryImpl) ReserveIDRange() (uint64, error) {
+	var rangeNumber uint64
+	err := pgxscan.Get(
+		context.Background(),
+		tr.db,
+		&rangeNumber,
+		`SELECT nextval('task_id_ranges');`,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return rangeNumber << TaskIDRangeBits, nil
+}
@@ -0,0 +1,128 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+)
+
+func TestTaskRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "(1+2)*(3+4)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := repo.NewTaskRepository(db)
+
+	// Задачи берут id из своего диапазона, как это делает оркестратор
+	base, err := tr.ReserveIDRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := tr.Create([]repo.Task{
+		{
+			ID:           base + 1,
+			ExpressionID: expr.ID,
+			NodeID:       0,
+			Operator:     "+",
+			Arg1:         1,
+			Arg2:         2,
+			Status:       repo.TaskQueued,
+		},
+		{
+			ID:           base + 2,
+			ExpressionID: expr.ID,
+			NodeID:       3,
+			Operator:     "+",
+			Arg1:         3,
+			Arg2:         4,
+			Status:       repo.TaskQueued,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != 2 {
+		t.Fatalf("len(created) = %d, want 2", len(created))
+	}
+
+	if created[1].NodeID != 3 || created[1].Status != repo.TaskQueued {
+		t.Errorf("got task %+v", created[1])
+	}
+
+	_, err = tr.UpdateStatus(base+1, repo.TaskCompleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tr.UpdateStatus(base+2, repo.TaskProcessing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := tr.Retry(base+2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Status != repo.TaskQueued || updated.Attempts != 1 {
+		t.Errorf("got retried task %+v", updated)
+	}
+
+	outstanding, err := tr.Outstanding([]uint64{expr.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outstanding) != 1 || outstanding[0] != updated {
+		t.Errorf("got outstanding tasks %+v, want %+v", outstanding, updated)
+	}
+
+	found, err := tr.Get(base + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != repo.TaskCompleted {
+		t.Errorf("got task %+v, want a completed one", found)
+	}
+
+	canceled, err := tr.CancelForExpression(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(canceled) != 1 || canceled[0].Status != repo.TaskCanceled {
+		t.Errorf("got canceled tasks %+v, want only the queued one", canceled)
+	}
+
+	first, err := tr.ReserveIDRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := tr.ReserveIDRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next-first != 1<<repo.TaskIDRangeBits {
+		t.Errorf("got overlapping id ranges starting at %d and %d",
+			first, next)
+	}
+}
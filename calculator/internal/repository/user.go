@@ -26,9 +26,9 @@ type UserRepositoryImpl struct {
 	db storage.Connection
 }
 
-func NewUserRepository() UserRepository {
+func NewUserRepository(db storage.Connection) UserRepository {
 	return &UserRepositoryImpl{
-		db: storage.Conn(),
+		db: db,
 	}
 }
 
@@ -17,10 +17,10 @@ func testUser() repo.User {
 }
 
 func TestUserRepository_Create(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
 	now := time.Now().Add(-time.Second * 10)
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	userToCreate := testUser()
 
@@ -66,9 +66,9 @@ func TestUserConstraints(t *testing.T) {
 
 	for _, u := range users {
 		t.Run(u.Username, func(t *testing.T) {
-			storage.TestWithTransaction(t)
+			db := storage.TestWithTransaction(t, testDB)
 
-			ur := repo.NewUserRepository()
+			ur := repo.NewUserRepository(db)
 
 			_, err := ur.Create(firstUser)
 			if err != nil {
@@ -90,9 +90,9 @@ func TestUserConstraints(t *testing.T) {
 }
 
 func TestUserRepository_GetByCredentials(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	created, err := ur.Create(testUser())
 	if err != nil {
@@ -111,9 +111,9 @@ func TestUserRepository_GetByCredentials(t *testing.T) {
 }
 
 func TestUserRepository_GetByCredentials2(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	created, err := ur.Create(testUser())
 	if err != nil {
@@ -127,9 +127,9 @@ func TestUserRepository_GetByCredentials2(t *testing.T) {
 }
 
 func TestUserRepository_Get(t *testing.T) {
-	storage.TestWithTransaction(t)
+	db := storage.TestWithTransaction(t, testDB)
 
-	ur := repo.NewUserRepository()
+	ur := repo.NewUserRepository(db)
 
 	created, err := ur.Create(testUser())
 	if err != nil {
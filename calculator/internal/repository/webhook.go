@@ -0,0 +1,289 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// Webhook is a URL the orchestrator notifies when
+// an expression of the user finishes.
+type Webhook struct {
+	ID     uint64 `json:"id"`
+	UserID uint64 `json:"user_id"`
+	URL    string `json:"url"`
+	// Ключ подписи, показывается только при создании
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookRepository interface {
+	Create(webhook Webhook) (Webhook, error)
+	Get(id uint64) (Webhook, error)
+	GetForUser(userID uint64) ([]Webhook, error)
+	Delete(id uint64) error
+}
+
+type WebhookRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewWebhookRepository(db storage.Connection) WebhookRepository {
+	return &WebhookRepositoryImpl{
+		db: db,
+	}
+}
+
+func (wr *WebhookRepositoryImpl) Create(webhook Webhook) (Webhook, error) {
+	created := Webhook{}
+	err := pgxscan.Get(
+		context.Background(),
+		wr.db,
+		&created,
+		`INSERT INTO webhooks (user_id, url, secret)
+		VALUES ($1, $2, $3)
+		RETURNING id, user_id, url, secret, created_at;`,
+		webhook.UserID,
+		webhook.URL,
+		webhook.Secret,
+	)
+
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return created, nil
+}
+
+func (wr *WebhookRepositoryImpl) Get(id uint64) (Webhook, error) {
+	webhook := Webhook{}
+	err := pgxscan.Get(
+		context.Background(),
+		wr.db,
+		&webhook,
+		`SELECT id, user_id, url, secret, created_at
+		FROM webhooks
+		WHERE id = $1;`,
+		id,
+	)
+
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func (wr *WebhookRepositoryImpl) GetForUser(userID uint64) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := pgxscan.Select(
+		context.Background(),
+		wr.db,
+		&webhooks,
+		`SELECT id, user_id, url, secret, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id;`,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook together with its deliveries.
+func (wr *WebhookRepositoryImpl) Delete(id uint64) error {
+	_, err := wr.db.Exec(
+		context.Background(),
+		`DELETE FROM webhooks WHERE id = $1;`,
+		id,
+	)
+
+	return err
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is a notification about a finished expression.
+// Pending deliveries form a queue that survives restarts.
+type WebhookDelivery struct {
+	ID            uint64          `json:"id"`
+	WebhookID     uint64          `json:"webhook_id"`
+	ExpressionID  uint64          `json:"expression_id"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        DeliveryStatus  `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	// Ответ получателя на последнюю попытку
+	LastStatusCode *int      `json:"last_status_code"`
+	LastError      *string   `json:"last_error"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DueDelivery is a delivery claimed for sending
+// along with where to send it.
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string `json:"url"`
+	Secret string `json:"-"`
+}
+
+type WebhookDeliveryRepository interface {
+	Enqueue(
+		userID uint64,
+		expressionID uint64,
+		payload json.RawMessage,
+	) ([]WebhookDelivery, error)
+	Claim(limit int, until time.Time) ([]DueDelivery, error)
+	Update(delivery WebhookDelivery) (WebhookDelivery, error)
+	ForWebhook(webhookID uint64, limit int) ([]WebhookDelivery, error)
+}
+
+type WebhookDeliveryRepositoryImpl struct {
+	db storage.Connection
+}
+
+func NewWebhookDeliveryRepository(
+	db storage.Connection,
+) WebhookDeliveryRepository {
+	return &WebhookDeliveryRepositoryImpl{
+		db: db,
+	}
+}
+
+// Enqueue creates a delivery of the payload to every webhook of the user.
+func (dr *WebhookDeliveryRepositoryImpl) Enqueue(
+	userID uint64,
+	expressionID uint64,
+	payload json.RawMessage,
+) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := pgxscan.Select(
+		context.Background(),
+		dr.db,
+		&deliveries,
+		`INSERT INTO webhook_deliveries (webhook_id, expression_id, payload)
+		SELECT id, $2, $3
+		FROM webhooks
+		WHERE user_id = $1
+		RETURNING id, webhook_id, expression_id, payload, status, attempts,
+		next_attempt_at, last_status_code, last_error, created_at, updated_at;`,
+		userID,
+		expressionID,
+		payload,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Claim takes up to limit pending deliveries that are due and postpones
+// their next attempt until the given time, so that nobody else sends them
+// meanwhile. If the sender dies, the deliveries are retried after that.
+func (dr *WebhookDeliveryRepositoryImpl) Claim(
+	limit int,
+	until time.Time,
+) ([]DueDelivery, error) {
+	var deliveries []DueDelivery
+	err := pgxscan.Select(
+		context.Background(),
+		dr.db,
+		&deliveries,
+		`WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.expression_id, d.payload, d.status,
+		d.attempts, d.next_attempt_at, d.last_status_code, d.last_error,
+		d.created_at, d.updated_at, w.url, w.secret;`,
+		limit,
+		until,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Update records the outcome of a delivery attempt.
+func (dr *WebhookDeliveryRepositoryImpl) Update(
+	delivery WebhookDelivery,
+) (WebhookDelivery, error) {
+	updated := WebhookDelivery{}
+	err := pgxscan.Get(
+		context.Background(),
+		dr.db,
+		&updated,
+		`UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4,
+			last_status_code = $5, last_error = $6
+		WHERE id = $1
+		RETURNING id, webhook_id, expression_id, payload, status, attempts,
+		next_attempt_at, last_status_code, last_error, created_at, updated_at;`,
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastStatusCode,
+		delivery.LastError,
+	)
+
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return updated, nil
+}
+
+// ForWebhook returns the latest deliveries to the webhook, newest first.
+func (dr *WebhookDeliveryRepositoryImpl) ForWebhook(
+	webhookID uint64,
+	limit int,
+) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := pgxscan.Select(
+		context.Background(),
+		dr.db,
+		&deliveries,
+		`SELECT id, webhook_id, expression_id, payload, status, attempts,
+		next_attempt_at, last_status_code, last_error, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2;`,
+		webhookID,
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,216 @@
+package repo_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/repository"
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+)
+
+func TestWebhookRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wr := repo.NewWebhookRepository(db)
+
+	webhook, err := wr.Create(repo.Webhook{
+		UserID: user.ID,
+		URL:    "http://localhost:9000/hook",
+		Secret: strings.Repeat("a", 64),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks, err := wr.GetForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webhooks) != 1 || webhooks[0].URL != webhook.URL {
+		t.Errorf("got webhooks %+v, want %+v", webhooks, webhook)
+	}
+
+	err = wr.Delete(webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = wr.Get(webhook.ID)
+	if err == nil {
+		t.Error("found a deleted webhook")
+	}
+}
+
+func TestWebhookDeliveryRepository(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "2+2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook, err := repo.NewWebhookRepository(db).Create(repo.Webhook{
+		UserID: user.ID,
+		URL:    "http://localhost:9000/hook",
+		Secret: strings.Repeat("a", 64),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dr := repo.NewWebhookDeliveryRepository(db)
+
+	enqueued, err := dr.Enqueue(user.ID, expr.ID, json.RawMessage(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(enqueued) != 1 || enqueued[0].Status != repo.DeliveryPending {
+		t.Fatalf("got enqueued deliveries %+v", enqueued)
+	}
+
+	due, err := dr.Claim(10, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != 1 || due[0].URL != webhook.URL ||
+		due[0].Secret != webhook.Secret {
+		t.Fatalf("got claimed deliveries %+v", due)
+	}
+
+	// Пока доставка занята, ее больше никто не получит
+	again, err := dr.Claim(10, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(again) != 0 {
+		t.Errorf("claimed the delivery twice: %+v", again)
+	}
+
+	code := 200
+	delivery := due[0].WebhookDelivery
+	delivery.Status = repo.DeliveryDelivered
+	delivery.Attempts = 1
+	delivery.LastStatusCode = &code
+
+	_, err = dr.Update(delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := dr.ForWebhook(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log) != 1 || log[0].Status != repo.DeliveryDelivered ||
+		log[0].Attempts != 1 || *log[0].LastStatusCode != code {
+		t.Errorf("got delivery log %+v", log)
+	}
+}
+
+func TestExpressionUpdateEnqueuesWebhooks(t *testing.T) {
+	db := storage.TestWithTransaction(t, testDB)
+
+	user, err := createTestUser(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er := repo.NewExpressionRepository(db, testOrigin)
+
+	expr, err := er.Create(repo.Expression{
+		UserID:     user.ID,
+		Expression: "2+2",
+		Owner:      stringPtr(testOrigin),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook, err := repo.NewWebhookRepository(db).Create(repo.Webhook{
+		UserID: user.ID,
+		URL:    "http://localhost:9000/hook",
+		Secret: strings.Repeat("a", 64),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := func(saved repo.Expression) (json.RawMessage, error) {
+		return json.Marshal(saved.Status)
+	}
+
+	dr := repo.NewWebhookDeliveryRepository(db)
+
+	// Незавершенное выражение вебхукам неинтересно
+	_, err = er.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionProcessing,
+	}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Без доставок не сохраняется и статус
+	_, err = er.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionSucceed,
+		Result: float64Ptr(4),
+	}, func(repo.Expression) (json.RawMessage, error) {
+		return nil, errors.New("broken payload")
+	})
+	if err == nil {
+		t.Fatal("the update succeeded without webhook deliveries")
+	}
+
+	got, err := er.Get(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != repo.ExpressionProcessing {
+		t.Errorf("got status %v, want %v",
+			got.Status, repo.ExpressionProcessing)
+	}
+
+	_, err = er.Update(repo.Expression{
+		ID:     expr.ID,
+		Status: repo.ExpressionSucceed,
+		Result: float64Ptr(4),
+	}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := dr.ForWebhook(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log) != 1 || log[0].ExpressionID != expr.ID ||
+		string(log[0].Payload) != `"succeed"` {
+		t.Errorf("got deliveries %+v, want one about the result", log)
+	}
+}
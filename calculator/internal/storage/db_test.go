@@ -5,23 +5,28 @@ import (
 	"testing"
 
 	"github.com/dzherb/go_calculator/calculator/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// testDB — база, которую тестам пакета поднимает TestMain.
+var testDB *pgxpool.Pool
+
 func TestMain(m *testing.M) {
-	code := storage.RunTestsWithTempDB(m.Run)
+	code := storage.RunTestsWithTempDB(func(db *pgxpool.Pool) int {
+		testDB = db
+		return m.Run()
+	})
 
 	os.Exit(code)
 }
 
 func TestTempDB(t *testing.T) {
-	pool := storage.ActivePool()
-
-	dbName := pool.Config().ConnConfig.Database
+	dbName := testDB.Config().ConnConfig.Database
 	if dbName != "go_test" {
 		t.Errorf("db name is %s, expected %s", dbName, "go_test")
 	}
 
-	err := pool.Ping(t.Context())
+	err := testDB.Ping(t.Context())
 	if err != nil {
 		t.Error(err)
 	}
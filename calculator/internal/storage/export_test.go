@@ -1,4 +1,3 @@
 package storage
 
 var Migrator = migrator
-var ActivePool = activePool
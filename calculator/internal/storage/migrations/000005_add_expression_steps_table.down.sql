@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE expression_steps;
+DROP TYPE step_executor;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,22 @@
+BEGIN;
+
+CREATE TYPE step_executor AS ENUM (
+    'agent',
+    'local'
+);
+
+CREATE TABLE expression_steps
+(
+    id            SERIAL PRIMARY KEY,
+    expression_id INTEGER REFERENCES expressions (id) ON DELETE CASCADE NOT NULL,
+    operator      VARCHAR(1)                NOT NULL,
+    arg1          DOUBLE PRECISION          NOT NULL,
+    arg2          DOUBLE PRECISION          NOT NULL,
+    result        DOUBLE PRECISION          NOT NULL,
+    executor      step_executor             NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE INDEX expression_id_index ON expression_steps (expression_id);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE tasks;
+DROP TYPE task_status;
+DROP TABLE evaluation_states;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,45 @@
+BEGIN;
+
+CREATE TABLE evaluation_states
+(
+    expression_id INTEGER PRIMARY KEY REFERENCES expressions (id) ON DELETE CASCADE,
+    state         JSONB                     NOT NULL,
+    updated_at    TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE TRIGGER set_updated_at_trigger
+    BEFORE UPDATE
+    ON evaluation_states
+    FOR EACH ROW
+    EXECUTE FUNCTION set_updated_at();
+
+CREATE TYPE task_status AS ENUM (
+    'queued',
+    'processing',
+    'completed',
+    'canceled'
+);
+
+CREATE TABLE tasks
+(
+    id            BIGINT PRIMARY KEY,
+    expression_id INTEGER REFERENCES expressions (id) ON DELETE CASCADE NOT NULL,
+    node_id       INTEGER                   NOT NULL,
+    operator      VARCHAR(1)                NOT NULL,
+    arg1          DOUBLE PRECISION          NOT NULL,
+    arg2          DOUBLE PRECISION          NOT NULL,
+    status        task_status               NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT now() NOT NULL,
+    updated_at    TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE TRIGGER set_updated_at_trigger
+    BEFORE UPDATE
+    ON tasks
+    FOR EACH ROW
+    EXECUTE FUNCTION set_updated_at();
+
+CREATE INDEX tasks_outstanding_index ON tasks (expression_id)
+    WHERE status IN ('queued', 'processing');
+
+COMMIT;
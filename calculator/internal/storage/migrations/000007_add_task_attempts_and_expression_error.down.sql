@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE tasks DROP COLUMN attempts;
+
+ALTER TABLE expressions DROP COLUMN error;
+
+COMMIT;
\ No newline at end of file
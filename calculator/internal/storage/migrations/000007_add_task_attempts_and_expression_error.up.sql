@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE expressions ADD COLUMN error TEXT;
+
+ALTER TABLE tasks ADD COLUMN attempts INTEGER DEFAULT 0 NOT NULL;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+CREATE TABLE result_mismatches
+(
+    id              SERIAL PRIMARY KEY,
+    task_id         BIGINT                    NOT NULL,
+    expression_id   INTEGER REFERENCES expressions (id) ON DELETE CASCADE NOT NULL,
+    agent_id        TEXT                      NOT NULL,
+    operator        VARCHAR(1)                NOT NULL,
+    arg1            DOUBLE PRECISION          NOT NULL,
+    arg2            DOUBLE PRECISION          NOT NULL,
+    result          DOUBLE PRECISION,
+    error           TEXT,
+    accepted_result DOUBLE PRECISION,
+    accepted_error  TEXT,
+    created_at      TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE INDEX result_mismatches_agent_id_index ON result_mismatches (agent_id);
+
+COMMIT;
@@ -0,0 +1,22 @@
+BEGIN;
+
+-- Значение из перечисления удалить нельзя, поэтому пересоздаем тип
+UPDATE expressions SET status = 'aborted' WHERE status = 'canceled';
+
+ALTER TYPE expression_status RENAME TO expression_status_old;
+
+CREATE TYPE expression_status AS ENUM (
+    'new',
+    'processing',
+    'succeed',
+    'aborted',
+    'failed'
+);
+
+ALTER TABLE expressions
+    ALTER COLUMN status TYPE expression_status
+    USING status::text::expression_status;
+
+DROP TYPE expression_status_old;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TYPE expression_status ADD VALUE 'canceled';
+
+COMMIT;
\ No newline at end of file
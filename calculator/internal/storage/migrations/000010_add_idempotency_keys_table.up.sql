@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE idempotency_keys
+(
+    user_id     INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    key         VARCHAR(256)              NOT NULL,
+    fingerprint CHAR(64)                  NOT NULL,
+    status_code INTEGER,
+    response    BYTEA,
+    created_at  TIMESTAMPTZ DEFAULT now() NOT NULL,
+    expires_at  TIMESTAMPTZ               NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_index ON idempotency_keys (expires_at);
+
+COMMIT;
@@ -0,0 +1,48 @@
+BEGIN;
+
+CREATE TABLE webhooks
+(
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    url        TEXT                      NOT NULL,
+    secret     CHAR(64)                  NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE INDEX webhooks_user_id_index ON webhooks (user_id);
+
+CREATE TYPE webhook_delivery_status AS ENUM (
+    'pending',
+    'delivered',
+    'failed'
+);
+
+CREATE TABLE webhook_deliveries
+(
+    id               BIGSERIAL PRIMARY KEY,
+    webhook_id       INTEGER REFERENCES webhooks (id) ON DELETE CASCADE NOT NULL,
+    expression_id    INTEGER REFERENCES expressions (id) ON DELETE CASCADE NOT NULL,
+    payload          JSONB                     NOT NULL,
+    status           webhook_delivery_status   NOT NULL DEFAULT 'pending',
+    attempts         INTEGER     DEFAULT 0     NOT NULL,
+    next_attempt_at  TIMESTAMPTZ DEFAULT now() NOT NULL,
+    last_status_code INTEGER,
+    last_error       TEXT,
+    created_at       TIMESTAMPTZ DEFAULT now() NOT NULL,
+    updated_at       TIMESTAMPTZ DEFAULT now() NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_index
+    ON webhook_deliveries (webhook_id);
+
+CREATE INDEX webhook_deliveries_pending_index
+    ON webhook_deliveries (next_attempt_at)
+    WHERE status = 'pending';
+
+CREATE TRIGGER set_updated_at_trigger
+    BEFORE UPDATE
+    ON webhook_deliveries
+    FOR EACH ROW
+    EXECUTE FUNCTION set_updated_at();
+
+COMMIT;
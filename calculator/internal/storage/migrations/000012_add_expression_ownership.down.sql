@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP SEQUENCE IF EXISTS task_id_ranges;
+
+DROP INDEX IF EXISTS expressions_unfinished_index;
+
+ALTER TABLE expressions DROP COLUMN IF EXISTS owner_lease_until;
+
+ALTER TABLE expressions DROP COLUMN IF EXISTS owner;
+
+COMMIT;
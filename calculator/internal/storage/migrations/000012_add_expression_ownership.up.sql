@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE expressions ADD COLUMN owner TEXT;
+
+ALTER TABLE expressions ADD COLUMN owner_lease_until TIMESTAMPTZ;
+
+CREATE INDEX expressions_unfinished_index ON expressions (owner_lease_until)
+    WHERE status IN ('new', 'processing');
+
+-- Каждый запуск оркестратора выдает задачи из своего диапазона id
+CREATE SEQUENCE task_id_ranges MINVALUE 1 MAXVALUE 16777215;
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX IF EXISTS expressions_expression_trgm_index;
+
+DROP EXTENSION IF EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS user_id_index ON expressions (user_id);
+
+DROP INDEX IF EXISTS expressions_user_updated_index;
+
+DROP INDEX IF EXISTS expressions_user_created_index;
+
+COMMIT;
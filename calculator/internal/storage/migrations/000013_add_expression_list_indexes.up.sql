@@ -0,0 +1,20 @@
+BEGIN;
+
+-- Список выражений пользователя листается по (created_at, id)
+-- или (updated_at, id) в любую сторону
+CREATE INDEX expressions_user_created_index
+    ON expressions (user_id, created_at, id);
+
+CREATE INDEX expressions_user_updated_index
+    ON expressions (user_id, updated_at, id);
+
+-- Его заменяют индексы выше
+DROP INDEX IF EXISTS user_id_index;
+
+-- Поиск по подстроке в тексте выражения
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX expressions_expression_trgm_index
+    ON expressions USING GIN (expression gin_trgm_ops);
+
+COMMIT;
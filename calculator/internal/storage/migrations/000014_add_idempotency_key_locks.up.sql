@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Пока запрос выполняется, ключ занят до этого момента. Если процесс
+-- упал, не сохранив ответ, повтор запроса заберет ключ после него
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMPTZ;
+
+COMMIT;
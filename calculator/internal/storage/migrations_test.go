@@ -56,7 +56,7 @@ func TestStairway(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	m, err := storage.Migrator()
+	m, err := storage.Migrator(testDB)
 	if err != nil {
 		t.Fatal(err)
 	}
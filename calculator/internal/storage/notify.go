@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewInstanceID returns a random id that tells an instance apart from
+// the others sharing the database, e.g. to recognize its own
+// notifications.
+func NewInstanceID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// listenRetryDelay — пауза перед повторной подпиской после обрыва.
+const listenRetryDelay = time.Second
+
+// Notify sends the payload to those listening on the channel.
+// Inside a transaction it is delivered on commit.
+func Notify(ctx context.Context, db Executor, channel, payload string) error {
+	_, err := db.Exec(ctx, `SELECT pg_notify($1, $2);`, channel, payload)
+	return err
+}
+
+// Listen calls handle with the payload of every notification on the
+// channel until the context is canceled. It holds a connection of the
+// pool for itself and takes a new one if the connection breaks.
+// Notifications sent while reconnecting are lost, so onListen is called
+// every time listening starts to let the caller catch up.
+func Listen(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	channel string,
+	onListen func(),
+	handle func(payload string),
+) {
+	for ctx.Err() == nil {
+		err := listen(ctx, pool, channel, onListen, handle)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		slog.Error("Stopped listening to notifications",
+			slog.String("channel", channel),
+			slog.String("error", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+func listen(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	channel string,
+	onListen func(),
+	handle func(payload string),
+) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// Соединение с подпиской не должно вернуться в пул:
+		// закрытое соединение пул выбросит
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+
+	onListen()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		handle(notification.Payload)
+	}
+}
@@ -0,0 +1,45 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/internal/storage"
+)
+
+func TestListen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	listening := make(chan struct{}, 1)
+	received := make(chan string, 1)
+
+	go storage.Listen(
+		ctx,
+		testDB,
+		"test_channel",
+		func() { listening <- struct{}{} },
+		func(payload string) { received <- payload },
+	)
+
+	select {
+	case <-listening:
+	case <-ctx.Done():
+		t.Fatal("did not start listening")
+	}
+
+	err := storage.Notify(ctx, testDB, "test_channel", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload != "hello" {
+			t.Errorf("got payload %q, want %q", payload, "hello")
+		}
+	case <-ctx.Done():
+		t.Error("did not receive the notification")
+	}
+}
@@ -14,35 +14,9 @@ import (
 	pgxslog "github.com/mcosta74/pgx-slog"
 )
 
-// Conn provides access to the database connection.
-// It returns a pgxpool.Pool wrapped in the Connection interface.
-//
-// This function is declared as a variable
-// so it can be overridden or mocked in tests.
-var Conn = func() Connection {
-	return activePool()
-}
-
-var pool *pgxpool.Pool
-
-func activePool() *pgxpool.Pool {
-	if pool == nil {
-		panic("db is not initialized")
-	}
-
-	return pool
-}
-
-func closePool() {
-	if pool != nil {
-		pool.Close()
-		pool = nil
-	}
-}
-
 const DefaultStatementTimeout = 10 * time.Second
 
-func InitFromEnv() (func(), error) {
+func InitFromEnv() (*pgxpool.Pool, error) {
 	url, ok := os.LookupEnv("DATABASE_URL")
 	if !ok {
 		return nil, errors.New("DATABASE_URL environment variable not set")
@@ -53,9 +27,9 @@ func InitFromEnv() (func(), error) {
 	})
 }
 
-func Init(cfg Config) (func(), error) {
-	var err error
-
+// Init connects to the database. The returned pool is shared
+// by the repositories and must be closed by the caller.
+func Init(cfg Config) (*pgxpool.Pool, error) {
 	pgxCfg, err := pgxpool.ParseConfig(cfg.DatabaseUrl)
 	if err != nil {
 		return nil, err
@@ -66,17 +40,18 @@ func Init(cfg Config) (func(), error) {
 		setStatementTimeout(DefaultStatementTimeout),
 	)
 
-	pool, err = pgxpool.NewWithConfig(context.Background(), pgxCfg)
+	pool, err := pgxpool.NewWithConfig(context.Background(), pgxCfg)
 	if err != nil {
 		return nil, err
 	}
 
 	err = pool.Ping(context.Background())
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 
-	return closePool, nil
+	return pool, nil
 }
 
 func traceLogger(level tracelog.LogLevel) *tracelog.TraceLog {
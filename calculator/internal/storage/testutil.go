@@ -16,16 +16,19 @@ import (
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
 )
 
 // RunTestsWithTempDB sets up a container with a temporary
-// PostgreSQL database for testing.
+// PostgreSQL database for testing and passes its pool to testRunner.
 //
 // Returns the exit code from testRunner or 1 on setup failure.
-func RunTestsWithTempDB(testRunner func() int) int { //nolint:funlen
+func RunTestsWithTempDB( //nolint:funlen
+	testRunner func(db *pgxpool.Pool) int,
+) int {
 	dockerPool, err := dockertest.NewPool("")
 	if err != nil {
 		slog.Error("Could not construct dockerPool", "error", err)
@@ -71,16 +74,15 @@ func RunTestsWithTempDB(testRunner func() int) int { //nolint:funlen
 
 	// exponential backoff-retry, because the application
 	// in the container might not be ready to accept connections yet
+	var db *pgxpool.Pool
+
 	dockerPool.MaxWait = 10 * time.Second //nolint:mnd
 	err = dockerPool.Retry(func() error {
-		_, err = Init(Config{
+		db, err = Init(Config{
 			DatabaseUrl: databaseUrl,
 		})
-		if err != nil {
-			return err
-		}
 
-		return pool.Ping(context.Background())
+		return err
 	})
 
 	if err != nil {
@@ -89,12 +91,14 @@ func RunTestsWithTempDB(testRunner func() int) int { //nolint:funlen
 	}
 
 	defer func() {
+		db.Close()
+
 		if err = dockerPool.Purge(resource); err != nil {
 			slog.Error("Could not purge resource", "error", err)
 		}
 	}()
 
-	return testRunner()
+	return testRunner(db)
 }
 
 func getHostPort(resource *dockertest.Resource, id string) (string, error) {
@@ -116,8 +120,8 @@ func getHostPort(resource *dockertest.Resource, id string) (string, error) {
 // and then rolls back all migrations.
 //
 // Returns the exit code from testRunner or 1 on setup failure.
-func RunTestsWithMigratedDB(testRunner func() int) int {
-	m, err := migrator()
+func RunTestsWithMigratedDB(db *pgxpool.Pool, testRunner func() int) int {
+	m, err := migrator(db)
 	if err != nil {
 		slog.Error(err.Error())
 		return 1
@@ -153,8 +157,8 @@ func RunTestsWithMigratedDB(testRunner func() int) int {
 // to roll them back after the test finishes.
 //
 // This helper is intended for individual tests.
-func TestWithMigratedDB(t *testing.T) {
-	m, err := migrator()
+func TestWithMigratedDB(t *testing.T, db *pgxpool.Pool) {
+	m, err := migrator(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -181,10 +185,11 @@ func TestWithMigratedDB(t *testing.T) {
 	})
 }
 
-// TestWithTransaction sets up a rolled-back transaction for a test.
+// TestWithTransaction sets up a rolled-back transaction for a test
+// and returns the connection the test should give its repositories.
 // Fails the test if setup fails.
-func TestWithTransaction(t *testing.T) {
-	tx, err := activePool().Begin(t.Context())
+func TestWithTransaction(t *testing.T, db *pgxpool.Pool) Connection {
+	tx, err := db.Begin(t.Context())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,14 +202,6 @@ func TestWithTransaction(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	prevConn := Conn
-
-	// Mock the Conn function
-	// to return the nested transaction.
-	Conn = func() Connection {
-		return nestedTx
-	}
-
 	t.Cleanup(func() {
 		// Use Background context,
 		// because t.Context() is already closed on Cleanup
@@ -213,15 +210,16 @@ func TestWithTransaction(t *testing.T) {
 			!errors.Is(err, pgx.ErrTxClosed) {
 			slog.Error(err.Error())
 		}
-
-		Conn = prevConn
 	})
-}
 
-func migrator() (*migrate.Migrate, error) {
-	db := stdlib.OpenDBFromPool(activePool())
+	return nestedTx
+}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+func migrator(db *pgxpool.Pool) (*migrate.Migrate, error) {
+	driver, err := postgres.WithInstance(
+		stdlib.OpenDBFromPool(db),
+		&postgres.Config{},
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -239,7 +237,7 @@ func migrator() (*migrate.Migrate, error) {
 
 	return migrate.NewWithDatabaseInstance(
 		migrationsPath,
-		activePool().Config().ConnConfig.Database,
+		db.Config().ConnConfig.Database,
 		driver,
 	)
 }
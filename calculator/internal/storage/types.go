@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type Retriever interface {
@@ -11,11 +12,16 @@ type Retriever interface {
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
+type Executor interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
 type TransactionIssuer interface {
 	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
 type Connection interface {
 	Retriever
+	Executor
 	TransactionIssuer
 }
@@ -1,75 +1,148 @@
 package calc
 
 import (
-	"fmt"
 	"strconv"
+	"time"
 )
 
-type node interface {
+// Node is a node of the expression syntax tree.
+// It is either a *Literal or a *BinaryOp.
+type Node interface {
 	String() string
+	node()
 }
 
-type numberNode struct {
+// Literal is a numeric literal.
+type Literal struct {
 	value float64
 }
 
-func (n *numberNode) String() string {
-	return fmt.Sprintf("%.2f", n.value)
+func NewLiteral(value float64) *Literal {
+	return &Literal{value: value}
 }
 
-type operatorNode struct {
+func (n *Literal) Value() float64 {
+	return n.value
+}
+
+func (n *Literal) String() string {
+	return Format(n)
+}
+
+func (n *Literal) node() {}
+
+// BinaryOp is an application of an arithmetic operator to two operands.
+type BinaryOp struct {
+	id           int // Номер узла в графе, стабилен между снимками
 	operator     string
-	left         node
-	right        node
-	parent       *operatorNode // Ссылка на родителя
+	left         Node
+	right        Node
+	parents      []*BinaryOp // Ссылки на родителей
 	isProcessing bool
 	isProcessed  bool
+	// Оценка времени от начала вычисления узла до вычисления корня
+	criticalPath time.Duration
+}
+
+func NewBinaryOp(operator string, left, right Node) *BinaryOp {
+	return &BinaryOp{
+		operator: operator,
+		left:     left,
+		right:    right,
+	}
 }
 
-func (o *operatorNode) String() string {
-	return fmt.Sprintf(
-		"(%s %s %s)",
-		o.left.String(),
-		o.operator,
-		o.right.String(),
-	)
+func (o *BinaryOp) Operator() string {
+	return o.operator
 }
 
-func (o *operatorNode) nextReadyForProcessingNode() (*operatorNode, bool) {
-	if o.isProcessed || o.isProcessing {
+func (o *BinaryOp) Left() Node {
+	return o.left
+}
+
+func (o *BinaryOp) Right() Node {
+	return o.right
+}
+
+func (o *BinaryOp) String() string {
+	return Format(o)
+}
+
+func (o *BinaryOp) node() {}
+
+// nextReadyForProcessingNode выбирает среди готовых к вычислению узлов
+// узел с самым длинным оставшимся критическим путем.
+func (o *BinaryOp) nextReadyForProcessingNode(
+	accept func(operator string) bool,
+) (*BinaryOp, bool) {
+	var best *BinaryOp
+
+	for _, node := range o.readyNodes(accept) {
+		if best == nil || node.criticalPath > best.criticalPath {
+			best = node
+		}
+	}
+
+	if best == nil {
 		return nil, false
 	}
 
+	best.isProcessing = true
+
+	return best, true
+}
+
+func (o *BinaryOp) readyNodes(accept func(operator string) bool) []*BinaryOp {
+	var nodes []*BinaryOp
+
+	o.collectReadyNodes(accept, make(map[*BinaryOp]struct{}), &nodes)
+
+	return nodes
+}
+
+// Узлы графа могут быть общими для нескольких родителей,
+// поэтому запоминаем уже просмотренные, чтобы не обходить их повторно.
+func (o *BinaryOp) collectReadyNodes(
+	accept func(operator string) bool,
+	visited map[*BinaryOp]struct{},
+	nodes *[]*BinaryOp,
+) {
+	if _, ok := visited[o]; ok {
+		return
+	}
+
+	visited[o] = struct{}{}
+
+	if o.isProcessed || o.isProcessing {
+		return
+	}
+
 	// Проверяем, можно ли вычислить этот узел
-	_, leftOK := o.left.(*numberNode)
-	_, rightOK := o.right.(*numberNode)
+	_, leftOK := o.left.(*Literal)
+	_, rightOK := o.right.(*Literal)
 
 	if leftOK && rightOK {
-		o.isProcessing = true
+		if accept == nil || accept(o.operator) {
+			*nodes = append(*nodes, o)
+		}
 
-		return o, true
+		return
 	}
 
 	// Рекурсивный поиск
-	if leftOp, ok := o.left.(*operatorNode); ok {
-		if node, ok := leftOp.nextReadyForProcessingNode(); ok {
-			return node, true
-		}
+	if leftOp, ok := o.left.(*BinaryOp); ok {
+		leftOp.collectReadyNodes(accept, visited, nodes)
 	}
 
-	if rightOp, ok := o.right.(*operatorNode); ok {
-		if node, ok := rightOp.nextReadyForProcessingNode(); ok {
-			return node, true
-		}
+	if rightOp, ok := o.right.(*BinaryOp); ok {
+		rightOp.collectReadyNodes(accept, visited, nodes)
 	}
-
-	return nil, false
 }
 
+var precedence = map[string]int{"+": 1, "-": 1, "*": 2, "/": 2} //nolint:mnd
+
 // Переводит инфиксное выражение в RPN (обратную польскую нотацию).
 func shuntingYard(tokens []Token) []Token { //nolint:gocognit
-	precedence := map[string]int{"+": 1, "-": 1, "*": 2, "/": 2} //nolint:mnd
-
 	var output []Token
 
 	var operators []Token
@@ -116,18 +189,18 @@ func shuntingYard(tokens []Token) []Token { //nolint:gocognit
 
 // Строит абстрактое ситактическое дерево на основе
 // последовательности токенов в обратной польской нотации.
-func buildAST(rpnOrganizedTokens []Token) node {
-	var stack []node
+func buildAST(rpnOrganizedTokens []Token) Node {
+	var stack []Node
 
 	for _, currToken := range rpnOrganizedTokens {
 		if currToken.TokenType == Number {
 			val, _ := strconv.ParseFloat(currToken.Value, 64)
-			stack = append(stack, &numberNode{value: val})
+			stack = append(stack, &Literal{value: val})
 		} else {
 			right := stack[len(stack)-1]
 			left := stack[len(stack)-2]
 			stack = stack[:len(stack)-2]
-			stack = append(stack, &operatorNode{
+			stack = append(stack, &BinaryOp{
 				operator: currToken.Value,
 				left:     left,
 				right:    right,
@@ -135,22 +208,5 @@ func buildAST(rpnOrganizedTokens []Token) node {
 		}
 	}
 
-	root := stack[0]
-	if r, ok := root.(*operatorNode); ok {
-		addParents(r)
-	}
-
-	return root
-}
-
-func addParents(node *operatorNode) {
-	if leftOp, ok := node.left.(*operatorNode); ok {
-		leftOp.parent = node
-		addParents(leftOp)
-	}
-
-	if rightOp, ok := node.right.(*operatorNode); ok {
-		rightOp.parent = node
-		addParents(rightOp)
-	}
+	return stack[0]
 }
@@ -0,0 +1,127 @@
+package calc_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func TestParseFormat(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "1", expected: "1"},
+		{expression: "-2.5", expected: "-2.5"},
+		{expression: "2+2*2", expected: "2 + 2 * 2"},
+		{expression: "(2+2)*2", expected: "(2 + 2) * 2"},
+		{expression: "1-2-3", expected: "1 - 2 - 3"},
+		{expression: "1-(2-3)", expected: "1 - (2 - 3)"},
+		{expression: "1+(2+3)", expected: "1 + (2 + 3)"},
+		{expression: "2/(3*4)", expected: "2 / (3 * 4)"},
+		{expression: "((1.50))*-3", expected: "1.5 * -3"},
+		{
+			expression: "-1.2*(3-2) / 10 + (-4 - 3.5)",
+			expected:   "-1.2 * (3 - 2) / 10 + (-4 - 3.5)",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			node, err := calc.Parse(testCase.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := calc.Format(node)
+			if got != testCase.expected {
+				t.Fatalf("got %q, want %q", got, testCase.expected)
+			}
+
+			reparsed, err := calc.Parse(got)
+			if err != nil {
+				t.Fatalf("formatted expression %q is invalid: %v", got, err)
+			}
+
+			if calc.Format(reparsed) != got {
+				t.Errorf(
+					"round trip changed the tree: %q -> %q",
+					got,
+					calc.Format(reparsed),
+				)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := calc.Parse("1+")
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+type operatorCollector struct {
+	operators []string
+}
+
+func (c *operatorCollector) Visit(node calc.Node) calc.Visitor {
+	if op, ok := node.(*calc.BinaryOp); ok {
+		c.operators = append(c.operators, op.Operator())
+	}
+
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	node, err := calc.Parse("(1+2)*3-4/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &operatorCollector{}
+	calc.Walk(c, node)
+
+	expected := []string{"-", "*", "+", "/"}
+	if !slices.Equal(c.operators, expected) {
+		t.Errorf("got operators %v, want %v", c.operators, expected)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	node, err := calc.Parse("(1+2)*3-4/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []float64
+
+	calc.Inspect(node, func(n calc.Node) bool {
+		if lit, ok := n.(*calc.Literal); ok {
+			values = append(values, lit.Value())
+		}
+
+		// Не спускаемся в поддерево деления
+		op, ok := n.(*calc.BinaryOp)
+
+		return !ok || op.Operator() != "/"
+	})
+
+	expected := []float64{1, 2, 3}
+	if !slices.Equal(values, expected) {
+		t.Errorf("got values %v, want %v", values, expected)
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	node := calc.NewBinaryOp(
+		"*",
+		calc.NewBinaryOp("+", calc.NewLiteral(1), calc.NewLiteral(2)),
+		calc.NewLiteral(3),
+	)
+
+	if got := node.String(); got != "(1 + 2) * 3" {
+		t.Errorf("got %q, want %q", got, "(1 + 2) * 3")
+	}
+}
@@ -1,7 +1,7 @@
 package calc
 
 func Calculate(expression string) (float64, error) {
-	exp, err := NewExpression(expression)
+	exp, err := NewExpression(expression, NewIDSeries(0))
 	if err != nil {
 		return 0, err
 	}
@@ -15,3 +15,18 @@ func Calculate(expression string) (float64, error) {
 
 	return res, err
 }
+
+// Parse parses the expression and returns the root of its syntax tree.
+// The tree is detached from any evaluation and is safe to inspect.
+func Parse(expression string) (Node, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	// Переводим токены в обратную польскую нотацию (RPN)
+	rpnOrganizedTokens := shuntingYard(tokens)
+
+	// Составляем абстрактное синтаксическое дерево
+	return buildAST(rpnOrganizedTokens), nil
+}
@@ -0,0 +1,95 @@
+package calc
+
+import (
+	"iter"
+	"math"
+	"strconv"
+)
+
+// dagBuilder объединяет структурно одинаковые поддеревья в один узел
+// (hash-consing), превращая дерево в ориентированный ациклический граф.
+// Так каждое уникальное подвыражение вычисляется ровно один раз,
+// а его результат передается всем родителям.
+type dagBuilder struct {
+	nodes map[string]Node
+	ids   map[Node]int
+}
+
+func buildDAG(root Node) Node {
+	b := &dagBuilder{
+		nodes: make(map[string]Node),
+		ids:   make(map[Node]int),
+	}
+
+	return b.intern(root)
+}
+
+func (b *dagBuilder) intern(node Node) Node {
+	var key string
+
+	switch n := node.(type) {
+	case *Literal:
+		key = "#" + strconv.FormatUint(math.Float64bits(n.value), 16)
+	case *BinaryOp:
+		n.left = b.intern(n.left)
+		n.right = b.intern(n.right)
+		n.parents = nil
+
+		key = strconv.Itoa(b.ids[n.left]) + " " + n.operator + " " +
+			strconv.Itoa(b.ids[n.right])
+	}
+
+	if existing, ok := b.nodes[key]; ok {
+		return existing
+	}
+
+	b.nodes[key] = node
+	b.ids[node] = len(b.ids)
+
+	if op, ok := node.(*BinaryOp); ok {
+		op.id = b.ids[node]
+		op.linkChildren()
+	}
+
+	return node
+}
+
+func (o *BinaryOp) linkChildren() {
+	if leftOp, ok := o.left.(*BinaryOp); ok {
+		leftOp.parents = append(leftOp.parents, o)
+	}
+
+	// Оба операнда могут ссылаться на один и тот же узел: (a+b)*(a+b)
+	if rightOp, ok := o.right.(*BinaryOp); ok && o.right != o.left {
+		rightOp.parents = append(rightOp.parents, o)
+	}
+}
+
+// nodes перебирает все операторы графа, каждый по одному разу.
+func (o *BinaryOp) nodes() iter.Seq[*BinaryOp] {
+	return func(yield func(*BinaryOp) bool) {
+		visited := make(map[*BinaryOp]struct{})
+		stack := []*BinaryOp{o}
+
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if _, ok := visited[node]; ok {
+				continue
+			}
+
+			visited[node] = struct{}{}
+
+			if !yield(node) {
+				return
+			}
+
+			for _, child := range []Node{node.right, node.left} {
+				if childOp, ok := child.(*BinaryOp); ok {
+					stack = append(stack, childOp)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package calc_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func computeTask(t *testing.T, task *calc.Task) float64 {
+	t.Helper()
+
+	left, right := task.GetArguments()
+
+	switch task.GetOperator() {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/":
+		return left / right
+	}
+
+	t.Fatalf("unexpected operator %s", task.GetOperator())
+
+	return 0
+}
+
+// evaluateSequentially вычисляет выражение по одной задаче за раз
+// и возвращает результат и количество выполненных задач.
+func evaluateSequentially(
+	t *testing.T,
+	exp *calc.Expression,
+) (float64, int) {
+	t.Helper()
+
+	tasks := 0
+
+	for {
+		task, ok := exp.GetNextTask()
+		if !ok {
+			break
+		}
+
+		tasks++
+
+		err := task.Complete(computeTask(t, task))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := exp.GetResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res, tasks
+}
+
+func TestCommonSubexpressions(t *testing.T) {
+	testCases := []struct {
+		expression    string
+		expectedRes   float64
+		expectedTasks int
+	}{
+		{
+			expression:    "(1+2)*(1+2) + (1+2)/2",
+			expectedRes:   10.5,
+			expectedTasks: 4,
+		},
+		{
+			expression:    "(2+3)*(2+3)",
+			expectedRes:   25,
+			expectedTasks: 2,
+		},
+		{
+			expression:    "1+2+3",
+			expectedRes:   6,
+			expectedTasks: 2,
+		},
+		{
+			// Разный порядок операндов — разные подвыражения
+			expression:    "(1-2)*(2-1)",
+			expectedRes:   -1,
+			expectedTasks: 3,
+		},
+		{
+			expression:    "((1+1)*(1+1))*((1+1)*(1+1))",
+			expectedRes:   16,
+			expectedTasks: 3,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			exp, err := calc.NewExpression(testCase.expression, calc.NewIDSeries(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, tasks := evaluateSequentially(t, exp)
+
+			if res != testCase.expectedRes {
+				t.Errorf("got result %f, want %f", res, testCase.expectedRes)
+			}
+
+			if tasks != testCase.expectedTasks {
+				t.Errorf(
+					"got %d tasks, want %d",
+					tasks,
+					testCase.expectedTasks,
+				)
+			}
+		})
+	}
+}
+
+func TestSharedSubexpressionIsDispatchedOnce(t *testing.T) {
+	exp, err := calc.NewExpression("(4-1)*(4-1)", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if _, ok = exp.GetNextTask(); ok {
+		t.Fatal("shared subexpression was dispatched twice")
+	}
+
+	err = task.Complete(computeTask(t, task))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, ok = exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	left, right := task.GetArguments()
+	if left != 3 || right != 3 {
+		t.Errorf("got arguments %f, %f, want 3, 3", left, right)
+	}
+}
@@ -0,0 +1,53 @@
+package calc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format prints the syntax tree back to an infix expression.
+// Only the brackets required to preserve the tree structure are kept,
+// so Parse(Format(node)) yields a tree equal to node.
+func Format(node Node) string {
+	var sb strings.Builder
+
+	writeNode(&sb, node)
+
+	return sb.String()
+}
+
+func writeNode(sb *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *Literal:
+		sb.WriteString(strconv.FormatFloat(n.value, 'f', -1, 64))
+	case *BinaryOp:
+		writeOperand(sb, n.left, precedence[n.operator], false)
+		sb.WriteString(" " + n.operator + " ")
+		writeOperand(sb, n.right, precedence[n.operator], true)
+	}
+}
+
+// Все операторы левоассоциативны, поэтому правый операнд
+// с тем же приоритетом тоже нужно взять в скобки.
+func writeOperand(
+	sb *strings.Builder,
+	operand Node,
+	parentPrecedence int,
+	isRight bool,
+) {
+	op, ok := operand.(*BinaryOp)
+	if !ok {
+		writeNode(sb, operand)
+		return
+	}
+
+	p := precedence[op.operator]
+	if p > parentPrecedence || p == parentPrecedence && !isRight {
+		writeNode(sb, operand)
+		return
+	}
+
+	sb.WriteString("(")
+	writeNode(sb, operand)
+	sb.WriteString(")")
+}
@@ -0,0 +1,74 @@
+package calc
+
+import "time"
+
+// CostFunc оценивает время вычисления оператора.
+type CostFunc func(operator string) time.Duration
+
+// AnnotateCriticalPath размечает каждый узел оставшимся критическим путем:
+// суммарной стоимостью самой длинной цепочки от узла до корня.
+// Чем длиннее путь, тем раньше узел стоит отдать на вычисление.
+func (e *Expression) AnnotateCriticalPath(cost CostFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.Root.isProcessed {
+		return
+	}
+
+	annotated := make(map[*BinaryOp]struct{})
+
+	var annotate func(node *BinaryOp)
+
+	// Поднимаемся к корню: путь узла зависит только от путей родителей
+	annotate = func(node *BinaryOp) {
+		if _, ok := annotated[node]; ok {
+			return
+		}
+
+		var longestParentPath time.Duration
+
+		for _, parent := range node.parents {
+			annotate(parent)
+			longestParentPath = max(longestParentPath, parent.criticalPath)
+		}
+
+		node.criticalPath = cost(node.operator) + longestParentPath
+		annotated[node] = struct{}{}
+	}
+
+	for node := range e.Root.nodes() {
+		annotate(node)
+	}
+}
+
+// ReadyTasks returns tasks for all nodes that are ready to be evaluated
+// and whose operator is accepted. A nil accept function accepts any
+// operator.
+func (e *Expression) ReadyTasks(accept func(operator string) bool) []*Task {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.IsFailed {
+		return nil
+	}
+
+	nodes := e.Root.readyNodes(accept)
+	tasks := make([]*Task, 0, len(nodes))
+
+	for _, node := range nodes {
+		node.isProcessing = true
+		tasks = append(tasks, newTask(node, e))
+	}
+
+	if len(tasks) > 0 {
+		e.IsProcessing = true
+	}
+
+	return tasks
+}
+
+// Priority returns the remaining critical path of the task's node.
+func (t *Task) Priority() time.Duration {
+	return t.node.criticalPath
+}
@@ -0,0 +1,120 @@
+package calc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func unitCost(string) time.Duration {
+	return time.Second
+}
+
+func TestCriticalPathOrder(t *testing.T) {
+	// Правое поддерево глубже, поэтому 3*4 должно уйти первым,
+	// хотя обход слева направо выбрал бы 1+2.
+	exp, err := calc.NewExpression("(1+2) + ((3*4)*5)*6", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp.AnnotateCriticalPath(unitCost)
+
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if task.GetOperator() != "*" {
+		t.Errorf("got operator %s, want *", task.GetOperator())
+	}
+
+	if task.Priority() != 4*time.Second {
+		t.Errorf("got priority %v, want %v", task.Priority(), 4*time.Second)
+	}
+
+	task, ok = exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if task.Priority() != 2*time.Second {
+		t.Errorf("got priority %v, want %v", task.Priority(), 2*time.Second)
+	}
+}
+
+func TestCriticalPathUsesCosts(t *testing.T) {
+	exp, err := calc.NewExpression("(1+2)/(3*4)", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp.AnnotateCriticalPath(func(operator string) time.Duration {
+		if operator == "+" {
+			return 10 * time.Second
+		}
+
+		return time.Second
+	})
+
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if task.GetOperator() != "+" {
+		t.Errorf("got operator %s, want +", task.GetOperator())
+	}
+
+	if task.Priority() != 11*time.Second {
+		t.Errorf("got priority %v, want %v", task.Priority(), 11*time.Second)
+	}
+}
+
+func TestCriticalPathSharedNode(t *testing.T) {
+	// Общий узел 1+2 берет самый длинный путь из двух родителей.
+	exp, err := calc.NewExpression("(1+2)*3*4 + (1+2)", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp.AnnotateCriticalPath(unitCost)
+
+	tasks := exp.ReadyTasks(nil)
+	if len(tasks) != 1 {
+		t.Fatalf("got %d ready tasks, want 1", len(tasks))
+	}
+
+	if tasks[0].Priority() != 4*time.Second {
+		t.Errorf(
+			"got priority %v, want %v",
+			tasks[0].Priority(),
+			4*time.Second,
+		)
+	}
+}
+
+func TestReadyTasks(t *testing.T) {
+	exp, err := calc.NewExpression("(1+2)*(3+4)-5/6", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := exp.ReadyTasks(nil)
+	if len(tasks) != 3 {
+		t.Fatalf("got %d ready tasks, want 3", len(tasks))
+	}
+
+	if more := exp.ReadyTasks(nil); len(more) != 0 {
+		t.Errorf("got %d tasks dispatched twice", len(more))
+	}
+
+	if err = tasks[0].Cancel(); err != nil {
+		t.Fatal(err)
+	}
+
+	if again := exp.ReadyTasks(nil); len(again) != 1 {
+		t.Errorf("got %d tasks after cancel, want 1", len(again))
+	}
+}
@@ -0,0 +1,184 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is a serializable snapshot of an expression evaluation.
+// It keeps only the operators that are not evaluated yet:
+// operands that are already computed are stored as values.
+type State struct {
+	Root   int         `json:"root"`
+	Nodes  []NodeState `json:"nodes"`
+	Result *float64    `json:"result,omitempty"`
+}
+
+// NodeState describes a single operator of the graph.
+type NodeState struct {
+	ID       int          `json:"id"`
+	Operator string       `json:"operator"`
+	Left     OperandState `json:"left"`
+	Right    OperandState `json:"right"`
+}
+
+// OperandState is either a reference to another node or a value.
+type OperandState struct {
+	Node  *int     `json:"node,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+var ErrInvalidState = errors.New("invalid expression state")
+
+// Snapshot captures the current evaluation state of the expression.
+// Nodes that are being processed are saved as not started:
+// whether their tasks survive is up to the caller.
+func (e *Expression) Snapshot() State {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.Root.isProcessed {
+		result := e.Root.left.(*Literal).value
+
+		return State{Result: &result}
+	}
+
+	state := State{Root: e.Root.id}
+
+	for node := range e.Root.nodes() {
+		state.Nodes = append(state.Nodes, NodeState{
+			ID:       node.id,
+			Operator: node.operator,
+			Left:     operandState(node.left),
+			Right:    operandState(node.right),
+		})
+	}
+
+	return state
+}
+
+func operandState(node Node) OperandState {
+	switch n := node.(type) {
+	case *Literal:
+		return OperandState{Value: &n.value}
+	case *BinaryOp:
+		return OperandState{Node: &n.id}
+	}
+
+	return OperandState{}
+}
+
+// RestoreExpression rebuilds an expression from a snapshot.
+// New tasks of the expression take ids from taskIDs.
+func RestoreExpression(
+	id uint64,
+	state State,
+	taskIDs IDSource,
+) (*Expression, error) {
+	if state.Result != nil {
+		return &Expression{
+			Id:      id,
+			taskIDs: taskIDs,
+			Root: &BinaryOp{
+				left:        &Literal{value: *state.Result},
+				isProcessed: true,
+			},
+		}, nil
+	}
+
+	nodes := make(map[int]*BinaryOp, len(state.Nodes))
+
+	for _, ns := range state.Nodes {
+		if _, ok := nodes[ns.ID]; ok {
+			return nil, fmt.Errorf(
+				"%w: duplicate node %d",
+				ErrInvalidState,
+				ns.ID,
+			)
+		}
+
+		nodes[ns.ID] = &BinaryOp{id: ns.ID, operator: ns.Operator}
+	}
+
+	for _, ns := range state.Nodes {
+		node := nodes[ns.ID]
+
+		var err error
+
+		if node.left, err = restoreOperand(ns.Left, nodes); err != nil {
+			return nil, err
+		}
+
+		if node.right, err = restoreOperand(ns.Right, nodes); err != nil {
+			return nil, err
+		}
+	}
+
+	root, ok := nodes[state.Root]
+	if !ok {
+		return nil, fmt.Errorf(
+			"%w: missing root %d",
+			ErrInvalidState,
+			state.Root,
+		)
+	}
+
+	for _, node := range nodes {
+		node.linkChildren()
+	}
+
+	return &Expression{Id: id, Root: root, taskIDs: taskIDs}, nil
+}
+
+func restoreOperand(
+	operand OperandState,
+	nodes map[int]*BinaryOp,
+) (Node, error) {
+	switch {
+	case operand.Value != nil:
+		return &Literal{value: *operand.Value}, nil
+	case operand.Node != nil:
+		node, ok := nodes[*operand.Node]
+		if !ok {
+			return nil, fmt.Errorf(
+				"%w: missing node %d",
+				ErrInvalidState,
+				*operand.Node,
+			)
+		}
+
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("%w: empty operand", ErrInvalidState)
+}
+
+// ResumeTask recreates a task handed out before the state was restored.
+// The task keeps its id, so a result for it is still accepted.
+// It fails if the node is already evaluated or is not ready.
+func (e *Expression) ResumeTask(id uint64, nodeID int) (*Task, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.IsFailed {
+		return nil, false
+	}
+
+	for _, node := range e.Root.readyNodes(nil) {
+		if node.id != nodeID {
+			continue
+		}
+
+		node.isProcessing = true
+		e.IsProcessing = true
+
+		return &Task{Id: id, expression: e, node: node}, true
+	}
+
+	return nil, false
+}
+
+// NodeID returns the id of the graph node the task evaluates.
+func (t *Task) NodeID() int {
+	return t.node.id
+}
@@ -0,0 +1,153 @@
+package calc_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func restoreViaJSON(
+	t *testing.T,
+	exp *calc.Expression,
+) *calc.Expression {
+	t.Helper()
+
+	data, err := json.Marshal(exp.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state calc.State
+
+	if err = json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := calc.RestoreExpression(exp.Id, state, calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return restored
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	exp, err := calc.NewExpression("(1+2)*(1+2) + (3-4)/5", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Вычисляем часть узлов до снимка
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if err = task.Complete(computeTask(t, task)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Задача в работе не попадает в снимок и будет выдана заново
+	if _, ok = exp.GetNextTask(); !ok {
+		t.Fatal("expected a task")
+	}
+
+	restored := restoreViaJSON(t, exp)
+
+	res, tasks := evaluateSequentially(t, restored)
+	if res != 8.8 {
+		t.Errorf("got %v, want 8.8", res)
+	}
+
+	if tasks != 4 {
+		t.Errorf("got %d tasks, want 4", tasks)
+	}
+}
+
+func TestSnapshotOfEvaluatedExpression(t *testing.T) {
+	exp, err := calc.NewExpression("2*3", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evaluateSequentially(t, exp)
+
+	restored := restoreViaJSON(t, exp)
+
+	if !restored.IsEvaluated() {
+		t.Fatal("restored expression is not evaluated")
+	}
+
+	res, err := restored.GetResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res != 6 {
+		t.Errorf("got %v, want 6", res)
+	}
+}
+
+func TestResumeTask(t *testing.T) {
+	exp, err := calc.NewExpression("(1+2)*(3+4)", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	restored := restoreViaJSON(t, exp)
+
+	resumed, ok := restored.ResumeTask(task.Id, task.NodeID())
+	if !ok {
+		t.Fatal("failed to resume task")
+	}
+
+	if resumed.Id != task.Id {
+		t.Errorf("got task id %d, want %d", resumed.Id, task.Id)
+	}
+
+	a1, a2 := task.GetArguments()
+	b1, b2 := resumed.GetArguments()
+
+	if a1 != b1 || a2 != b2 || task.GetOperator() != resumed.GetOperator() {
+		t.Error("resumed task evaluates another node")
+	}
+
+	if _, ok = restored.ResumeTask(task.Id, task.NodeID()); ok {
+		t.Error("task was resumed twice")
+	}
+
+	if err = resumed.Complete(computeTask(t, resumed)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ := evaluateSequentially(t, restored)
+	if res != 21 {
+		t.Errorf("got %v, want 21", res)
+	}
+}
+
+func TestRestoreInvalidState(t *testing.T) {
+	missing := 7
+
+	_, err := calc.RestoreExpression(1, calc.State{
+		Root: 0,
+		Nodes: []calc.NodeState{
+			{
+				ID:       0,
+				Operator: "+",
+				Left:     calc.OperandState{Node: &missing},
+				Right:    calc.OperandState{},
+			},
+		},
+	}, calc.NewIDSeries(0))
+	if !errors.Is(err, calc.ErrInvalidState) {
+		t.Errorf("got error %v, want %v", err, calc.ErrInvalidState)
+	}
+}
@@ -0,0 +1,75 @@
+package calc
+
+import "time"
+
+// Stats describes the part of an expression that is yet to be evaluated.
+type Stats struct {
+	// Nodes is the number of distinct operations, i.e. tasks.
+	Nodes int
+	// Depth is the number of operations on the longest chain.
+	Depth int
+	// CriticalPath is the cost of the most expensive chain of operations,
+	// the lower bound of the evaluation time with unlimited workers.
+	CriticalPath time.Duration
+	// TotalWork is the cost of all operations,
+	// the evaluation time with a single worker.
+	TotalWork time.Duration
+}
+
+func (e *Expression) Stats(cost CostFunc) Stats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.Root.isProcessed {
+		return Stats{}
+	}
+
+	type nodeStats struct {
+		depth        int
+		criticalPath time.Duration
+	}
+
+	var stats Stats
+
+	computed := make(map[*BinaryOp]nodeStats)
+
+	var compute func(node *BinaryOp) nodeStats
+
+	// Спускаемся к листьям: оценка узла зависит только от оценок детей
+	compute = func(node *BinaryOp) nodeStats {
+		if ns, ok := computed[node]; ok {
+			return ns
+		}
+
+		var longest nodeStats
+
+		for _, child := range []Node{node.left, node.right} {
+			if childOp, ok := child.(*BinaryOp); ok {
+				cs := compute(childOp)
+				longest.depth = max(longest.depth, cs.depth)
+				longest.criticalPath = max(
+					longest.criticalPath,
+					cs.criticalPath,
+				)
+			}
+		}
+
+		nodeCost := cost(node.operator)
+		ns := nodeStats{
+			depth:        longest.depth + 1,
+			criticalPath: longest.criticalPath + nodeCost,
+		}
+		computed[node] = ns
+
+		stats.Nodes++
+		stats.TotalWork += nodeCost
+
+		return ns
+	}
+
+	root := compute(e.Root)
+	stats.Depth = root.depth
+	stats.CriticalPath = root.criticalPath
+
+	return stats
+}
@@ -0,0 +1,70 @@
+package calc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func TestStats(t *testing.T) {
+	cost := func(operator string) time.Duration {
+		if operator == "*" {
+			return 2 * time.Second
+		}
+
+		return time.Second
+	}
+
+	testCases := []struct {
+		expression string
+		expected   calc.Stats
+	}{
+		{
+			expression: "1",
+			expected:   calc.Stats{},
+		},
+		{
+			expression: "1+2",
+			expected: calc.Stats{
+				Nodes:        1,
+				Depth:        1,
+				CriticalPath: time.Second,
+				TotalWork:    time.Second,
+			},
+		},
+		{
+			expression: "(1+2)*(3+4)-5",
+			expected: calc.Stats{
+				Nodes:        4,
+				Depth:        3,
+				CriticalPath: 4 * time.Second,
+				TotalWork:    5 * time.Second,
+			},
+		},
+		{
+			// Общее подвыражение учитывается один раз
+			expression: "(1+2)*(1+2)",
+			expected: calc.Stats{
+				Nodes:        2,
+				Depth:        2,
+				CriticalPath: 3 * time.Second,
+				TotalWork:    3 * time.Second,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			exp, err := calc.NewExpression(testCase.expression, calc.NewIDSeries(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := exp.Stats(cost)
+			if got != testCase.expected {
+				t.Errorf("got %+v, want %+v", got, testCase.expected)
+			}
+		})
+	}
+}
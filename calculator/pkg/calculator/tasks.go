@@ -8,27 +8,47 @@ import (
 	"time"
 )
 
-var taskIdSeries = atomic.Uint64{}
+// IDSource hands out ids of new tasks. The ids must be unique
+// among the tasks that are evaluated together.
+type IDSource interface {
+	NextID() uint64
+}
+
+// IDSeries is an IDSource counting up from the given id.
+type IDSeries struct {
+	last atomic.Uint64
+}
+
+func NewIDSeries(last uint64) *IDSeries {
+	s := &IDSeries{}
+	s.last.Store(last)
+
+	return s
+}
+
+func (s *IDSeries) NextID() uint64 {
+	return s.last.Add(1)
+}
 
 type Task struct {
 	Id          uint64
 	expression  *Expression
-	node        *operatorNode
+	node        *BinaryOp
 	IsCompleted bool
 	IsCanceled  bool
 	mu          sync.Mutex
 }
 
-func newTask(node *operatorNode, exp *Expression) *Task {
+func newTask(node *BinaryOp, exp *Expression) *Task {
 	return &Task{
-		Id:         taskIdSeries.Add(1),
+		Id:         exp.taskIDs.NextID(),
 		expression: exp,
 		node:       node,
 	}
 }
 
 func (t *Task) GetArguments() (float64, float64) {
-	return t.node.left.(*numberNode).value, t.node.right.(*numberNode).value
+	return t.node.left.(*Literal).value, t.node.right.(*Literal).value
 }
 
 func (t *Task) GetOperator() string {
@@ -51,16 +71,25 @@ func (t *Task) Complete(result float64) error {
 		return ErrTaskIsCanceled
 	}
 
-	// Найти родителя и заменить текущий узел на numberNode
-	if t.node.parent != nil {
-		if t.node.parent.left == t.node {
-			t.node.parent.left = &numberNode{value: result}
-		} else if t.node.parent.right == t.node {
-			t.node.parent.right = &numberNode{value: result}
-		}
-	} else {
+	t.expression.mu.Lock()
+	defer t.expression.mu.Unlock()
+
+	resultNode := &Literal{value: result}
+
+	if len(t.node.parents) == 0 {
 		// Это корневой узел, заменяем его содержимое
-		*t.node = operatorNode{left: &numberNode{value: result}, isProcessed: true}
+		*t.node = BinaryOp{left: resultNode, isProcessed: true}
+	}
+
+	// Заменяем текущий узел на Literal у всех родителей
+	for _, parent := range t.node.parents {
+		if parent.left == t.node {
+			parent.left = resultNode
+		}
+
+		if parent.right == t.node {
+			parent.right = resultNode
+		}
 	}
 
 	t.IsCompleted = true
@@ -80,12 +109,22 @@ func (t *Task) Cancel() error {
 		return errors.New("task is already canceled")
 	}
 
+	t.expression.mu.Lock()
+	defer t.expression.mu.Unlock()
+
 	t.IsCanceled = true
 	t.node.isProcessing = false
 
 	return nil
 }
 
+// Compute evaluates the task in place without sending it to an agent.
+func (t *Task) Compute() (float64, error) {
+	left, right := t.GetArguments()
+
+	return compute(left, right, t.node.operator)
+}
+
 func compute(left, right float64, operator string) (float64, error) {
 	var result float64
 
@@ -109,47 +148,61 @@ func compute(left, right float64, operator string) (float64, error) {
 	return result, nil
 }
 
-var ExpressionIdSeries = atomic.Uint64{}
-
 type Expression struct {
 	Id           uint64
-	Root         *operatorNode
+	Root         *BinaryOp
 	IsProcessing bool
 	IsFailed     bool
+	taskIDs      IDSource // Выдает id задачам выражения
 	mu           sync.RWMutex
 }
 
-func NewExpression(expression string) (*Expression, error) {
-	tokens, err := Tokenize(expression)
+// NewExpression parses the expression. Its tasks take ids from taskIDs,
+// the id of the expression itself is up to the caller.
+func NewExpression(
+	expression string,
+	taskIDs IDSource,
+) (*Expression, error) {
+	ast, err := Parse(expression)
 	if err != nil {
 		return nil, err
 	}
 
-	// Переводим токены в обратную польскую нотацию (RPN)
-	rpnOrganizedTokens := shuntingYard(tokens)
-	// Составляем абстрактное синтаксическое дерево
-	ast := buildAST(rpnOrganizedTokens)
+	// Одинаковые подвыражения вычисляем один раз
+	ast = buildDAG(ast)
 
-	var root *operatorNode
+	var root *BinaryOp
 
 	switch n := ast.(type) {
-	case *operatorNode:
+	case *BinaryOp:
 		root = n
-	case *numberNode:
-		root = &operatorNode{left: &numberNode{value: n.value}, isProcessed: true}
+	case *Literal:
+		root = &BinaryOp{left: &Literal{value: n.value}, isProcessed: true}
 	}
 
 	return &Expression{
-		Id:   ExpressionIdSeries.Add(1),
-		Root: root,
+		Root:    root,
+		taskIDs: taskIDs,
 	}, nil
 }
 
 func (e *Expression) String() string {
+	if e.Root.isProcessed {
+		return fmt.Sprintf("( #%d %s )", e.Id, e.Root.left.String())
+	}
+
 	return fmt.Sprintf("( #%d %s )", e.Id, e.Root.String())
 }
 
 func (e *Expression) GetNextTask() (*Task, bool) {
+	return e.GetNextTaskFor(nil)
+}
+
+// GetNextTaskFor returns the next ready task whose operator is accepted.
+// A nil accept function accepts any operator.
+func (e *Expression) GetNextTaskFor(
+	accept func(operator string) bool,
+) (*Task, bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -157,7 +210,7 @@ func (e *Expression) GetNextTask() (*Task, bool) {
 		return nil, false
 	}
 
-	node, ok := e.Root.nextReadyForProcessingNode()
+	node, ok := e.Root.nextReadyForProcessingNode(accept)
 	if !ok {
 		return nil, false
 	}
@@ -186,13 +239,20 @@ func (e *Expression) GetResult() (float64, error) {
 		return 0, fmt.Errorf("expressions is not evaluated")
 	}
 
-	if resultNode, ok := e.Root.left.(*numberNode); ok {
+	if resultNode, ok := e.Root.left.(*Literal); ok {
 		return resultNode.value, nil
 	}
 
 	return 0, errors.New("expression result node is not a Number")
 }
 
+func (e *Expression) HasFailed() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.IsFailed
+}
+
 func (e *Expression) MarkAsFailed() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
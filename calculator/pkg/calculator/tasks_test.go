@@ -0,0 +1,65 @@
+package calc_test
+
+import (
+	"testing"
+
+	"github.com/dzherb/go_calculator/calculator/pkg/calculator"
+)
+
+func TestGetNextTaskFor(t *testing.T) {
+	exp, err := calc.NewExpression("2*3+10/4", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onlyDivision := func(operator string) bool {
+		return operator == "/"
+	}
+
+	task, ok := exp.GetNextTaskFor(onlyDivision)
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if task.GetOperator() != "/" {
+		t.Fatalf("got operator %s, want /", task.GetOperator())
+	}
+
+	if _, ok = exp.GetNextTaskFor(onlyDivision); ok {
+		t.Fatal("expected no more division tasks")
+	}
+
+	res, err := task.Compute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res != 2.5 {
+		t.Errorf("got %f, want 2.5", res)
+	}
+
+	task, ok = exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if task.GetOperator() != "*" {
+		t.Errorf("got operator %s, want *", task.GetOperator())
+	}
+}
+
+func TestTaskComputeDivisionByZero(t *testing.T) {
+	exp, err := calc.NewExpression("1/0", calc.NewIDSeries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, ok := exp.GetNextTask()
+	if !ok {
+		t.Fatal("expected a task")
+	}
+
+	if _, err = task.Compute(); err == nil {
+		t.Error("expected error")
+	}
+}
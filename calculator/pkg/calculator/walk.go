@@ -0,0 +1,40 @@
+package calc
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the syntax tree in depth-first order:
+// it starts by calling v.Visit(node); node must not be nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	if op, ok := node.(*BinaryOp); ok {
+		Walk(v, op.left)
+		Walk(v, op.right)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect traverses the syntax tree in depth-first order: it starts by
+// calling f(node); if f returns true, Inspect invokes f recursively
+// for each of the children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -5,4 +5,6 @@ import "time"
 type Config struct {
 	SecretKey      string
 	AccessTokenTTL time.Duration
+	// Now returns the current time, time.Now is used if it is nil.
+	Now func() time.Time
 }
@@ -1,4 +1,3 @@
 package security
 
-var SecretKey = &secretKey
 var TimeToFloat64 = timeToFloat64
@@ -7,34 +7,47 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var secretKey []byte
+// TokenManager issues access tokens and validates them.
+// Tokens are signed with the secret key of the manager.
+type TokenManager struct {
+	secretKey      []byte
+	accessTokenTTL time.Duration
+	now            func() time.Time
+}
 
-var accessTokenTTL time.Duration
+func NewTokenManager(cfg Config) *TokenManager {
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
+	}
 
-func Init(cfg Config) {
-	secretKey = []byte(cfg.SecretKey)
-	accessTokenTTL = cfg.AccessTokenTTL
+	return &TokenManager{
+		secretKey:      []byte(cfg.SecretKey),
+		accessTokenTTL: cfg.AccessTokenTTL,
+		now:            now,
+	}
 }
 
-func IssueAccessToken(userID uint64) (string, error) {
-	now := time.Now().UTC()
+func (m *TokenManager) IssueAccessToken(userID uint64) (string, error) {
+	now := m.now().UTC()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"iat": timeToFloat64(now),
 		"sub": strconv.FormatUint(userID, 10),
-		"exp": timeToFloat64(now.Add(accessTokenTTL)),
+		"exp": timeToFloat64(now.Add(m.accessTokenTTL)),
 	})
 
-	return token.SignedString(secretKey)
+	return token.SignedString(m.secretKey)
 }
 
-func ValidateToken(tokenString string) (uint64, error) {
+func (m *TokenManager) ValidateToken(tokenString string) (uint64, error) {
 	token, err := jwt.Parse(
 		tokenString,
 		func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
+			return m.secretKey, nil
 		},
 		jwt.WithIssuedAt(),
 		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(m.now),
 	)
 
 	if err != nil {
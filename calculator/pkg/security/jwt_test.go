@@ -1,7 +1,6 @@
 package security_test
 
 import (
-	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -16,23 +15,25 @@ type token struct {
 	exp float64
 }
 
-func TestMain(m *testing.M) {
-	security.Init(security.Config{
-		SecretKey:      "secret",
+const secretKey = "secret"
+
+func newTokenManager() *security.TokenManager {
+	return security.NewTokenManager(security.Config{
+		SecretKey:      secretKey,
 		AccessTokenTTL: time.Hour,
 	})
-
-	os.Exit(m.Run())
 }
 
 func TestTokenIssue(t *testing.T) {
-	token, err := security.IssueAccessToken(23)
+	tm := newTokenManager()
+
+	token, err := tm.IssueAccessToken(23)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	userID, err := security.ValidateToken(token)
+	userID, err := tm.ValidateToken(token)
 	if err != nil {
 		t.Error(err)
 		return
@@ -44,6 +45,8 @@ func TestTokenIssue(t *testing.T) {
 }
 
 func TestTokenValidation(t *testing.T) {
+	tm := newTokenManager()
+
 	cases := []struct {
 		token   token
 		isValid bool
@@ -88,13 +91,13 @@ func TestTokenValidation(t *testing.T) {
 			"sub": c.token.sub,
 			"exp": c.token.exp,
 		}).
-			SignedString(*security.SecretKey)
+			SignedString([]byte(secretKey))
 
 		if err != nil {
 			t.Error(err)
 		}
 
-		userID, err := security.ValidateToken(tokenEncoded)
+		userID, err := tm.ValidateToken(tokenEncoded)
 		if err != nil {
 			if c.isValid {
 				t.Error("unexpected error:", err)
@@ -119,3 +122,41 @@ func TestTokenValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenManagersAreIndependent(t *testing.T) {
+	token, err := newTokenManager().IssueAccessToken(23)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := security.NewTokenManager(security.Config{
+		SecretKey:      "other secret",
+		AccessTokenTTL: time.Hour,
+	})
+
+	_, err = other.ValidateToken(token)
+	if err == nil {
+		t.Error("token signed with another key is valid")
+	}
+}
+
+func TestTokenManagerClock(t *testing.T) {
+	now := time.Now()
+	tm := security.NewTokenManager(security.Config{
+		SecretKey:      secretKey,
+		AccessTokenTTL: time.Hour,
+		Now:            func() time.Time { return now },
+	})
+
+	token, err := tm.IssueAccessToken(23)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	_, err = tm.ValidateToken(token)
+	if err == nil {
+		t.Error("expired token is valid")
+	}
+}
@@ -4,6 +4,7 @@ import {useAuthentication} from "@/composables.js";
 const BASE_URL = import.meta.env.VITE_BACKEND_BASE_URL ?? ''
 const NO_RESPONSE_FROM_SERVER_MESSAGE = 'no response body from the server'
 const UNKNOWN_ERROR_MESSAGE = 'something went wrong...'
+const EXPRESSIONS_PAGE_SIZE = 1000
 
 export const EXPRESSION_STATUS = {
   NEW: 'new',
@@ -73,9 +74,26 @@ export const api = {
       expressions: []
     }
 
-    const response = await apiFetch(`${BASE_URL}/api/v1/expressions`)
+    const expressions = []
+    let cursor = null
 
-    return {...schema, ...response}
+    // Сервер отдает историю страницами, собираем ее целиком
+    do {
+      const params = new URLSearchParams({limit: EXPRESSIONS_PAGE_SIZE})
+      if (cursor !== null) {
+        params.set('cursor', cursor)
+      }
+
+      const response = await apiFetch(`${BASE_URL}/api/v1/expressions?${params}`)
+      if (response.error) {
+        return {...schema, ...response}
+      }
+
+      expressions.push(...(response.expressions ?? []))
+      cursor = response.nextCursor ?? null
+    } while (cursor !== null)
+
+    return {...schema, expressions}
   },
 
   async login({username, password}) {